@@ -0,0 +1,275 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/cobra"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+const apiKeyCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+var (
+	apiKeyName       string
+	apiKeyNamespace  string
+	apiKeyID         string
+	apiKeyLength     int
+	apiKeyData       string
+	apiKeyDataFile   string
+	apiKeyPublicKey  string
+	apiKeyPrivateKey string
+	apiKeyOutFile    string
+)
+
+func init() {
+	for _, c := range []*cobra.Command{apiKeyGenerateCmd, apiKeyEncryptCmd} {
+		c.Flags().StringVarP(&apiKeyPublicKey, "public-key", "k", "", "Path to a PEM-encoded RSA or EC public key to encrypt the API key with.")
+		c.Flags().StringVarP(&apiKeyName, "name", "n", "", "Name of the ApiKey resource.")
+		c.Flags().StringVar(&apiKeyNamespace, "namespace", "default", "Namespace of the ApiKey resource.")
+		c.Flags().StringVar(&apiKeyID, "key-id", "", "ID of the decryption key that the API key is encrypted for.")
+		c.Flags().StringVarP(&apiKeyOutFile, "out-file", "o", "", "Path to write the ApiKey manifest to. Defaults to stdout.")
+	}
+	apiKeyGenerateCmd.Flags().IntVarP(&apiKeyLength, "length", "l", 32, "Length of the generated API key.")
+	apiKeyEncryptCmd.Flags().StringVarP(&apiKeyData, "data", "d", "", "API key to encrypt. Read from --data-file or stdin if not specified.")
+	apiKeyEncryptCmd.Flags().StringVarP(&apiKeyDataFile, "data-file", "f", "", "Path to a file containing the API key to encrypt.")
+	apiKeyDecryptCmd.Flags().StringVarP(&apiKeyPrivateKey, "private-key", "k", "", "Path to a PEM-encoded RSA or EC private key to decrypt the API key with.")
+	apiKeyDecryptCmd.Flags().StringVarP(&apiKeyData, "data", "d", "", "Hex encoded encrypted API key or ApiKey manifest. Read from --data-file or stdin if not specified.")
+	apiKeyDecryptCmd.Flags().StringVarP(&apiKeyDataFile, "data-file", "f", "", "Path to a file containing a hex encoded encrypted API key or an ApiKey manifest.")
+
+	apiKeyCmd.AddCommand(apiKeyGenerateCmd, apiKeyEncryptCmd, apiKeyDecryptCmd)
+	RootCmd.AddCommand(apiKeyCmd)
+}
+
+var apiKeyCmd = &cobra.Command{
+	Use:   `apikey`,
+	Short: `manage API keys`,
+	Long:  `generate, encrypt and decrypt API keys and ApiKey manifests`,
+}
+
+var apiKeyGenerateCmd = &cobra.Command{
+	Use:   `generate`,
+	Short: `generate a random API key`,
+	Long:  `generate a random API key and an ApiKey manifest containing it encrypted with a public key`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := generateAPIKey(apiKeyLength)
+		if err != nil {
+			return err
+		}
+		if err := writeAPIKeyManifest(data); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Here is your api key (you will only see this once): %s\n", data)
+		return nil
+	},
+}
+
+var apiKeyEncryptCmd = &cobra.Command{
+	Use:   `encrypt`,
+	Short: `encrypt an existing API key`,
+	Long:  `create an ApiKey manifest containing an existing API key encrypted with a public key`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := readAPIKeyInput(apiKeyData, apiKeyDataFile)
+		if err != nil {
+			return err
+		}
+		return writeAPIKeyManifest(data)
+	},
+}
+
+var apiKeyDecryptCmd = &cobra.Command{
+	Use:   `decrypt`,
+	Short: `decrypt an API key`,
+	Long:  `decrypt the API key in an ApiKey manifest or hex encoded cipher text with a private key`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := spec.LoadDecryptionKeyFile(apiKeyPrivateKey)
+		if err != nil {
+			return err
+		}
+		input, err := readAPIKeyInput(apiKeyData, apiKeyDataFile)
+		if err != nil {
+			return err
+		}
+		data, err := decryptAPIKey(key, input)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, data)
+		return nil
+	},
+}
+
+// generateAPIKey returns a random alphanumeric API key
+func generateAPIKey(length int) (string, error) {
+	if length < 1 {
+		return "", errors.New("length must be positive")
+	}
+	max := big.NewInt(int64(len(apiKeyCharacters)))
+	key := make([]byte, length)
+	for i := range key {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		key[i] = apiKeyCharacters[n.Int64()]
+	}
+	return string(key), nil
+}
+
+// readAPIKeyInput returns the given value, the contents of the given
+// file or, if neither is given, the contents of stdin
+func readAPIKeyInput(value, path string) (string, error) {
+	switch {
+	case value != "" && path != "":
+		return "", errors.New("only one of --data and --data-file may be specified")
+	case value != "":
+		return value, nil
+	case path != "":
+		data, err := ioutil.ReadFile(path)
+		return strings.TrimSpace(string(data)), err
+	default:
+		data, err := ioutil.ReadAll(os.Stdin)
+		return strings.TrimSpace(string(data)), err
+	}
+}
+
+func writeAPIKeyManifest(data string) error {
+	if apiKeyName == "" {
+		return errors.New("name must be specified")
+	}
+	pub, err := loadPublicKey(apiKeyPublicKey)
+	if err != nil {
+		return err
+	}
+	manifest, err := createAPIKeyManifest(pub, data, apiKeyName, apiKeyNamespace, apiKeyID)
+	if err != nil {
+		return err
+	}
+	if apiKeyOutFile == "" {
+		_, err := out.Write(manifest)
+		return err
+	}
+	return ioutil.WriteFile(apiKeyOutFile, manifest, 0644)
+}
+
+// createAPIKeyManifest returns the YAML manifest of an ApiKey
+// containing an API key encrypted with a public key
+func createAPIKeyManifest(pub crypto.PublicKey, data, name, namespace, keyID string) ([]byte, error) {
+	cipherText, err := spec.EncryptAPIKey(pub, []byte(data))
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(spec.APIKey{
+		TypeMeta: unversioned.TypeMeta{
+			Kind:       "ApiKey",
+			APIVersion: "kanali.io/v1",
+		},
+		ObjectMeta: api.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: hex.EncodeToString(cipherText),
+			KeyID:      keyID,
+		},
+	})
+}
+
+// decryptAPIKey decrypts an API key from either an
+// ApiKey manifest or hex encoded cipher text
+func decryptAPIKey(key spec.DecryptionKey, input string) (string, error) {
+	cipherHex := input
+	apiKey := spec.APIKey{}
+	if err := yaml.Unmarshal([]byte(input), &apiKey); err == nil && apiKey.Spec.APIKeyData != "" {
+		cipherHex = apiKey.Spec.APIKeyData
+	}
+	cipherText, err := hex.DecodeString(cipherHex)
+	if err != nil {
+		return "", err
+	}
+	keyring := &spec.KeyringFactory{}
+	keyring.Set(key)
+	data, err := keyring.Decrypt("", cipherText)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// loadPublicKey loads a PEM-encoded PKIX or PKCS#1 public key
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return parsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+}
+
+// pkcs1PublicKey is the ASN.1 structure of a PKCS#1 RSA public key
+type pkcs1PublicKey struct {
+	N *big.Int
+	E int
+}
+
+// parsePKCS1PublicKey parses a DER encoded PKCS#1 RSA public key.
+// x509.ParsePKCS1PublicKey is not available in the Go version
+// Kanali is built with, so the structure is decoded directly.
+func parsePKCS1PublicKey(der []byte) (*rsa.PublicKey, error) {
+	pub := pkcs1PublicKey{}
+	rest, err := asn1.Unmarshal(der, &pub)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after PKCS#1 public key")
+	}
+	if pub.N == nil || pub.N.Sign() <= 0 || pub.E <= 0 {
+		return nil, errors.New("PKCS#1 public key has an invalid modulus or exponent")
+	}
+	return &rsa.PublicKey{N: pub.N, E: pub.E}, nil
+}
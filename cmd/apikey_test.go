@@ -0,0 +1,157 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyCmdInit(t *testing.T) {
+	assert.Equal(t, RootCmd.Commands()[0], apiKeyCmd)
+	assert.Equal(t, len(apiKeyCmd.Commands()), 3)
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	assert := assert.New(t)
+
+	one, err := generateAPIKey(32)
+	assert.Nil(err)
+	assert.Equal(32, len(one))
+	two, _ := generateAPIKey(32)
+	assert.NotEqual(one, two)
+	for _, c := range one {
+		assert.Contains(apiKeyCharacters, string(c))
+	}
+
+	_, err = generateAPIKey(0)
+	assert.NotNil(err)
+}
+
+func TestAPIKeyManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	for _, priv := range []interface{}{rsaKey, ecKey} {
+		var pub interface{}
+		switch priv := priv.(type) {
+		case *rsa.PrivateKey:
+			pub = &priv.PublicKey
+		case *ecdsa.PrivateKey:
+			pub = &priv.PublicKey
+		}
+
+		manifest, err := createAPIKeyManifest(pub, "i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9", "my-api-key", "foo", "new")
+		assert.Nil(err)
+
+		apiKey := spec.APIKey{}
+		assert.Nil(yaml.Unmarshal(manifest, &apiKey))
+		assert.Equal("ApiKey", apiKey.TypeMeta.Kind)
+		assert.Equal("kanali.io/v1", apiKey.TypeMeta.APIVersion)
+		assert.Equal("my-api-key", apiKey.ObjectMeta.Name)
+		assert.Equal("foo", apiKey.ObjectMeta.Namespace)
+		assert.Equal("new", apiKey.Spec.KeyID)
+
+		data, err := decryptAPIKey(spec.DecryptionKey{Key: priv}, string(manifest))
+		assert.Nil(err)
+		assert.Equal("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9", data)
+
+		data, err = decryptAPIKey(spec.DecryptionKey{Key: priv}, apiKey.Spec.APIKeyData)
+		assert.Nil(err, "hex encoded cipher text should be decrypted")
+		assert.Equal("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9", data)
+	}
+
+	_, err := decryptAPIKey(spec.DecryptionKey{Key: rsaKey}, "foo")
+	assert.NotNil(err)
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "kanali")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pkix, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pkcs1, _ := asn1.Marshal(pkcs1PublicKey{N: rsaKey.PublicKey.N, E: rsaKey.PublicKey.E})
+
+	ioutil.WriteFile(filepath.Join(dir, "pkix.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "pkcs1.pem"), pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: pkcs1}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "trailing.pem"), pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: append(pkcs1, 0)}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "foo.pem"), []byte("foo"), 0600)
+
+	pub, err := loadPublicKey(filepath.Join(dir, "pkix.pem"))
+	assert.Nil(err)
+	assert.Equal(&rsaKey.PublicKey, pub)
+	pub, err = loadPublicKey(filepath.Join(dir, "pkcs1.pem"))
+	assert.Nil(err)
+	assert.Equal(&rsaKey.PublicKey, pub)
+	_, err = loadPublicKey(filepath.Join(dir, "trailing.pem"))
+	assert.NotNil(err)
+	_, err = loadPublicKey(filepath.Join(dir, "foo.pem"))
+	assert.NotNil(err)
+	_, err = loadPublicKey(filepath.Join(dir, "missing.pem"))
+	assert.NotNil(err)
+}
+
+func TestReadAPIKeyInput(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ioutil.TempFile("", "kanali")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+	f.WriteString("foo\n")
+	f.Close()
+
+	data, err := readAPIKeyInput("", f.Name())
+	assert.Nil(err)
+	assert.Equal("foo", data)
+
+	data, err = readAPIKeyInput("bar", "")
+	assert.Nil(err)
+	assert.Equal("bar", data)
+
+	data, err = readAPIKeyInput(f.Name(), "")
+	assert.Nil(err)
+	assert.Equal(f.Name(), data, "data should never be read as a path")
+
+	_, err = readAPIKeyInput("bar", f.Name())
+	assert.Equal("only one of --data and --data-file may be specified", err.Error())
+
+	_, err = readAPIKeyInput("", f.Name()+".missing")
+	assert.NotNil(err)
+}
@@ -21,19 +21,23 @@
 package cmd
 
 import (
-	"crypto/x509"
-	"encoding/pem"
-	"io/ioutil"
+	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/northwesternmutual/kanali/config"
 	"github.com/northwesternmutual/kanali/controller"
+	"github.com/northwesternmutual/kanali/handlers"
 	"github.com/northwesternmutual/kanali/monitor"
+	"github.com/northwesternmutual/kanali/plugins"
 	"github.com/northwesternmutual/kanali/server"
 	"github.com/northwesternmutual/kanali/spec"
 	"github.com/northwesternmutual/kanali/tracer"
+	"github.com/northwesternmutual/kanali/traffic"
 	"github.com/opentracing/opentracing-go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -79,6 +83,12 @@ var startCmd = &cobra.Command{
 			logrus.SetLevel(level)
 		}
 
+		// default plugins that can not be parsed would otherwise be skipped
+		if err := spec.ValidateDefaultPlugins(); err != nil {
+			logrus.Fatal(err.Error())
+			os.Exit(1)
+		}
+
 		// create new k8s controller
 		ctlr, err := controller.New()
 		if err != nil {
@@ -86,11 +96,14 @@ var startCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// load decryption key into memory
-		if err := loadDecryptionKey(viper.GetString(config.FlagPluginsAPIKeyDecriptionKeyFile.GetLong())); err != nil {
+		// load decryption keys into memory
+		if err := loadDecryptionKeys(); err != nil {
 			logrus.Fatalf("could not load decryption key: %s", err.Error())
 			os.Exit(1)
 		}
+		if interval := viper.GetDuration(config.FlagPluginsAPIKeyDecriptionKeyReloadInterval.GetLong()); interval > 0 {
+			go reloadDecryptionKeys(interval)
+		}
 
 		// create tprs
 		if err := ctlr.CreateTPRs(); err != nil {
@@ -99,8 +112,19 @@ var startCmd = &cobra.Command{
 		}
 
 		go ctlr.Watch()
+		go ctlr.RevalidatePlugins(viper.GetDuration(config.FlagPluginsRevalidateInterval.GetLong()))
+		go plugins.RunHostSweeper(viper.GetDuration(config.FlagPluginsHostSweepInterval.GetLong()))
+		go logExpiringAPIKeys(viper.GetDuration(config.FlagPluginsAPIKeyExpiryWarningWindow.GetLong()))
+
+		startTime := time.Now()
+
+		go traffic.RunPeerDiscovery()
 
 		// start UDP server
+		if err := traffic.ValidateSelfIP(); err != nil {
+			logrus.Fatal(err.Error())
+			os.Exit(1)
+		}
 		go func() {
 			if err := server.StartUDPServer(); err != nil {
 				logrus.Fatal(err.Error())
@@ -108,6 +132,31 @@ var startCmd = &cobra.Command{
 			}
 		}()
 
+		// start health server
+		if viper.GetInt(config.FlagServerHealthPort.GetLong()) > 0 {
+			go func() {
+				if err := server.StartHealthServer(); err != nil {
+					logrus.Fatal(err.Error())
+					os.Exit(1)
+				}
+			}()
+		}
+
+		// restore and periodically persist traffic
+		snapshotStore, err := getTrafficSnapshotStore(ctlr)
+		if err != nil {
+			logrus.Fatalf("could not create traffic snapshot store: %s", err.Error())
+			os.Exit(1)
+		}
+		if snapshotStore != nil {
+			if err := traffic.RestoreSnapshot(snapshotStore, startTime); err != nil {
+				logrus.Warnf("could not restore traffic snapshot: %s", err.Error())
+			}
+			go traffic.RunSnapshots(snapshotStore, viper.GetDuration(config.FlagServerTrafficSnapshotInterval.GetLong()), nil)
+		}
+		go traffic.RunPruning(viper.GetDuration(config.FlagServerTrafficPruneInterval.GetLong()), nil)
+		go cleanUpOnExit(snapshotStore)
+
 		tracer, closer, err := tracer.Jaeger()
 		if err != nil {
 			logrus.Warnf("error create Jaeger tracer: %s", err.Error())
@@ -132,28 +181,97 @@ var startCmd = &cobra.Command{
 			}()
 		}
 
+		handlers.SetReady(true)
 		server.Start(influxCtlr)
 
 	},
 }
 
-func loadDecryptionKey(location string) error {
+func getTrafficSnapshotStore(ctlr *controller.Controller) (traffic.SnapshotStore, error) {
+	switch store := viper.GetString(config.FlagServerTrafficSnapshotStore.GetLong()); store {
+	case "":
+		return nil, nil
+	case "file":
+		return traffic.FileSnapshotStore{Path: viper.GetString(config.FlagServerTrafficSnapshotFile.GetLong())}, nil
+	case "configmap":
+		instance, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		return ctlr.NewConfigMapSnapshotStore(
+			viper.GetString(config.FlagServerTrafficSnapshotConfigMapNamespace.GetLong()),
+			viper.GetString(config.FlagServerTrafficSnapshotConfigMapName.GetLong()),
+			instance,
+		), nil
+	default:
+		return nil, fmt.Errorf("unsupported traffic snapshot store %s", store)
+	}
+}
+
+// cleanUpOnExit persists a final traffic snapshot, if a store is
+// configured, and closes every loaded plugin before Kanali is
+// terminated, for example during a rolling deploy
+func cleanUpOnExit(store traffic.SnapshotStore) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	if store != nil {
+		if err := traffic.SaveSnapshot(store); err != nil {
+			logrus.Errorf("could not save traffic snapshot: %s", err.Error())
+		}
+	}
+	plugins.Registry.Close()
+	os.Exit(0)
+}
 
-	// read in private key
-	keyBytes, err := ioutil.ReadFile(location)
-	if err != nil {
-		return err
+// loadDecryptionKeys loads every decryption key from the configured
+// directory or, if no directory is configured, the configured file
+func loadDecryptionKeys() error {
+	if dir := viper.GetString(config.FlagPluginsAPIKeyDecriptionKeyDir.GetLong()); dir != "" {
+		keys, err := spec.LoadDecryptionKeyDir(dir)
+		if err != nil {
+			return err
+		}
+		spec.APIKeyDecryptionKeys.Set(keys...)
+		return nil
 	}
-	// create a pem block from the private key provided
-	block, _ := pem.Decode(keyBytes)
-	// parse the pem block into a private key
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	key, err := spec.LoadDecryptionKeyFile(viper.GetString(config.FlagPluginsAPIKeyDecriptionKeyFile.GetLong()))
 	if err != nil {
 		return err
 	}
+	spec.APIKeyDecryptionKeys.Set(key)
+	return nil
+}
+
+// reloadDecryptionKeys periodically reloads the decryption keys so that
+// keys can be rotated without a restart. If the keys can not be loaded,
+// the previously loaded keys continue to be used.
+func reloadDecryptionKeys(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := loadDecryptionKeys(); err != nil {
+			logrus.Warnf("could not reload decryption keys: %s", err.Error())
+			continue
+		}
+		controller.RetryUndecryptedAPIKeys()
+	}
+}
 
-	spec.APIKeyDecryptionKey = privateKey
+// expiryCheckStartupDelay gives the watchers time to
+// discover existing APIKeys before they are first checked
+const expiryCheckStartupDelay = 30 * time.Second
 
-	return nil
+// logExpiringAPIKeys logs every APIKey that will expire within a window
+// shortly after startup and then every hour
+func logExpiringAPIKeys(window time.Duration) {
+	time.Sleep(expiryCheckStartupDelay)
+	checkExpiringAPIKeys(window)
+	for range time.Tick(time.Hour) {
+		checkExpiringAPIKeys(window)
+	}
+}
 
+func checkExpiringAPIKeys(window time.Duration) {
+	for _, key := range spec.KeyStore.ExpiringWithin(window, time.Now()) {
+		logrus.Warnf("APIKey named %s in namespace %s expires at %s", key.ObjectMeta.Name, key.ObjectMeta.Namespace, key.Spec.ExpiresAt.Time.Format(time.RFC3339))
+	}
 }
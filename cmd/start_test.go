@@ -27,6 +27,6 @@ import (
 )
 
 func TestStartCmdInit(t *testing.T) {
-	assert.Equal(t, len(RootCmd.Commands()), 2)
-	assert.Equal(t, RootCmd.Commands()[0], startCmd)
+	assert.Equal(t, len(RootCmd.Commands()), 3)
+	assert.Equal(t, RootCmd.Commands()[1], startCmd)
 }
@@ -28,8 +28,8 @@ import (
 )
 
 func TestVersionCmdInit(t *testing.T) {
-	assert.Equal(t, len(RootCmd.Commands()), 2)
-	assert.Equal(t, RootCmd.Commands()[1], versionCmd)
+	assert.Equal(t, len(RootCmd.Commands()), 3)
+	assert.Equal(t, RootCmd.Commands()[2], versionCmd)
 }
 
 func TestVersionCmdRun(t *testing.T) {
@@ -23,7 +23,25 @@ package config
 func init() {
 	Flags.Add(
 		FlagPluginsLocation,
+		FlagPluginsExternalTimeout,
+		FlagPluginsExternalMaxBodySize,
+		FlagPluginsExternalTLSCaFile,
+		FlagPluginsExternalTLSCertFile,
+		FlagPluginsExternalTLSKeyFile,
+		FlagPluginsDefaultChain,
+		FlagPluginsNamespaceDefaultChains,
+		FlagPluginsRevalidateInterval,
+		FlagPluginsMaxAbandonedCalls,
+		FlagPluginsHostMaxEntries,
+		FlagPluginsHostSweepInterval,
 		FlagPluginsAPIKeyDecriptionKeyFile,
+		FlagPluginsAPIKeyDecriptionKeyDir,
+		FlagPluginsAPIKeyDecriptionKeyReloadInterval,
+		FlagPluginsAPIKeyHeaderKey,
+		FlagPluginsAPIKeyQueryParam,
+		FlagPluginsAPIKeyExpiryWarningWindow,
+		FlagPluginsAPIKeySignatureClockSkew,
+		FlagPluginsAPIKeySignatureMaxBodySize,
 	)
 }
 
@@ -35,6 +53,83 @@ var (
 		Value: "/",
 		Usage: "Location of custom plugins shared object (.so) files.",
 	}
+	// FlagPluginsExternalTimeout sets the default timeout of each call to an external plugin
+	FlagPluginsExternalTimeout = Flag{
+		Long:  "plugins.external_timeout",
+		Short: "",
+		Value: "0h0m1s",
+		Usage: "Default timeout of each call to an external plugin. Can be overridden by an APIProxy.",
+	}
+	// FlagPluginsExternalMaxBodySize sets the largest body that is sent to an external plugin
+	FlagPluginsExternalMaxBodySize = Flag{
+		Long:  "plugins.external_max_body_size",
+		Short: "",
+		Value: 4194304,
+		Usage: "Largest request or response body in bytes that is sent to an external plugin. Larger requests are rejected with a 413 and larger responses with a 502. Unlimited if not positive.",
+	}
+	// FlagPluginsExternalTLSCaFile specifies the path to the x509 certificate authority bundle used to verify external plugins
+	FlagPluginsExternalTLSCaFile = Flag{
+		Long:  "plugins.external_tls_ca_file",
+		Short: "",
+		Value: "",
+		Usage: "Path to x509 certificate authority bundle used to verify external plugins that use TLS. The system roots are used if not set.",
+	}
+	// FlagPluginsExternalTLSCertFile specifies the path to the x509 client certificate presented to external plugins
+	FlagPluginsExternalTLSCertFile = Flag{
+		Long:  "plugins.external_tls_cert_file",
+		Short: "",
+		Value: "",
+		Usage: "Path to x509 client certificate presented to external plugins that use TLS.",
+	}
+	// FlagPluginsExternalTLSKeyFile specifies the path to the x509 private key matching --plugins.external_tls_cert_file
+	FlagPluginsExternalTLSKeyFile = Flag{
+		Long:  "plugins.external_tls_key_file",
+		Short: "",
+		Value: "",
+		Usage: "Path to x509 private key matching --plugins.external_tls_cert_file.",
+	}
+	// FlagPluginsDefaultChain sets the plugins that are used by every APIProxy
+	FlagPluginsDefaultChain = Flag{
+		Long:  "plugins.default_chain",
+		Short: "",
+		Value: []string{},
+		Usage: "Plugins used by every APIProxy, in order, before the plugins of the APIProxy itself. Each plugin is specified as name or name:version, or in a configuration file as a table with the fields of an APIProxy plugin and an optional mandatory field.",
+	}
+	// FlagPluginsNamespaceDefaultChains sets the plugins that are used by every APIProxy in a namespace
+	FlagPluginsNamespaceDefaultChains = Flag{
+		Long:  "plugins.namespace_default_chains",
+		Short: "",
+		Value: map[string]interface{}{},
+		Usage: "Plugins, by namespace, used by every APIProxy in the namespace after the default chain. Each chain is specified like the default chain or as a comma separated list of name or name:version.",
+	}
+	// FlagPluginsMaxAbandonedCalls sets how many timed out calls to a plugin may still be running
+	FlagPluginsMaxAbandonedCalls = Flag{
+		Long:  "plugins.max_abandoned_calls",
+		Short: "",
+		Value: 10,
+		Usage: "Largest number of calls to a plugin that may still be running after they timed out or were cancelled. Further calls to the plugin are rejected with a 503 until some of them return. Unlimited if not positive.",
+	}
+	// FlagPluginsRevalidateInterval sets how often plugins that could not be loaded are retried
+	FlagPluginsRevalidateInterval = Flag{
+		Long:  "plugins.revalidate_interval",
+		Short: "",
+		Value: "0h0m30s",
+		Usage: "How often the plugins of an APIProxy that could not be loaded or configured are retried and the health of every other plugin is checked. Disabled if not positive.",
+	}
+	// FlagPluginsHostMaxEntries sets the number of keys each plugin may store in the host key/value store
+	FlagPluginsHostMaxEntries = Flag{
+		Long:  "plugins.host_max_entries",
+		Short: "",
+		Value: 10000,
+		Usage: "Maximum number of keys each plugin may store in the key/value store of the plugin host, and of counters it may use.",
+	}
+	// FlagPluginsHostSweepInterval sets how often expired plugin host state is removed
+	FlagPluginsHostSweepInterval = Flag{
+		Long:  "plugins.host_sweep_interval",
+		Short: "",
+		Value: "0h1m0s",
+		Usage: "How often expired keys and increments outside the window of their counter are removed from the plugin host. Sweeping is disabled if not positive.",
+	}
 	// FlagPluginsAPIKeyDecriptionKeyFile set the location of the decryption RSA key file to be used to decrypt incoming API keys.
 	FlagPluginsAPIKeyDecriptionKeyFile = Flag{
 		Long:  "plugins.apiKey.decryption_key_file",
@@ -42,4 +137,53 @@ var (
 		Value: "",
 		Usage: "Path to valid PEM-encoded private key that matches the public key used to encrypt API keys.",
 	}
+	// FlagPluginsAPIKeyDecriptionKeyDir sets the location of a directory of decryption keys to be used to decrypt incoming API keys.
+	FlagPluginsAPIKeyDecriptionKeyDir = Flag{
+		Long:  "plugins.apiKey.decryption_key_dir",
+		Short: "",
+		Value: "",
+		Usage: "Path to a directory of PEM-encoded PKCS#1, PKCS#8 or EC private keys used to decrypt API keys. Takes precedence over the decryption key file.",
+	}
+	// FlagPluginsAPIKeyDecriptionKeyReloadInterval sets how often decryption keys are reloaded
+	FlagPluginsAPIKeyDecriptionKeyReloadInterval = Flag{
+		Long:  "plugins.apiKey.decryption_key_reload_interval",
+		Short: "",
+		Value: "0h1m0s",
+		Usage: "How often decryption keys are reloaded. Set to zero to disable reloading.",
+	}
+	// FlagPluginsAPIKeyHeaderKey sets the name of the HTTP header that holds an API key
+	FlagPluginsAPIKeyHeaderKey = Flag{
+		Long:  "plugins.apiKey.header_key",
+		Short: "",
+		Value: "apikey",
+		Usage: "Name of the HTTP header that holds an API key. Can be overridden by an APIProxy.",
+	}
+	// FlagPluginsAPIKeyQueryParam sets the name of the query parameter that holds an API key
+	FlagPluginsAPIKeyQueryParam = Flag{
+		Long:  "plugins.apiKey.query_param",
+		Short: "",
+		Value: "",
+		Usage: "Name of the query parameter that holds an API key if the header is not present. Can be overridden by an APIProxy.",
+	}
+	// FlagPluginsAPIKeyExpiryWarningWindow sets how long before an API key expires that it is reported as expiring soon
+	FlagPluginsAPIKeyExpiryWarningWindow = Flag{
+		Long:  "plugins.apiKey.expiry_warning_window",
+		Short: "",
+		Value: "168h0m0s",
+		Usage: "How long before an API key expires that it is reported as expiring soon in logs and metrics.",
+	}
+	// FlagPluginsAPIKeySignatureClockSkew sets how far the timestamp of a signed request may differ from the current time
+	FlagPluginsAPIKeySignatureClockSkew = Flag{
+		Long:  "plugins.apiKey.signature_clock_skew",
+		Short: "",
+		Value: "0h5m0s",
+		Usage: "How far the timestamp of a signed request may differ from the current time.",
+	}
+	// FlagPluginsAPIKeySignatureMaxBodySize sets the largest request body that a signature is verified over
+	FlagPluginsAPIKeySignatureMaxBodySize = Flag{
+		Long:  "plugins.apiKey.signature_max_body_size",
+		Short: "",
+		Value: 1048576,
+		Usage: "Largest request body in bytes that a request signature is verified over. Larger signed requests are rejected. Unlimited if not positive.",
+	}
 )
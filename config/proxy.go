@@ -29,6 +29,15 @@ func init() {
 		FlagProxyMaskHeaderKeys,
 		FlagProxyTLSCommonNameValidation,
 		FlagProxyDefaultHeaderValues,
+		FlagProxyAdaptiveConcurrency,
+		FlagProxyAdaptiveConcurrencyMinLimit,
+		FlagProxyAdaptiveConcurrencyMaxLimit,
+		FlagProxyAdaptiveConcurrencyTargetLatency,
+		FlagProxyAdaptiveConcurrencyMaxQueue,
+		FlagProxyAdaptiveConcurrencyQueueTimeout,
+		FlagProxyJWKSRefreshInterval,
+		FlagProxyJWTClockSkew,
+		FlagProxyClientIdentityHeader,
 	)
 }
 
@@ -82,4 +91,67 @@ var (
 		Value: map[string]string{},
 		Usage: "Specifies the default values for HTTP headers to be used in dynamic service discovery.",
 	}
+	// FlagProxyAdaptiveConcurrency enables a global concurrency limit on upstream requests that adapts to observed upstream latency
+	FlagProxyAdaptiveConcurrency = Flag{
+		Long:  "proxy.adaptive_concurrency",
+		Short: "",
+		Value: false,
+		Usage: "Enables a global concurrency limit on upstream requests that adapts to observed upstream latency.",
+	}
+	// FlagProxyAdaptiveConcurrencyMinLimit sets the lowest value the adaptive concurrency limit will decrease to
+	FlagProxyAdaptiveConcurrencyMinLimit = Flag{
+		Long:  "proxy.adaptive_concurrency_min_limit",
+		Short: "",
+		Value: 10,
+		Usage: "Lowest value the adaptive concurrency limit will decrease to.",
+	}
+	// FlagProxyAdaptiveConcurrencyMaxLimit sets the highest value the adaptive concurrency limit will increase to
+	FlagProxyAdaptiveConcurrencyMaxLimit = Flag{
+		Long:  "proxy.adaptive_concurrency_max_limit",
+		Short: "",
+		Value: 1000,
+		Usage: "Highest value the adaptive concurrency limit will increase to.",
+	}
+	// FlagProxyAdaptiveConcurrencyTargetLatency sets the upstream latency above which the adaptive concurrency limit is decreased
+	FlagProxyAdaptiveConcurrencyTargetLatency = Flag{
+		Long:  "proxy.adaptive_concurrency_target_latency",
+		Short: "",
+		Value: "0h0m1s",
+		Usage: "Upstream latency above which the adaptive concurrency limit is decreased.",
+	}
+	// FlagProxyAdaptiveConcurrencyMaxQueue sets how many requests may wait for the adaptive concurrency limit
+	FlagProxyAdaptiveConcurrencyMaxQueue = Flag{
+		Long:  "proxy.adaptive_concurrency_max_queue",
+		Short: "",
+		Value: 100,
+		Usage: "Number of requests that may wait for the adaptive concurrency limit before being rejected.",
+	}
+	// FlagProxyAdaptiveConcurrencyQueueTimeout sets how long a request may wait for the adaptive concurrency limit
+	FlagProxyAdaptiveConcurrencyQueueTimeout = Flag{
+		Long:  "proxy.adaptive_concurrency_queue_timeout",
+		Short: "",
+		Value: "0h0m1s",
+		Usage: "How long a request may wait for the adaptive concurrency limit before being rejected.",
+	}
+	// FlagProxyJWKSRefreshInterval sets how long a JSON Web Key Set fetched from a URL is cached
+	FlagProxyJWKSRefreshInterval = Flag{
+		Long:  "proxy.jwks_refresh_interval",
+		Short: "",
+		Value: "0h10m0s",
+		Usage: "How long a JSON Web Key Set fetched from a URL is cached before it is refetched.",
+	}
+	// FlagProxyJWTClockSkew sets the clock skew allowed when validating the expiry and not before time of a JSON Web Token
+	FlagProxyJWTClockSkew = Flag{
+		Long:  "proxy.jwt_clock_skew",
+		Short: "",
+		Value: "0h1m0s",
+		Usage: "Clock skew allowed when validating the expiry and not before time of a JSON Web Token.",
+	}
+	// FlagProxyClientIdentityHeader sets the header that the identity of a verified client certificate is forwarded upstream in
+	FlagProxyClientIdentityHeader = Flag{
+		Long:  "proxy.client_identity_header",
+		Short: "",
+		Value: "X-Client-Identity",
+		Usage: "Name of the HTTP header that the identity of a verified client certificate is forwarded upstream in. An empty value disables forwarding.",
+	}
 )
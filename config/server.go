@@ -25,7 +25,25 @@ func init() {
 		FlagServerPort,
 		FlagServerBindAddress,
 		FlagServerPeerUDPPort,
+		FlagServerHealthPort,
 		FlagServerProxyProtocol,
+		FlagServerTrustedProxies,
+		FlagServerPeerDiscovery,
+		FlagServerPeerSelfIP,
+		FlagServerPeerEndpointsName,
+		FlagServerPeerEndpointsNamespace,
+		FlagServerPeerEndpointsSelector,
+		FlagServerPeerEndpointsPortName,
+		FlagServerPeerStaticAddresses,
+		FlagServerPeerDNSSRV,
+		FlagServerPeerDNSRefreshInterval,
+		FlagServerTrafficSnapshotStore,
+		FlagServerTrafficSnapshotFile,
+		FlagServerTrafficSnapshotConfigMapName,
+		FlagServerTrafficSnapshotConfigMapNamespace,
+		FlagServerTrafficSnapshotInterval,
+		FlagServerTrafficRetention,
+		FlagServerTrafficPruneInterval,
 	)
 }
 
@@ -51,6 +69,13 @@ var (
 		Value: 10001,
 		Usage: "Sets the port that all Kanali instances will communicate to each other over.",
 	}
+	// FlagServerHealthPort sets the port that liveness and readiness checks are served on
+	FlagServerHealthPort = Flag{
+		Long:  "server.health_port",
+		Short: "",
+		Value: 0,
+		Usage: "Port that liveness (/healthz) and readiness (/readyz) checks are served on. Set to zero to disable.",
+	}
 	// FlagServerProxyProtocol maintains the integrity of the remote client IP address when incoming traffic to Kanali includes the Proxy Protocol header
 	FlagServerProxyProtocol = Flag{
 		Long:  "server.proxy_protocol",
@@ -58,4 +83,123 @@ var (
 		Value: false,
 		Usage: "Maintain the integrity of the remote client IP address when incoming traffic to Kanali includes the Proxy Protocol header.",
 	}
+	// FlagServerPeerDiscovery specifies how Kanali discovers its peers. Choose between 'endpoints', 'static', 'dns'
+	FlagServerPeerDiscovery = Flag{
+		Long:  "server.peer_discovery",
+		Short: "",
+		Value: "endpoints",
+		Usage: "Specifies how Kanali discovers its peers. Choose between 'endpoints', 'static', 'dns'.",
+	}
+	// FlagServerPeerSelfIP specifies the IP address of this Kanali instance. Defaults to the POD_IP environment variable
+	FlagServerPeerSelfIP = Flag{
+		Long:  "server.peer_self_ip",
+		Short: "",
+		Value: "",
+		Usage: "IP address of this Kanali instance. Defaults to the POD_IP environment variable.",
+	}
+	// FlagServerPeerEndpointsName specifies the name of the Kubernetes endpoints that represent all Kanali instances
+	FlagServerPeerEndpointsName = Flag{
+		Long:  "server.peer_endpoints_name",
+		Short: "",
+		Value: "kanali",
+		Usage: "Name of the Kubernetes endpoints that represent all Kanali instances.",
+	}
+	// FlagServerPeerEndpointsNamespace restricts peer discovery to Kubernetes endpoints in this namespace
+	FlagServerPeerEndpointsNamespace = Flag{
+		Long:  "server.peer_endpoints_namespace",
+		Short: "",
+		Value: "",
+		Usage: "Restricts peer discovery to Kubernetes endpoints in this namespace. Defaults to all namespaces.",
+	}
+	// FlagServerPeerEndpointsSelector specifies a label selector matching the Kubernetes endpoints that represent all Kanali instances
+	FlagServerPeerEndpointsSelector = Flag{
+		Long:  "server.peer_endpoints_selector",
+		Short: "",
+		Value: "",
+		Usage: "Label selector matching the Kubernetes endpoints that represent all Kanali instances. Takes precedence over --server.peer_endpoints_name.",
+	}
+	// FlagServerPeerEndpointsPortName specifies the name of the UDP endpoints port that Kanali instances communicate over
+	FlagServerPeerEndpointsPortName = Flag{
+		Long:  "server.peer_endpoints_port_name",
+		Short: "",
+		Value: "",
+		Usage: "Name of the UDP endpoints port that Kanali instances communicate over. Defaults to the first UDP port, then --server.peer_udp_port.",
+	}
+	// FlagServerPeerStaticAddresses specifies a static list of peer addresses in host or host:port form
+	FlagServerPeerStaticAddresses = Flag{
+		Long:  "server.peer_static_addresses",
+		Short: "",
+		Value: []string{},
+		Usage: "Static list of peer addresses in host or host:port form. Used when --server.peer_discovery is 'static'.",
+	}
+	// FlagServerPeerDNSSRV specifies the DNS SRV record that resolves to all Kanali instances
+	FlagServerPeerDNSSRV = Flag{
+		Long:  "server.peer_dns_srv",
+		Short: "",
+		Value: "",
+		Usage: "DNS SRV record that resolves to all Kanali instances. Used when --server.peer_discovery is 'dns'.",
+	}
+	// FlagServerPeerDNSRefreshInterval specifies how often the peer DNS SRV record is resolved
+	FlagServerPeerDNSRefreshInterval = Flag{
+		Long:  "server.peer_dns_refresh_interval",
+		Short: "",
+		Value: "0h0m30s",
+		Usage: "How often static and DNS SRV peers are resolved.",
+	}
+	// FlagServerTrustedProxies specifies the IP addresses and CIDR ranges of proxies whose X-Forwarded-For header is trusted
+	FlagServerTrustedProxies = Flag{
+		Long:  "server.trusted_proxies",
+		Short: "",
+		Value: []string{},
+		Usage: "IP addresses and CIDR ranges of proxies whose X-Forwarded-For header is trusted when computing the client IP.",
+	}
+	// FlagServerTrafficSnapshotStore specifies where traffic snapshots are persisted. Choose between 'file', 'configmap'
+	FlagServerTrafficSnapshotStore = Flag{
+		Long:  "server.traffic_snapshot_store",
+		Short: "",
+		Value: "",
+		Usage: "Where traffic snapshots are persisted so that quotas and rate limits survive restarts. Choose between 'file', 'configmap'. Disabled if empty.",
+	}
+	// FlagServerTrafficSnapshotFile specifies the file that traffic snapshots are persisted to
+	FlagServerTrafficSnapshotFile = Flag{
+		Long:  "server.traffic_snapshot_file",
+		Short: "",
+		Value: "/var/lib/kanali/traffic.json",
+		Usage: "File that traffic snapshots are persisted to when using the 'file' snapshot store.",
+	}
+	// FlagServerTrafficSnapshotConfigMapName specifies the name of the ConfigMap that traffic snapshots are persisted to
+	FlagServerTrafficSnapshotConfigMapName = Flag{
+		Long:  "server.traffic_snapshot_configmap_name",
+		Short: "",
+		Value: "kanali-traffic",
+		Usage: "Name of the ConfigMap that traffic snapshots are persisted to when using the 'configmap' snapshot store.",
+	}
+	// FlagServerTrafficSnapshotConfigMapNamespace specifies the namespace of the ConfigMap that traffic snapshots are persisted to
+	FlagServerTrafficSnapshotConfigMapNamespace = Flag{
+		Long:  "server.traffic_snapshot_configmap_namespace",
+		Short: "",
+		Value: "default",
+		Usage: "Namespace of the ConfigMap that traffic snapshots are persisted to when using the 'configmap' snapshot store.",
+	}
+	// FlagServerTrafficSnapshotInterval specifies how often traffic snapshots are persisted
+	FlagServerTrafficSnapshotInterval = Flag{
+		Long:  "server.traffic_snapshot_interval",
+		Short: "",
+		Value: "0h0m30s",
+		Usage: "How often traffic snapshots are persisted. Disabled if not positive.",
+	}
+	// FlagServerTrafficRetention specifies how long traffic points are kept
+	FlagServerTrafficRetention = Flag{
+		Long:  "server.traffic_retention",
+		Short: "",
+		Value: "1h0m0s",
+		Usage: "How long traffic is kept before it is pruned from the traffic store and its snapshots. Must be at least the largest rate limit window. Quotas still count pruned traffic. Never pruned if not positive.",
+	}
+	// FlagServerTrafficPruneInterval specifies how often traffic older than the retention period is pruned
+	FlagServerTrafficPruneInterval = Flag{
+		Long:  "server.traffic_prune_interval",
+		Short: "",
+		Value: "0h1m0s",
+		Usage: "How often traffic older than the retention period is pruned from the traffic store. Never pruned if not positive.",
+	}
 )
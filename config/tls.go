@@ -25,6 +25,7 @@ func init() {
 		FlagTLSCertFile,
 		FlagTLSKeyFile,
 		FlagTLSCaFile,
+		FlagTLSClientCertOptional,
 	)
 }
 
@@ -50,4 +51,11 @@ var (
 		Value: "",
 		Usage: "Path to x509 certificate authority bundle for mutual TLS.",
 	}
+	// FlagTLSClientCertOptional allows clients to connect without a certificate when mutual TLS is enabled
+	FlagTLSClientCertOptional = Flag{
+		Long:  "tls.client_cert_optional",
+		Short: "",
+		Value: false,
+		Usage: "Allow clients to connect without a certificate when --tls.ca_file is set. Certificates that are presented are still verified.",
+	}
 )
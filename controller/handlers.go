@@ -21,7 +21,12 @@
 package controller
 
 import (
+	"reflect"
+	"sync"
+
 	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/limiter"
+	"github.com/northwesternmutual/kanali/plugins"
 	"github.com/northwesternmutual/kanali/spec"
 	"k8s.io/kubernetes/pkg/api"
 )
@@ -32,12 +37,15 @@ type handlerFuncs interface {
 	deleteFunc(obj interface{})
 }
 
-type k8sEventHandler struct{}
+type k8sEventHandler struct {
+	status statusWriter
+}
 
 func (h k8sEventHandler) addFunc(obj interface{}) {
 	switch obj.(type) {
 	case spec.APIProxy:
 		if proxy, ok := obj.(spec.APIProxy); ok {
+			proxy.Status = h.validatePlugins(proxy)
 			err := spec.ProxyStore.Set(proxy)
 			if err != nil {
 				logrus.Errorf("could not add api proxy. skipping: %s", err.Error())
@@ -45,12 +53,15 @@ func (h k8sEventHandler) addFunc(obj interface{}) {
 		}
 	case spec.APIKey:
 		if key, ok := obj.(spec.APIKey); ok {
+			encrypted := key
 			if err := key.Decrypt(); err == nil {
+				undecryptedKeys.delete(key)
 				err := spec.KeyStore.Set(key)
 				if err != nil {
 					logrus.Errorf("could not add apikey. skipping: %s", err.Error())
 				}
 			} else {
+				undecryptedKeys.set(encrypted)
 				logrus.Error("could not decrypt apikey. skipping...")
 			}
 		}
@@ -77,8 +88,8 @@ func (h k8sEventHandler) addFunc(obj interface{}) {
 		}
 	case api.Endpoints:
 		if endpoints, ok := obj.(api.Endpoints); ok {
-			if endpoints.ObjectMeta.Name == "kanali" {
-				spec.KanaliEndpoints = &endpoints
+			if err := spec.KanaliEndpoints.Set(endpoints); err != nil {
+				logrus.Errorf("could not add endpoints. skipping: %s", err.Error())
 			}
 		}
 	case api.ConfigMap:
@@ -94,6 +105,7 @@ func (h k8sEventHandler) updateFunc(obj interface{}) {
 	switch obj.(type) {
 	case spec.APIProxy:
 		if proxy, ok := obj.(spec.APIProxy); ok {
+			proxy.Status = h.validatePlugins(proxy)
 			err := spec.ProxyStore.Update(proxy)
 			if err != nil {
 				logrus.Errorf("could not modify api proxy. skipping: %s", err.Error())
@@ -101,13 +113,20 @@ func (h k8sEventHandler) updateFunc(obj interface{}) {
 		}
 	case spec.APIKey:
 		if key, ok := obj.(spec.APIKey); ok {
+			encrypted := key
 			if err := key.Decrypt(); err == nil {
+				undecryptedKeys.delete(key)
 				err := spec.KeyStore.Update(key)
 				if err != nil {
 					logrus.Errorf("could not modify apikey. skipping: %s", err.Error())
 				}
 			} else {
-				logrus.Error("could not decrypt apikey. skipping...")
+				// the previous version of the key must not remain usable
+				if _, err := spec.KeyStore.Delete(encrypted); err != nil {
+					logrus.Errorf("could not delete apikey: %s", err.Error())
+				}
+				undecryptedKeys.set(encrypted)
+				logrus.Error("could not decrypt apikey. removing it until it can be decrypted...")
 			}
 		}
 	case spec.APIKeyBinding:
@@ -133,8 +152,8 @@ func (h k8sEventHandler) updateFunc(obj interface{}) {
 		}
 	case api.Endpoints:
 		if endpoints, ok := obj.(api.Endpoints); ok {
-			if endpoints.ObjectMeta.Name == "kanali" {
-				spec.KanaliEndpoints = &endpoints
+			if err := spec.KanaliEndpoints.Update(endpoints); err != nil {
+				logrus.Errorf("could not modify endpoints. skipping: %s", err.Error())
 			}
 		}
 	case api.ConfigMap:
@@ -154,16 +173,15 @@ func (h k8sEventHandler) deleteFunc(obj interface{}) {
 			if err != nil {
 				logrus.Errorf("could not delete api proxy. skipping: %s", err.Error())
 			}
+			limiter.Proxies.Delete(limiter.Name(proxy.ObjectMeta.Namespace, proxy.ObjectMeta.Name))
+			plugins.Registry.Release(proxy)
 		}
 	case spec.APIKey:
 		if key, ok := obj.(spec.APIKey); ok {
-			if err := key.Decrypt(); err == nil {
-				_, err := spec.KeyStore.Delete(key)
-				if err != nil {
-					logrus.Errorf("could not delete apikey. skipping: %s", err.Error())
-				}
-			} else {
-				logrus.Warn("could not decrypt apikey. skipping...")
+			undecryptedKeys.delete(key)
+			_, err := spec.KeyStore.Delete(key)
+			if err != nil {
+				logrus.Errorf("could not delete apikey. skipping: %s", err.Error())
 			}
 		}
 	case spec.APIKeyBinding:
@@ -187,6 +205,12 @@ func (h k8sEventHandler) deleteFunc(obj interface{}) {
 				logrus.Errorf("could not delete service. skipping: %s", err.Error())
 			}
 		}
+	case api.Endpoints:
+		if endpoints, ok := obj.(api.Endpoints); ok {
+			if _, err := spec.KanaliEndpoints.Delete(endpoints); err != nil {
+				logrus.Errorf("could not delete endpoints. skipping: %s", err.Error())
+			}
+		}
 	case api.ConfigMap:
 		if cm, ok := obj.(api.ConfigMap); ok {
 			if _, err := spec.MockResponseStore.Delete(cm); err != nil {
@@ -195,3 +219,62 @@ func (h k8sEventHandler) deleteFunc(obj interface{}) {
 		}
 	}
 }
+
+// undecryptedKeyStore holds the APIKeys that could not be decrypted by any
+// key in the keyring so that they can be retried once the keyring is reloaded
+type undecryptedKeyStore struct {
+	mutex sync.Mutex
+	keys  map[string]spec.APIKey
+}
+
+var undecryptedKeys = &undecryptedKeyStore{keys: map[string]spec.APIKey{}}
+
+func (s *undecryptedKeyStore) set(key spec.APIKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys[key.ObjectMeta.Namespace+"/"+key.ObjectMeta.Name] = key
+}
+
+func (s *undecryptedKeyStore) delete(key spec.APIKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.keys, key.ObjectMeta.Namespace+"/"+key.ObjectMeta.Name)
+}
+
+// RetryUndecryptedAPIKeys attempts to decrypt every APIKey that previously
+// could not be decrypted. It should be called whenever the keyring changes.
+func RetryUndecryptedAPIKeys() {
+	undecryptedKeys.mutex.Lock()
+	defer undecryptedKeys.mutex.Unlock()
+	for name, key := range undecryptedKeys.keys {
+		if err := key.Decrypt(); err != nil {
+			continue
+		}
+		delete(undecryptedKeys.keys, name)
+		if err := spec.KeyStore.Set(key); err != nil {
+			logrus.Errorf("could not add apikey. skipping: %s", err.Error())
+		}
+	}
+}
+
+// validatePlugins loads the plugins of an APIProxy and writes the
+// result to its resource if it differs from the status already there
+func (h k8sEventHandler) validatePlugins(proxy spec.APIProxy) spec.APIProxyStatus {
+	return h.reportStatus(proxy, plugins.Registry.Validate(proxy))
+}
+
+// reportStatus logs the plugins of an APIProxy that are not ready to be
+// used and writes its status to its resource if it has changed
+func (h k8sEventHandler) reportStatus(proxy spec.APIProxy, status spec.APIProxyStatus) spec.APIProxyStatus {
+	for _, plugin := range status.Plugins {
+		if !plugin.Loaded || plugin.Error != "" || plugin.Unhealthy != "" {
+			logrus.Errorf("api proxy %s in namespace %s uses plugin %s that is unavailable: %s", proxy.ObjectMeta.Name, proxy.ObjectMeta.Namespace, plugin.Name, plugin.GetReason())
+		}
+	}
+	if h.status != nil && !reflect.DeepEqual(proxy.Status, status) {
+		if err := h.status.writeStatus(proxy, status); err != nil {
+			logrus.Warnf("could not write status of api proxy %s in namespace %s: %s", proxy.ObjectMeta.Name, proxy.ObjectMeta.Namespace, err.Error())
+		}
+	}
+	return status
+}
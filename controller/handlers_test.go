@@ -26,7 +26,10 @@ import (
 	"encoding/pem"
 	"testing"
 
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/plugins"
 	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/kubernetes/pkg/api"
 )
@@ -129,7 +132,9 @@ func TestAddFunc(t *testing.T) {
 		},
 	}
 	handlers.addFunc(ep)
-	assert.Equal(t, *(spec.KanaliEndpoints), ep)
+	result, err = spec.KanaliEndpoints.Get("kanali", "bar")
+	assert.Nil(t, err)
+	assert.Equal(t, result, ep)
 
 	mockOne, _ := json.Marshal([]spec.Route{
 		{
@@ -259,7 +264,9 @@ func TestUpdateFunc(t *testing.T) {
 		},
 	}
 	handlers.updateFunc(ep)
-	assert.Equal(t, *(spec.KanaliEndpoints), ep)
+	result, err = spec.KanaliEndpoints.Get("kanali", "bar")
+	assert.Nil(t, err)
+	assert.Equal(t, result, ep)
 
 	mockOne, _ := json.Marshal([]spec.Route{
 		{
@@ -329,10 +336,10 @@ func TestDeleteFunc(t *testing.T) {
 
 	spec.KeyStore.Set(spec.APIKey{
 		ObjectMeta: api.ObjectMeta{
-			Name: "def456",
+			Name: "abc123",
 		},
 		Spec: spec.APIKeySpec{
-			APIKeyData: "i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9",
+			APIKeyData: spec.HashAPIKey("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9"),
 		},
 	})
 	assert.False(t, spec.KeyStore.IsEmpty())
@@ -408,6 +415,84 @@ func TestDeleteFunc(t *testing.T) {
 	assert.False(t, spec.MockResponseStore.IsEmpty())
 	handlers.deleteFunc(cm)
 	assert.True(t, spec.MockResponseStore.IsEmpty())
+
+	ep := api.Endpoints{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "kanali",
+			Namespace: "bar",
+		},
+	}
+	spec.KanaliEndpoints.Set(ep)
+	assert.False(t, spec.KanaliEndpoints.IsEmpty())
+	handlers.deleteFunc(ep)
+	assert.True(t, spec.KanaliEndpoints.IsEmpty())
+}
+
+func TestRetryUndecryptedAPIKeys(t *testing.T) {
+	clearAllStores()
+	defer clearAllStores()
+	defer spec.APIKeyDecryptionKeys.Clear()
+
+	handlers := k8sEventHandler{}
+	spec.APIKeyDecryptionKeys.Clear()
+
+	key := spec.APIKey{
+		ObjectMeta: api.ObjectMeta{
+			Name: "abc123",
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: "9210f613f32a54eca4601d199b81dda5a4f93c0540ee6a8b9634c2d4976b13399a03276820cd85a35b625a96ffdeffa2e094f1349e1ed7510afd7f0f904595f0f1bd8707170a46e6d366395456568323e4de71973977d872ab9aa733b35fbdeec279fc1f4bc147e242f414652bae8d46b7c53af76a1c37254096e4e0aa89dfdf86d599692ab74849bfedd7ecc6b4409b01d1e4d989cdd9ca6db7c1a90cd86086da7508f85186d938ab2922e862832eb07281e5934d417addaba0ddc43f57f3613ab0aff4f353fdadc1116f9dca10338562a842904eb7b3ab77b6f919ac244a8b8fa4d2634ac2f9bec60ee4631894e6b823dd200dc0c793f5d1dfc08b749b2bba",
+		},
+	}
+
+	handlers.addFunc(key)
+	assert.True(t, spec.KeyStore.IsEmpty(), "key should not be decryptable yet")
+	assert.Equal(t, 1, len(undecryptedKeys.keys))
+
+	RetryUndecryptedAPIKeys()
+	assert.True(t, spec.KeyStore.IsEmpty())
+
+	setDecryptionKey(t)
+	RetryUndecryptedAPIKeys()
+	assert.False(t, spec.KeyStore.IsEmpty(), "key should be decrypted once the keyring is reloaded")
+	assert.Equal(t, 0, len(undecryptedKeys.keys))
+
+	spec.APIKeyDecryptionKeys.Clear()
+	handlers.addFunc(key)
+	assert.Equal(t, 1, len(undecryptedKeys.keys))
+	handlers.deleteFunc(key)
+	assert.Equal(t, 0, len(undecryptedKeys.keys), "deleted keys should not be retried")
+}
+
+func TestAddFuncValidatesPlugins(t *testing.T) {
+	clearAllStores()
+	defer clearAllStores()
+	handlers := k8sEventHandler{}
+
+	proxy := spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path: "/api/v1/accounts",
+			Plugins: []spec.Plugin{
+				{Name: "missing", Version: "1.0.0"},
+			},
+		},
+	}
+	handlers.addFunc(proxy)
+	result, _ := spec.ProxyStore.Get("/api/v1/accounts")
+	assert.NotNil(t, result, "proxy should be admitted even if its plugins could not be loaded")
+	status := result.(spec.APIProxy).Status
+	assert.Equal(t, 1, len(status.Plugins))
+	assert.False(t, status.Plugins[0].Loaded)
+	assert.Equal(t, "missing", status.Plugins[0].Name)
+	assert.NotEqual(t, "", status.Plugins[0].Error)
+
+	handlers.updateFunc(proxy)
+	result, _ = spec.ProxyStore.Get("/api/v1/accounts")
+	assert.NotNil(t, result.(spec.APIProxy).Status.GetFailedPlugin())
 }
 
 func clearAllStores() {
@@ -417,6 +502,42 @@ func clearAllStores() {
 	spec.SecretStore.Clear()
 	spec.ServiceStore.Clear()
 	spec.MockResponseStore.Clear()
+	spec.KanaliEndpoints.Clear()
+	plugins.Registry.Clear()
+	undecryptedKeys.keys = map[string]spec.APIKey{}
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+}
+
+func TestUndecryptableAPIKey(t *testing.T) {
+	clearAllStores()
+	defer clearAllStores()
+	handlers := k8sEventHandler{}
+
+	key := spec.APIKey{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "abc123",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: spec.HashAPIKey("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9"),
+		},
+	}
+	undecryptable := key
+	undecryptable.Spec.APIKeyData = "encrypted"
+	undecryptable.Spec.Disabled = true
+
+	spec.KeyStore.Set(key)
+	handlers.deleteFunc(undecryptable)
+	assert.True(t, spec.KeyStore.IsEmpty(), "a key should be deleted even if it can not be decrypted")
+
+	spec.KeyStore.Set(key)
+	handlers.updateFunc(undecryptable)
+	result, _ := spec.KeyStore.Get("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9")
+	assert.Nil(t, result, "a key should be disabled even if its update can not be decrypted")
+	assert.Equal(t, 1, len(undecryptedKeys.keys))
+
+	handlers.deleteFunc(undecryptable)
+	assert.Equal(t, 0, len(undecryptedKeys.keys))
 }
 
 func setDecryptionKey(t *testing.T) {
@@ -456,5 +577,5 @@ I0teIby/h2MN7yPNjFgIq64JneHpKwEH04+qy/dWQafxrpBlf9tfwg==
 		assert.Fail(t, err.Error())
 	}
 
-	spec.APIKeyDecryptionKey = privateKey
+	spec.APIKeyDecryptionKeys.Set(spec.DecryptionKey{Key: privateKey})
 }
@@ -0,0 +1,120 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package controller
+
+import (
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+)
+
+// snapshotDataKeySuffix is the suffix of the ConfigMap data
+// keys that hold the traffic snapshot of a Kanali instance
+const snapshotDataKeySuffix = ".json"
+
+// snapshotUpdateAttempts is the number of times a ConfigMap update is
+// attempted when it conflicts with an update from another Kanali instance
+const snapshotUpdateAttempts = 3
+
+// ConfigMapSnapshotStore persists traffic snapshots to a Kubernetes
+// ConfigMap. Each Kanali instance saves its snapshot under its own key.
+type ConfigMapSnapshotStore struct {
+	clientSet internalclientset.Interface
+	namespace string
+	name      string
+	instance  string
+}
+
+// NewConfigMapSnapshotStore creates a snapshot store backed by the ConfigMap
+// with the given namespace and name that saves under the given instance name
+func (c *Controller) NewConfigMapSnapshotStore(namespace, name, instance string) *ConfigMapSnapshotStore {
+	return &ConfigMapSnapshotStore{
+		clientSet: c.ClientSet,
+		namespace: namespace,
+		name:      name,
+		instance:  instance,
+	}
+}
+
+// Load reads the snapshot of every Kanali instance from the ConfigMap
+func (s *ConfigMapSnapshotStore) Load() (map[string][]byte, error) {
+	cm, err := s.clientSet.Core().ConfigMaps(s.namespace).Get(s.name)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshots := map[string][]byte{}
+	for k, v := range cm.Data {
+		if strings.HasSuffix(k, snapshotDataKeySuffix) {
+			snapshots[k] = []byte(v)
+		}
+	}
+	return snapshots, nil
+}
+
+// Save writes the snapshot of this Kanali instance to
+// the ConfigMap, creating the ConfigMap if it does not exist
+func (s *ConfigMapSnapshotStore) Save(data []byte) error {
+	return s.update(func(cmData map[string]string) {
+		cmData[s.instance+snapshotDataKeySuffix] = string(data)
+	})
+}
+
+// Remove deletes a snapshot from the ConfigMap
+func (s *ConfigMapSnapshotStore) Remove(name string) error {
+	return s.update(func(cmData map[string]string) {
+		delete(cmData, name)
+	})
+}
+
+// update applies a change to the data of the ConfigMap. As every Kanali
+// instance updates the same ConfigMap, the update is retried on conflict.
+func (s *ConfigMapSnapshotStore) update(fn func(map[string]string)) (err error) {
+	for i := 0; i < snapshotUpdateAttempts; i++ {
+		var cm *api.ConfigMap
+		cm, err = s.clientSet.Core().ConfigMaps(s.namespace).Get(s.name)
+		if errors.IsNotFound(err) {
+			cmData := map[string]string{}
+			fn(cmData)
+			_, err = s.clientSet.Core().ConfigMaps(s.namespace).Create(&api.ConfigMap{
+				ObjectMeta: api.ObjectMeta{
+					Name:      s.name,
+					Namespace: s.namespace,
+				},
+				Data: cmData,
+			})
+		} else if err == nil {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			fn(cm.Data)
+			_, err = s.clientSet.Core().ConfigMaps(s.namespace).Update(cm)
+		}
+		if !errors.IsConflict(err) && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return err
+}
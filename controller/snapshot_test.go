@@ -0,0 +1,56 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+func TestConfigMapSnapshotStore(t *testing.T) {
+	assert := assert.New(t)
+
+	ctlr := &Controller{ClientSet: fake.NewSimpleClientset()}
+	one := ctlr.NewConfigMapSnapshotStore("foo", "kanali-traffic", "kanali-one")
+	two := ctlr.NewConfigMapSnapshotStore("foo", "kanali-traffic", "kanali-two")
+
+	data, err := one.Load()
+	assert.Nil(err, "a missing ConfigMap is not an error")
+	assert.Nil(data)
+
+	assert.Nil(one.Save([]byte("one")), "ConfigMap should be created")
+	data, err = one.Load()
+	assert.Nil(err)
+	assert.Equal(map[string][]byte{"kanali-one.json": []byte("one")}, data)
+
+	assert.Nil(two.Save([]byte("two")), "ConfigMap should be updated")
+	assert.Nil(one.Save([]byte("three")))
+	data, err = two.Load()
+	assert.Nil(err)
+	assert.Equal(map[string][]byte{"kanali-one.json": []byte("three"), "kanali-two.json": []byte("two")}, data, "instances should not overwrite each other")
+
+	assert.Nil(two.Remove("kanali-one.json"))
+	cm, err := ctlr.ClientSet.Core().ConfigMaps("foo").Get("kanali-traffic")
+	assert.Nil(err)
+	assert.Equal(map[string]string{"kanali-two.json": "two"}, cm.Data)
+}
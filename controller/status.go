@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/plugins"
+	"github.com/northwesternmutual/kanali/spec"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// statusWriter persists the status of an APIProxy
+// as observed by Kanali to its resource
+type statusWriter interface {
+	writeStatus(proxy spec.APIProxy, status spec.APIProxyStatus) error
+}
+
+// writeStatus patches the status of an APIProxy resource so that
+// plugins that could not be loaded are visible using kubectl
+func (c *Controller) writeStatus(proxy spec.APIProxy, status spec.APIProxyStatus) error {
+	var patch struct {
+		Status *spec.APIProxyStatus `json:"status"`
+	}
+	// an empty status would be merged with the existing one
+	// so it must be explicitly removed
+	if len(status.Plugins) > 0 {
+		patch.Status = &status
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return c.RestClient.Patch(api.MergePatchType).
+		AbsPath("apis/kanali.io/v1/namespaces", proxy.ObjectMeta.Namespace, "apiproxies", proxy.ObjectMeta.Name).
+		Body(data).
+		Do().
+		Error()
+}
+
+// RevalidatePlugins periodically retries the plugins of every APIProxy
+// that could not be loaded or configured when it was added or modified
+// and checks the health of the plugins of every other APIProxy. Requests
+// to an APIProxy are rejected until its plugins recover.
+func (c *Controller) RevalidatePlugins(interval time.Duration) {
+	if interval <= 0 {
+		logrus.Warn("plugin revalidation is disabled")
+		return
+	}
+	h := k8sEventHandler{status: c}
+	for range time.Tick(interval) {
+		h.revalidatePlugins()
+	}
+}
+
+func (h k8sEventHandler) revalidatePlugins() {
+	for _, proxy := range spec.ProxyStore.GetAll() {
+		if proxy.Status.GetFailedPlugin() != nil {
+			spec.ProxyStore.SetStatus(proxy, h.validatePlugins(proxy))
+			continue
+		}
+		status := plugins.Registry.CheckHealth(proxy)
+		if !reflect.DeepEqual(proxy.Status, status) {
+			spec.ProxyStore.SetStatus(proxy, h.reportStatus(proxy, status))
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/plugins"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+type fakeStatusWriter struct {
+	written []spec.APIProxyStatus
+}
+
+func (w *fakeStatusWriter) writeStatus(proxy spec.APIProxy, status spec.APIProxyStatus) error {
+	w.written = append(w.written, status)
+	return nil
+}
+
+type fakePlugin struct{}
+
+func (p fakePlugin) OnRequest(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, req *http.Request, span opentracing.Span) error {
+	return nil
+}
+
+func (p fakePlugin) OnResponse(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, req *http.Request, resp *http.Response, span opentracing.Span) error {
+	return nil
+}
+
+func TestRevalidatePlugins(t *testing.T) {
+	clearAllStores()
+	defer clearAllStores()
+	writer := &fakeStatusWriter{}
+	handlers := k8sEventHandler{status: writer}
+
+	plugin := spec.Plugin{Name: "missing", Version: "1.0.0"}
+	proxy := spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:    "/api/v1/accounts",
+			Plugins: []spec.Plugin{plugin},
+		},
+	}
+	handlers.addFunc(proxy)
+	assert.Equal(t, 1, len(writer.written), "a failed plugin should be written to the resource")
+	assert.NotNil(t, writer.written[0].GetFailedPlugin())
+
+	// the resource now holds the status that was written
+	proxy.Status = writer.written[0]
+	handlers.updateFunc(proxy)
+	assert.Equal(t, 1, len(writer.written), "an unchanged status should not be written again")
+
+	handlers.revalidatePlugins()
+	assert.Equal(t, 1, len(writer.written))
+	result, _ := spec.ProxyStore.Get("/api/v1/accounts")
+	assert.NotNil(t, result.(spec.APIProxy).Status.GetFailedPlugin())
+
+	assert.Nil(t, plugins.Registry.Set(plugin, fakePlugin{}))
+	handlers.revalidatePlugins()
+	assert.Equal(t, 2, len(writer.written), "a recovered plugin should be written to the resource")
+	assert.Nil(t, writer.written[1].GetFailedPlugin())
+	result, _ = spec.ProxyStore.Get("/api/v1/accounts")
+	assert.Nil(t, result.(spec.APIProxy).Status.GetFailedPlugin(), "requests should no longer be rejected")
+	assert.True(t, result.(spec.APIProxy).Status.Plugins[0].Loaded)
+}
+
+type fakeLifecyclePlugin struct {
+	fakePlugin
+	healthy error
+}
+
+func (p *fakeLifecyclePlugin) Init(config map[string]interface{}, logger *logrus.Entry) error {
+	return nil
+}
+
+func (p *fakeLifecyclePlugin) Close() error {
+	return nil
+}
+
+func (p *fakeLifecyclePlugin) Healthy() error {
+	return p.healthy
+}
+
+func TestRevalidatePluginHealth(t *testing.T) {
+	clearAllStores()
+	defer clearAllStores()
+	writer := &fakeStatusWriter{}
+	handlers := k8sEventHandler{status: writer}
+
+	lifecycle := &fakeLifecyclePlugin{}
+	plugin := spec.Plugin{Name: "lifecycle"}
+	assert.Nil(t, plugins.Registry.Set(plugin, lifecycle))
+	proxy := spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:    "/api/v1/accounts",
+			Plugins: []spec.Plugin{plugin},
+		},
+	}
+	other := spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyTwo",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path: "/api/v1/users",
+		},
+	}
+	handlers.addFunc(proxy)
+	handlers.addFunc(other)
+	assert.Equal(t, 1, len(writer.written))
+	assert.Nil(t, writer.written[0].GetFailedPlugin())
+
+	handlers.revalidatePlugins()
+	assert.Equal(t, 1, len(writer.written), "an unchanged status should not be written again")
+
+	lifecycle.healthy = errors.New("connection refused")
+	handlers.revalidatePlugins()
+	assert.Equal(t, 2, len(writer.written), "an unhealthy plugin should be written to the resource")
+	assert.Equal(t, "connection refused", writer.written[1].Plugins[0].Unhealthy)
+	result, _ := spec.ProxyStore.Get("/api/v1/accounts")
+	assert.Equal(t, "unhealthy: connection refused", result.(spec.APIProxy).Status.GetFailedPlugin().GetReason())
+	result, _ = spec.ProxyStore.Get("/api/v1/users")
+	assert.Nil(t, result.(spec.APIProxy).Status.GetFailedPlugin(), "proxies that do not use the plugin should not be affected")
+
+	lifecycle.healthy = nil
+	handlers.revalidatePlugins()
+	assert.Equal(t, 3, len(writer.written), "a recovered plugin should be written to the resource")
+	result, _ = spec.ProxyStore.Get("/api/v1/accounts")
+	assert.Nil(t, result.(spec.APIProxy).Status.GetFailedPlugin())
+}
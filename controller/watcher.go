@@ -68,7 +68,7 @@ func (c *Controller) Watch() {
 
 	// start a go return that will monitor for
 	// new events that are sent through the channel
-	go monitor(eventCh, k8sEventHandler{})
+	go monitor(eventCh, k8sEventHandler{status: c})
 
 	// start listening for events and put
 	// them on the channel
@@ -76,6 +76,7 @@ func (c *Controller) Watch() {
 	go c.watchResource(eventCh, "apis/kanali.io/v1/apikeybindings?watch=true")
 	go c.watchResource(eventCh, "apis/kanali.io/v1/apiproxies?watch=true")
 	go c.watchResource(eventCh, "api/v1/secrets?fieldSelector=type%3Dkubernetes.io/tls&watch=true")
+	go c.watchResource(eventCh, "api/v1/secrets?labelSelector=kanali.io%2Fjwks%3Dtrue&watch=true")
 	go c.watchResource(eventCh, "api/v1/services?watch=true")
 	go c.watchResource(eventCh, "api/v1/configmaps?watch=true")
 	go c.watchResource(eventCh, "api/v1/endpoints?watch=true")
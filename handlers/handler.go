@@ -26,16 +26,17 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
 	"github.com/northwesternmutual/kanali/metrics"
 	"github.com/northwesternmutual/kanali/monitor"
 	"github.com/northwesternmutual/kanali/spec"
 	"github.com/northwesternmutual/kanali/tracer"
 	"github.com/northwesternmutual/kanali/utils"
 	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
 )
 
 // Handler is used to provide additional parameters to an HTTP handler
@@ -54,7 +55,7 @@ func (h Handler) serveHTTP(w http.ResponseWriter, r *http.Request) {
 			metrics.Metric{Name: "total_time", Value: int(time.Now().Sub(t0) / time.Millisecond), Index: false},
 			metrics.Metric{Name: "http_method", Value: r.Method, Index: false},
 			metrics.Metric{Name: "http_uri", Value: utils.ComputeURLPath(r.URL), Index: false},
-			metrics.Metric{Name: "client_ip", Value: strings.Split(r.RemoteAddr, ":")[0], Index: false},
+			metrics.Metric{Name: "client_ip", Value: utils.ComputeClientIP(r, viper.GetStringSlice(config.FlagServerTrustedProxies.GetLong())), Index: false},
 		)
 		go func() {
 			if err := h.InfluxController.WriteRequestData(m); err != nil {
@@ -71,7 +72,7 @@ func (h Handler) serveHTTP(w http.ResponseWriter, r *http.Request) {
 
 	tracer.HydrateSpanFromRequest(r, sp)
 
-	err := h.H(context.Background(), &spec.APIProxy{}, m, w, r, sp)
+	err := h.H(r.Context(), &spec.APIProxy{}, m, w, r, sp)
 	if err == nil {
 		return
 	}
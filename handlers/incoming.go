@@ -45,6 +45,10 @@ func IncomingRequest(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metri
 
 	f.Add(
 		steps.ValidateProxyStep{},
+		steps.IPFilterStep{},
+		steps.APIKeyStep{},
+		steps.JWTStep{},
+		steps.RateLimitStep{},
 		steps.PluginsOnRequestStep{},
 	)
 	if viper.GetBool(config.FlagProxyEnableMockResponses.GetLong()) && mockIsDefined(utils.ComputeURLPath(r.URL)) {
@@ -60,6 +64,11 @@ func IncomingRequest(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metri
 
 	err := f.Play(ctx, proxy, m, w, r, futureResponse, trace)
 
+	// closing the response releases the concurrency slot of the request
+	if futureResponse.Body != nil {
+		futureResponse.Body.Close()
+	}
+
 	return err
 
 }
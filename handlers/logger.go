@@ -22,10 +22,11 @@ package handlers
 
 import (
 	"net/http"
-	"strings"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
 	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
 )
 
 // Logger creates a custom http.Handler that logs details around a request
@@ -38,7 +39,7 @@ func Logger(inner Handler) http.Handler {
 		inner.serveHTTP(w, r)
 
 		logrus.WithFields(logrus.Fields{
-			"client ip": strings.Split(r.RemoteAddr, ":")[0],
+			"client ip": utils.ComputeClientIP(r, viper.GetStringSlice(config.FlagServerTrustedProxies.GetLong())),
 			"method":    r.Method,
 			"uri":       utils.ComputeURLPath(r.URL),
 		}).Info("request details")
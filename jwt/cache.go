@@ -0,0 +1,141 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jwt
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval bounds how often a key set is refetched
+// when a token is signed by a key that is not in the key set
+const minRefreshInterval = 30 * time.Second
+
+// maxKeySetSize bounds the size of a fetched key set
+const maxKeySetSize = 1 << 20
+
+type keySetEntry struct {
+	mutex   sync.Mutex
+	set     *KeySet
+	fetched time.Time
+	failed  time.Time
+	err     error
+}
+
+// KeySetCache fetches and caches JSON Web Key Sets by URL. It is safe for concurrent use.
+type KeySetCache struct {
+	mutex   sync.Mutex
+	client  *http.Client
+	ttl     time.Duration
+	entries map[string]*keySetEntry
+}
+
+// NewKeySetCache creates a cache that refetches key sets once they are older than ttl
+func NewKeySetCache(client *http.Client, ttl time.Duration) *KeySetCache {
+	return &KeySetCache{
+		client:  client,
+		ttl:     ttl,
+		entries: map[string]*keySetEntry{},
+	}
+}
+
+// Get returns the key set at a URL, fetching it if it is not cached or
+// has expired. If it can not be fetched, an expired key set is returned.
+func (c *KeySetCache) Get(url string) (*KeySet, error) {
+	return c.get(url, c.ttl)
+}
+
+// Refresh refetches the key set at a URL so that rotated keys are
+// discovered. Key sets fetched very recently are not refetched.
+func (c *KeySetCache) Refresh(url string) (*KeySet, error) {
+	return c.get(url, minRefreshInterval)
+}
+
+// SetTTL updates how long key sets are cached for
+func (c *KeySetCache) SetTTL(ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ttl = ttl
+}
+
+func (c *KeySetCache) get(url string, maxAge time.Duration) (*KeySet, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[url]
+	if !ok {
+		entry = &keySetEntry{}
+		c.entries[url] = entry
+	}
+	c.mutex.Unlock()
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	// failed fetches are not retried immediately, even if
+	// nothing has been fetched yet, so that an unavailable
+	// issuer is not sent a request for every token
+	if time.Now().Sub(entry.failed) < minRefreshInterval {
+		if entry.set != nil {
+			return entry.set, nil
+		}
+		return nil, entry.err
+	}
+	if entry.set != nil && time.Now().Sub(entry.fetched) < maxAge {
+		return entry.set, nil
+	}
+
+	set, err := c.fetch(url)
+	if err != nil {
+		entry.failed = time.Now()
+		entry.err = err
+		if entry.set != nil {
+			return entry.set, nil
+		}
+		return nil, err
+	}
+	entry.set = set
+	entry.fetched = time.Now()
+	entry.failed = time.Time{}
+	entry.err = nil
+	return set, nil
+}
+
+func (c *KeySetCache) fetch(url string) (*KeySet, error) {
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching key set from %s returned a %d status code", url, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKeySetSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeySetSize {
+		return nil, fmt.Errorf("key set at %s exceeds %d bytes", url, maxKeySetSize)
+	}
+	return ParseKeySet(data)
+}
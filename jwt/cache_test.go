@@ -0,0 +1,103 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySetCache(t *testing.T) {
+	assert := assert.New(t)
+
+	keyOne, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keyTwo, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var requests int32
+	var failing int32
+	current := encodeKeySet(rsaJWK("one", "", &keyOne.PublicKey))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(current)
+	}))
+	defer server.Close()
+
+	cache := NewKeySetCache(http.DefaultClient, time.Hour)
+
+	set, err := cache.Get(server.URL)
+	assert.Nil(err)
+	assert.Equal("one", set.Keys[0].ID)
+	set, err = cache.Get(server.URL)
+	assert.Nil(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&requests), "key set should have been cached")
+
+	current = encodeKeySet(rsaJWK("two", "", &keyTwo.PublicKey))
+	set, _ = cache.Refresh(server.URL)
+	assert.Equal("one", set.Keys[0].ID, "recently fetched key sets should not be refetched")
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+
+	cache.SetTTL(0)
+	set, err = cache.Get(server.URL)
+	assert.Nil(err)
+	assert.Equal("two", set.Keys[0].ID, "expired key sets should be refetched")
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+
+	atomic.StoreInt32(&failing, 1)
+	set, err = cache.Get(server.URL)
+	assert.Nil(err)
+	assert.Equal("two", set.Keys[0].ID, "an expired key set should be used when it can not be refetched")
+	assert.Equal(int32(3), atomic.LoadInt32(&requests))
+	cache.Get(server.URL)
+	assert.Equal(int32(3), atomic.LoadInt32(&requests), "failed fetches should not be retried immediately")
+
+	empty := NewKeySetCache(http.DefaultClient, time.Hour)
+	_, err = empty.Get(server.URL)
+	assert.Contains(err.Error(), "returned a 500 status code")
+	assert.Equal(int32(4), atomic.LoadInt32(&requests))
+	_, err = empty.Get(server.URL)
+	assert.Contains(err.Error(), "returned a 500 status code")
+	_, err = empty.Refresh(server.URL)
+	assert.Contains(err.Error(), "returned a 500 status code")
+	assert.Equal(int32(4), atomic.LoadInt32(&requests), "failed fetches should not be retried immediately when nothing has been fetched")
+}
+
+func TestKeySetCacheMaxSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxKeySetSize+1))
+	}))
+	defer server.Close()
+
+	_, err := NewKeySetCache(http.DefaultClient, time.Hour).Get(server.URL)
+	assert.Equal(t, fmt.Sprintf("key set at %s exceeds %d bytes", server.URL, maxKeySetSize), err.Error())
+}
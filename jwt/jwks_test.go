@@ -0,0 +1,80 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeySet(t *testing.T) {
+	assert := assert.New(t)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	encryptionKey := rsaJWK("enc", "", &rsaKey.PublicKey)
+	encryptionKey.Use = "enc"
+
+	set, err := ParseKeySet(encodeKeySet(
+		rsaJWK("one", "RS256", &rsaKey.PublicKey),
+		ecJWK("two", "P-384", &ecKey.PublicKey),
+		encryptionKey,
+		JWK{KeyType: "oct", KeyID: "three"},
+		ecJWK("four", "P-999", &ecKey.PublicKey),
+		ecJWK("five", "P-256", &ecKey.PublicKey),
+	))
+	assert.Nil(err)
+	assert.Equal(2, len(set.Keys))
+	assert.Equal("one", set.Keys[0].ID)
+	assert.Equal("RS256", set.Keys[0].Algorithm)
+	assert.Equal(rsaKey.PublicKey.N, set.Keys[0].PublicKey.(*rsa.PublicKey).N)
+	assert.Equal(rsaKey.PublicKey.E, set.Keys[0].PublicKey.(*rsa.PublicKey).E)
+	assert.Equal("two", set.Keys[1].ID)
+	assert.Equal(ecKey.PublicKey.X, set.Keys[1].PublicKey.(*ecdsa.PublicKey).X)
+
+	_, err = ParseKeySet(encodeKeySet(encryptionKey))
+	assert.Equal("key set contains no usable keys", err.Error())
+
+	_, err = ParseKeySet([]byte("not json"))
+	assert.NotNil(err)
+}
+
+func TestKeySetFind(t *testing.T) {
+	assert := assert.New(t)
+
+	set := &KeySet{Keys: []Key{
+		{ID: "one", Algorithm: "RS256"},
+		{ID: "two"},
+		{ID: "three", Algorithm: "ES256"},
+	}}
+
+	assert.Equal([]Key{{ID: "one", Algorithm: "RS256"}}, set.Find("one", "RS256"))
+	assert.Equal([]Key{}, set.Find("one", "RS512"))
+	assert.Equal([]Key{{ID: "two"}}, set.Find("two", "PS256"))
+	assert.Equal([]Key{}, set.Find("four", "RS256"))
+	assert.Equal([]Key{{ID: "one", Algorithm: "RS256"}, {ID: "two"}}, set.Find("", "RS256"))
+}
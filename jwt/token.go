@@ -0,0 +1,266 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // register hash functions used by signing algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMalformed is returned when a token is not a valid compact JWS
+	ErrMalformed = errors.New("malformed token")
+	// ErrKeyNotFound is returned when no key in the key set matches a token
+	ErrKeyNotFound = errors.New("no matching key found")
+	// ErrInvalidSignature is returned when a token's signature can not be verified
+	ErrInvalidSignature = errors.New("invalid token signature")
+	// ErrExpired is returned when a token has expired
+	ErrExpired = errors.New("token has expired")
+	// ErrMissingExpiry is returned when a token does not have an expiry
+	ErrMissingExpiry = errors.New("token does not have an expiry")
+	// ErrNotYetValid is returned when a token is used before its not before time
+	ErrNotYetValid = errors.New("token is not yet valid")
+	// ErrInvalidIssuer is returned when a token was not issued by the expected issuer
+	ErrInvalidIssuer = errors.New("token issuer is not valid")
+	// ErrInvalidAudience is returned when a token was not issued for an expected audience
+	ErrInvalidAudience = errors.New("token audience is not valid")
+)
+
+// Header is the JOSE header of a token
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Claims are the claims of a token
+type Claims map[string]interface{}
+
+// Token is a parsed and verified JSON Web Token
+type Token struct {
+	Header Header
+	Claims Claims
+}
+
+// Parse parses a compact serialized JSON Web Token and verifies its
+// signature using the keys in a key set. Only RSA and EC signatures
+// are supported.
+func Parse(raw string, keys *KeySet) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	token := &Token{}
+	if err := decodeSegment(parts[0], &token.Header); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := decodeSegment(parts[1], &token.Claims); err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	hash, err := getHash(token.Header.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	digest := hasher.Sum(nil)
+
+	candidates := keys.Find(token.Header.KeyID, token.Header.Algorithm)
+	if len(candidates) < 1 {
+		return nil, ErrKeyNotFound
+	}
+	for _, key := range candidates {
+		if verify(token.Header.Algorithm, hash, key.PublicKey, digest, signature) {
+			return token, nil
+		}
+	}
+	return nil, ErrInvalidSignature
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func getHash(alg string) (crypto.Hash, error) {
+	if len(alg) != 5 {
+		return 0, fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+	switch alg[:2] {
+	case "RS", "PS", "ES":
+	default:
+		return 0, fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+}
+
+func verify(alg string, hash crypto.Hash, key crypto.PublicKey, digest, signature []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		case "PS":
+			return rsa.VerifyPSS(key, hash, digest, signature, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		// each ES algorithm is only defined for a single curve
+		if alg[:2] != "ES" || key.Curve != getCurve(hash) {
+			return false
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+func getCurve(hash crypto.Hash) elliptic.Curve {
+	switch hash {
+	case crypto.SHA256:
+		return elliptic.P256()
+	case crypto.SHA384:
+		return elliptic.P384()
+	case crypto.SHA512:
+		return elliptic.P521()
+	}
+	return nil
+}
+
+// Validate checks the registered time, issuer and audience claims. Every
+// token must expire. An empty issuer or audience list is not checked.
+// Times are compared allowing for clock skew.
+func (c Claims) Validate(issuer string, audiences []string, currTime time.Time, skew time.Duration) error {
+	exp, ok := c.time("exp")
+	if !ok {
+		return ErrMissingExpiry
+	}
+	if !currTime.Add(-skew).Before(exp) {
+		return ErrExpired
+	}
+	if nbf, ok := c.time("nbf"); ok && currTime.Add(skew).Before(nbf) {
+		return ErrNotYetValid
+	}
+	if issuer != "" && c.String("iss") != issuer {
+		return ErrInvalidIssuer
+	}
+	if len(audiences) > 0 {
+		for _, aud := range c.Strings("aud") {
+			for _, expected := range audiences {
+				if aud == expected {
+					return nil
+				}
+			}
+		}
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// String returns the value of a claim as a string. Non string
+// values are JSON encoded. Missing claims are an empty string.
+func (c Claims) String(name string) string {
+	switch v := c[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		return strings.Join(c.Strings(name), ",")
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// Strings returns the value of a claim that is either a
+// string or an array as a list of strings
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case nil:
+		return []string{}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			} else {
+				b, _ := json.Marshal(item)
+				values = append(values, string(b))
+			}
+		}
+		return values
+	default:
+		return []string{c.String(name)}
+	}
+}
+
+// Scopes returns the OAuth2 scopes of a token. Scopes are read from
+// the space delimited scope claim or, if not present, the scp claim.
+func (c Claims) Scopes() []string {
+	if scope, ok := c["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	if scp, ok := c["scp"].(string); ok {
+		return strings.Fields(scp)
+	}
+	return c.Strings("scp")
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signToken(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(Header{Algorithm: alg, KeyID: kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash, err := getHash(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(alg, "PS") {
+			signature, err = rsa.SignPSS(rand.Reader, k, hash, digest, nil)
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[size-len(rBytes):size], rBytes)
+		copy(signature[2*size-len(sBytes):], sBytes)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func rsaJWK(kid, alg string, pub *rsa.PublicKey) JWK {
+	return JWK{
+		KeyType: "RSA",
+		KeyID:   kid,
+		Use:     "sig",
+		Alg:     alg,
+		N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid, crv string, pub *ecdsa.PublicKey) JWK {
+	return JWK{
+		KeyType: "EC",
+		KeyID:   kid,
+		Curve:   crv,
+		X:       base64.RawURLEncoding.EncodeToString(pub.X.Bytes()),
+		Y:       base64.RawURLEncoding.EncodeToString(pub.Y.Bytes()),
+	}
+}
+
+func encodeKeySet(keys ...JWK) []byte {
+	data, _ := json.Marshal(map[string][]JWK{"keys": keys})
+	return data
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	keys, err := ParseKeySet(encodeKeySet(
+		rsaJWK("rsa", "", &rsaKey.PublicKey),
+		ecJWK("ec", "P-256", &ecKey.PublicKey),
+	))
+	assert.Nil(err)
+
+	claims := map[string]interface{}{"sub": "frank"}
+
+	for _, alg := range []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"} {
+		token, err := Parse(signToken(t, rsaKey, alg, "rsa", claims), keys)
+		assert.Nil(err, alg)
+		assert.Equal("frank", token.Claims.String("sub"), alg)
+		assert.Equal(alg, token.Header.Algorithm)
+	}
+
+	token, err := Parse(signToken(t, ecKey, "ES256", "ec", claims), keys)
+	assert.Nil(err)
+	assert.Equal("ec", token.Header.KeyID)
+
+	_, err = Parse(signToken(t, ecKey, "ES256", "", claims), keys)
+	assert.Nil(err, "tokens without a key id should be verified with every key")
+
+	_, err = Parse(signToken(t, rsaKey, "RS256", "unknown", claims), keys)
+	assert.Equal(ErrKeyNotFound, err)
+
+	_, err = Parse(signToken(t, otherKey, "RS256", "rsa", claims), keys)
+	assert.Equal(ErrInvalidSignature, err)
+
+	_, err = Parse(signToken(t, rsaKey, "RS256", "ec", claims), keys)
+	assert.Equal(ErrInvalidSignature, err)
+
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p384Keys, err := ParseKeySet(encodeKeySet(ecJWK("ec", "P-384", &p384Key.PublicKey)))
+	assert.Nil(err)
+	_, err = Parse(signToken(t, p384Key, "ES384", "ec", claims), p384Keys)
+	assert.Nil(err)
+	_, err = Parse(signToken(t, p384Key, "ES256", "ec", claims), p384Keys)
+	assert.Equal(ErrInvalidSignature, err, "ES256 should only be verified with a P-256 key")
+	_, err = Parse(signToken(t, ecKey, "ES384", "ec", claims), keys)
+	assert.Equal(ErrInvalidSignature, err, "ES384 should only be verified with a P-384 key")
+
+	raw := signToken(t, rsaKey, "RS256", "rsa", claims)
+	parts := strings.Split(raw, ".")
+	tampered, _ := json.Marshal(map[string]interface{}{"sub": "admin"})
+	_, err = Parse(parts[0]+"."+base64.RawURLEncoding.EncodeToString(tampered)+"."+parts[2], keys)
+	assert.Equal(ErrInvalidSignature, err)
+
+	_, err = Parse("abc.def", keys)
+	assert.Equal(ErrMalformed, err)
+	_, err = Parse("abc.def.ghi", keys)
+	assert.Equal(ErrMalformed, err)
+
+	header, _ := json.Marshal(Header{Algorithm: "HS256"})
+	_, err = Parse(base64.RawURLEncoding.EncodeToString(header)+"."+parts[1]+"."+parts[2], keys)
+	assert.Equal("unsupported signing algorithm HS256", err.Error())
+
+	header, _ = json.Marshal(Header{Algorithm: "none"})
+	_, err = Parse(base64.RawURLEncoding.EncodeToString(header)+"."+parts[1]+".", keys)
+	assert.NotNil(err)
+}
+
+func TestClaimsValidate(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1500000000, 0)
+
+	claims := Claims{
+		"iss": "https://issuer.example.com",
+		"aud": []interface{}{"one", "two"},
+		"exp": float64(now.Add(time.Minute).Unix()),
+		"nbf": float64(now.Add(-time.Minute).Unix()),
+	}
+
+	assert.Nil(claims.Validate("https://issuer.example.com", []string{"two"}, now, 0))
+	assert.Nil(claims.Validate("", nil, now, 0))
+	assert.Equal(ErrInvalidIssuer, claims.Validate("https://other.example.com", nil, now, 0))
+	assert.Equal(ErrInvalidAudience, claims.Validate("", []string{"three"}, now, 0))
+	assert.Equal(ErrExpired, claims.Validate("", nil, now.Add(time.Minute), 0))
+	assert.Nil(claims.Validate("", nil, now.Add(time.Minute), 30*time.Second))
+	assert.Equal(ErrNotYetValid, claims.Validate("", nil, now.Add(-2*time.Minute), 0))
+	assert.Nil(claims.Validate("", nil, now.Add(-2*time.Minute), 2*time.Minute))
+
+	claims["aud"] = "one"
+	assert.Nil(claims.Validate("", []string{"one"}, now, 0))
+	assert.Equal(ErrInvalidAudience, claims.Validate("", []string{"two"}, now, 0))
+
+	delete(claims, "exp")
+	assert.Equal(ErrMissingExpiry, claims.Validate("", nil, now, 0), "tokens without an expiry should not be accepted")
+	claims["exp"] = "tomorrow"
+	assert.Equal(ErrMissingExpiry, claims.Validate("", nil, now, 0))
+}
+
+func TestClaims(t *testing.T) {
+	assert := assert.New(t)
+
+	claims := Claims{
+		"sub":    "frank",
+		"groups": []interface{}{"admins", "users"},
+		"age":    float64(42),
+		"scope":  "read write",
+	}
+
+	assert.Equal("frank", claims.String("sub"))
+	assert.Equal("admins,users", claims.String("groups"))
+	assert.Equal("42", claims.String("age"))
+	assert.Equal("", claims.String("missing"))
+	assert.Equal([]string{"admins", "users"}, claims.Strings("groups"))
+	assert.Equal([]string{"frank"}, claims.Strings("sub"))
+	assert.Equal([]string{}, claims.Strings("missing"))
+	assert.Equal([]string{"read", "write"}, claims.Scopes())
+
+	delete(claims, "scope")
+	claims["scp"] = []interface{}{"read"}
+	assert.Equal([]string{"read"}, claims.Scopes())
+	claims["scp"] = "read write"
+	assert.Equal([]string{"read", "write"}, claims.Scopes())
+	delete(claims, "scp")
+	assert.Equal([]string{}, claims.Scopes())
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// AdaptiveLimiter is a limiter whose limit is adjusted based on observed
+// latency. The limit is increased by one after a full limit's worth of
+// requests complete within the target latency and is multiplicatively
+// decreased whenever a request exceeds it.
+type AdaptiveLimiter struct {
+	*Limiter
+	mutex     sync.Mutex
+	min       int
+	max       int
+	target    time.Duration
+	backoff   float64
+	successes int
+}
+
+// NewAdaptive creates an adaptive limiter starting at the maximum limit
+func NewAdaptive(min, max, maxQueue int, target time.Duration) *AdaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &AdaptiveLimiter{
+		Limiter: New(max, maxQueue),
+		min:     min,
+		max:     max,
+		target:  target,
+		backoff: 0.9,
+	}
+}
+
+// Observe records the latency of a completed request and adjusts the limit
+func (l *AdaptiveLimiter) Observe(latency time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limit := l.Limiter.Limit()
+
+	if latency > l.target {
+		l.successes = 0
+		next := int(float64(limit) * l.backoff)
+		if next < l.min {
+			next = l.min
+		}
+		if next != limit {
+			l.Limiter.SetLimits(next, l.Limiter.maxQueueSize())
+		}
+		return
+	}
+
+	l.successes++
+	if l.successes >= limit && limit < l.max {
+		l.successes = 0
+		l.Limiter.SetLimits(limit+1, l.Limiter.maxQueueSize())
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAdaptive(t *testing.T) {
+	assert := assert.New(t)
+
+	l := NewAdaptive(0, 0, 10, time.Second)
+	assert.Equal(1, l.min)
+	assert.Equal(1, l.max)
+	assert.Equal(1, l.Limit())
+
+	l = NewAdaptive(5, 20, 10, time.Second)
+	assert.Equal(20, l.Limit())
+}
+
+func TestObserve(t *testing.T) {
+	assert := assert.New(t)
+
+	l := NewAdaptive(5, 20, 10, 100*time.Millisecond)
+
+	l.Observe(200 * time.Millisecond)
+	assert.Equal(18, l.Limit())
+
+	for i := 0; i < 20; i++ {
+		l.Observe(time.Second)
+	}
+	assert.Equal(5, l.Limit())
+
+	for i := 0; i < 4; i++ {
+		l.Observe(10 * time.Millisecond)
+	}
+	assert.Equal(5, l.Limit())
+	l.Observe(10 * time.Millisecond)
+	assert.Equal(6, l.Limit())
+
+	for i := 0; i < 1000; i++ {
+		l.Observe(10 * time.Millisecond)
+	}
+	assert.Equal(20, l.Limit())
+}
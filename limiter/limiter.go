@@ -0,0 +1,160 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when a request can not be queued
+	// because the wait queue is at capacity
+	ErrQueueFull = errors.New("too many requests in flight and wait queue is full")
+	// ErrQueueTimeout is returned when a queued request
+	// is not admitted within the queue timeout
+	ErrQueueTimeout = errors.New("timed out waiting for an in flight request to complete")
+)
+
+// Limiter bounds the number of concurrent requests. Requests over the
+// limit wait in a bounded FIFO queue until a slot becomes available.
+type Limiter struct {
+	mutex    sync.Mutex
+	limit    int
+	maxQueue int
+	inFlight int
+	waiters  *list.List
+}
+
+// New creates a limiter that admits up to limit concurrent
+// requests and queues up to maxQueue more
+func New(limit, maxQueue int) *Limiter {
+	return &Limiter{
+		limit:    limit,
+		maxQueue: maxQueue,
+		waiters:  list.New(),
+	}
+}
+
+// Acquire admits a request, waiting up to timeout in the queue if necessary.
+// A timeout of zero waits indefinitely. Waiting stops early if ctx is done,
+// in which case its error is returned. The returned function must be called
+// exactly once when the request completes.
+func (l *Limiter) Acquire(ctx context.Context, timeout time.Duration) (func(), error) {
+	l.mutex.Lock()
+	if l.inFlight < l.limit && l.waiters.Len() == 0 {
+		l.inFlight++
+		l.mutex.Unlock()
+		return l.release, nil
+	}
+	if l.waiters.Len() >= l.maxQueue {
+		l.mutex.Unlock()
+		return nil, ErrQueueFull
+	}
+	ready := make(chan struct{})
+	element := l.waiters.PushBack(ready)
+	l.mutex.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case <-ready:
+		return l.release, nil
+	case <-expired:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	select {
+	case <-ready:
+		// we were admitted while acquiring the lock
+		return l.release, nil
+	default:
+	}
+	l.waiters.Remove(element)
+	return nil, err
+}
+
+func (l *Limiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inFlight--
+	l.admit()
+}
+
+// admit hands available slots to queued requests in order.
+// The caller must hold the lock.
+func (l *Limiter) admit() {
+	for l.inFlight < l.limit && l.waiters.Len() > 0 {
+		ready := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.inFlight++
+		close(ready)
+	}
+}
+
+// SetLimits updates the concurrency limit and the maximum queue size.
+// Lowering the limit does not interrupt requests that are already in flight.
+func (l *Limiter) SetLimits(limit, maxQueue int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.limit = limit
+	l.maxQueue = maxQueue
+	l.admit()
+}
+
+// Limit returns the current concurrency limit
+func (l *Limiter) Limit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limit
+}
+
+func (l *Limiter) maxQueueSize() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.maxQueue
+}
+
+// InFlight returns the number of requests currently admitted
+func (l *Limiter) InFlight() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.inFlight
+}
+
+// Queued returns the number of requests currently waiting
+func (l *Limiter) Queued() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.waiters.Len()
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquire(t *testing.T) {
+	assert := assert.New(t)
+
+	l := New(2, 1)
+
+	releaseOne, err := l.Acquire(context.Background(), 0)
+	assert.Nil(err)
+	releaseTwo, err := l.Acquire(context.Background(), 0)
+	assert.Nil(err)
+	assert.Equal(2, l.InFlight())
+
+	admitted := make(chan func())
+	go func() {
+		release, err := l.Acquire(context.Background(), time.Second)
+		assert.Nil(err)
+		admitted <- release
+	}()
+
+	for l.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = l.Acquire(context.Background(), 0)
+	assert.Equal(ErrQueueFull, err)
+
+	releaseOne()
+	releaseThree := <-admitted
+	assert.Equal(2, l.InFlight())
+	assert.Equal(0, l.Queued())
+
+	releaseTwo()
+	releaseThree()
+	assert.Equal(0, l.InFlight())
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	l := New(1, 1)
+	release, err := l.Acquire(context.Background(), 0)
+	assert.Nil(err)
+
+	_, err = l.Acquire(context.Background(), 10*time.Millisecond)
+	assert.Equal(ErrQueueTimeout, err)
+	assert.Equal(0, l.Queued())
+	assert.Equal(1, l.InFlight())
+
+	release()
+	assert.Equal(0, l.InFlight())
+}
+
+func TestSetLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	l := New(1, 1)
+	releaseOne, _ := l.Acquire(context.Background(), 0)
+
+	admitted := make(chan func())
+	go func() {
+		release, _ := l.Acquire(context.Background(), 0)
+		admitted <- release
+	}()
+
+	for l.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	l.SetLimits(2, 1)
+	releaseTwo := <-admitted
+	assert.Equal(2, l.Limit())
+	assert.Equal(2, l.InFlight())
+
+	releaseOne()
+	releaseTwo()
+	assert.Equal(0, l.InFlight())
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	l := New(1, 1)
+	release, err := l.Acquire(context.Background(), 0)
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := l.Acquire(ctx, 0)
+		done <- err
+	}()
+
+	for l.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	assert.Equal(context.Canceled, <-done)
+	assert.Equal(0, l.Queued())
+	assert.Equal(1, l.InFlight())
+
+	release()
+	assert.Equal(0, l.InFlight())
+}
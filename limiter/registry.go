@@ -0,0 +1,85 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package limiter
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Registry holds a limiter for each unique name. It is safe for concurrent use.
+type Registry struct {
+	mutex    sync.Mutex
+	limiters map[string]*Limiter
+}
+
+// Proxies holds the concurrency limiter of every APIProxy
+// that defines one. It should not be mutated directly!
+var Proxies *Registry
+
+func init() {
+	Proxies = NewRegistry()
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{limiters: map[string]*Limiter{}}
+}
+
+// Name returns the name that the limiter of a
+// namespaced Kubernetes object is registered under
+func Name(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// Get returns the limiter for a name, creating it if it does not exist.
+// If the limits have changed, the existing limiter is updated in place so
+// that requests already in flight continue to be accounted for.
+func (r *Registry) Get(name string, limit, maxQueue int) *Limiter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	l, ok := r.limiters[name]
+	if !ok {
+		l = New(limit, maxQueue)
+		r.limiters[name] = l
+		return l
+	}
+	if l.Limit() != limit || l.maxQueueSize() != maxQueue {
+		l.SetLimits(limit, maxQueue)
+	}
+	return l
+}
+
+// Delete removes the limiter for a name
+func (r *Registry) Delete(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.limiters, name)
+}
+
+// Clear removes all limiters
+func (r *Registry) Clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for k := range r.limiters {
+		delete(r.limiters, k)
+	}
+}
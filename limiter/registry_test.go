@@ -0,0 +1,57 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package limiter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "foo/bar", Name("foo", "bar"))
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewRegistry()
+	l := r.Get("foo/bar", 1, 2)
+	assert.Equal(1, l.Limit())
+	assert.Equal(2, l.maxQueueSize())
+
+	release, _ := l.Acquire(context.Background(), 0)
+
+	updated := r.Get("foo/bar", 5, 10)
+	assert.True(l == updated, "expected limiter to be updated in place")
+	assert.Equal(5, updated.Limit())
+	assert.Equal(10, updated.maxQueueSize())
+	assert.Equal(1, updated.InFlight())
+	release()
+
+	r.Delete("foo/bar")
+	assert.False(l == r.Get("foo/bar", 5, 10))
+
+	r.Get("foo/car", 1, 1)
+	r.Clear()
+	assert.Equal(0, len(r.limiters))
+}
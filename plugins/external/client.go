@@ -0,0 +1,134 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package external
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// ServiceName is the name of the gRPC service implemented by external plugins
+	ServiceName = "kanali.plugin.v1.Plugin"
+
+	onRequestMethod  = "/" + ServiceName + "/OnRequest"
+	onResponseMethod = "/" + ServiceName + "/OnResponse"
+	unixPrefix       = "unix://"
+)
+
+// Client invokes an external plugin over gRPC
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// Options configures the connection to an external plugin
+type Options struct {
+	// TLS secures connections over TCP. Without it, only loopback
+	// addresses may be dialed as requests would be sent in plain text.
+	TLS *tls.Config
+	// MaxMessageSize bounds the size of the messages sent to and received
+	// from the plugin. The gRPC default is used if it is not positive.
+	MaxMessageSize int
+}
+
+// Dial creates a client for an external plugin listening on an address.
+// Addresses prefixed with unix:// are Unix domain sockets, all other
+// addresses are TCP host:port pairs. The connection is established lazily.
+func Dial(address string, options Options) (*Client, error) {
+	if address == "" || address == unixPrefix {
+		return nil, errors.New("external plugin address must not be empty")
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithCodec(Codec{}),
+	}
+	if options.MaxMessageSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(options.MaxMessageSize),
+			grpc.MaxCallSendMsgSize(options.MaxMessageSize),
+		))
+	}
+	switch {
+	case strings.HasPrefix(address, unixPrefix):
+		opts = append(opts, grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+		address = strings.TrimPrefix(address, unixPrefix)
+	case options.TLS != nil:
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(options.TLS)))
+	case isLoopback(address):
+		opts = append(opts, grpc.WithInsecure())
+	default:
+		return nil, fmt.Errorf("external plugin address %s must be a loopback address unless tls is enabled", address)
+	}
+
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn}, nil
+}
+
+// isLoopback reports whether a host:port pair can only refer to this host.
+// Host names other than localhost are not trusted as they may resolve to
+// any address.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// OnRequest invokes the OnRequest method of the external plugin
+func (c *Client) OnRequest(ctx context.Context, req *Request) (*Result, error) {
+	return c.invoke(ctx, onRequestMethod, req)
+}
+
+// OnResponse invokes the OnResponse method of the external plugin
+func (c *Client) OnResponse(ctx context.Context, req *Request) (*Result, error) {
+	return c.invoke(ctx, onResponseMethod, req)
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req *Request) (*Result, error) {
+	result := &Result{}
+	if err := grpc.Invoke(ctx, method, req, result, c.conn); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Close closes the connection to the external plugin
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package external
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+type fakeServer struct{}
+
+func (s fakeServer) OnRequest(ctx context.Context, req *Request) (*Result, error) {
+	if req.Plugin == "broken" {
+		return nil, errors.New("broken")
+	}
+	return &Result{
+		Header:   http.Header{"X-Plugin": []string{req.Plugin}},
+		Body:     append(req.Body, []byte(" world")...),
+		Metadata: map[string]string{"proxy": req.Proxy.Name},
+	}, nil
+}
+
+func (s fakeServer) OnResponse(ctx context.Context, req *Request) (*Result, error) {
+	return &Result{
+		Response: &Response{StatusCode: req.Response.StatusCode + 1},
+	}, nil
+}
+
+func startFakeServer(t *testing.T, network, address string) func() {
+	lis, err := net.Listen(network, address)
+	assert.Nil(t, err)
+	s := NewServer()
+	RegisterServer(s, fakeServer{})
+	go s.Serve(lis)
+	return s.Stop
+}
+
+func TestDial(t *testing.T) {
+	_, err := Dial("", Options{})
+	assert.Equal(t, "external plugin address must not be empty", err.Error())
+	_, err = Dial("unix://", Options{})
+	assert.NotNil(t, err)
+
+	c, err := Dial("localhost:1", Options{})
+	assert.Nil(t, err, "connections should be established lazily")
+	assert.Nil(t, c.Close())
+
+	for _, address := range []string{"localhost:1", "127.0.0.1:1", "[::1]:1"} {
+		c, err := Dial(address, Options{})
+		assert.Nil(t, err, address)
+		c.Close()
+	}
+	for _, address := range []string{"plugin.foo.svc:1", "10.0.0.1:1", "0.0.0.0:1", "localhost"} {
+		_, err := Dial(address, Options{})
+		assert.NotNil(t, err, "plain text connections should only be made to loopback addresses")
+	}
+	c, err = Dial("plugin.foo.svc:1", Options{TLS: &tls.Config{}})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Close())
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := NewServer()
+	RegisterServer(s, fakeServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	c, err := Dial(lis.Addr().String(), Options{MaxMessageSize: 1024})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = c.OnRequest(ctx, &Request{Plugin: "large", Body: make([]byte, 2048)})
+	assert.Equal(t, codes.ResourceExhausted, grpc.Code(err))
+}
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "plugin.sock")
+	defer startFakeServer(t, "unix", socket)()
+
+	c, err := Dial("unix://"+socket, Options{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.OnRequest(ctx, &Request{
+		Plugin: "example",
+		Proxy:  Proxy{Name: "proxy"},
+		Body:   []byte("hello"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "example", result.Header.Get("X-Plugin"))
+	assert.Equal(t, "hello world", string(result.Body))
+	assert.Equal(t, map[string]string{"proxy": "proxy"}, result.Metadata)
+
+	result, err = c.OnResponse(ctx, &Request{Response: &Response{StatusCode: 200}})
+	assert.Nil(t, err)
+	assert.Equal(t, 201, result.Response.StatusCode)
+
+	_, err = c.OnRequest(ctx, &Request{Plugin: "broken"})
+	assert.NotNil(t, err)
+}
+
+func TestClientTCP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := NewServer()
+	RegisterServer(s, fakeServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	c, err := Dial(lis.Addr().String(), Options{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := c.OnRequest(ctx, &Request{Plugin: "tcp"})
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp", result.Header.Get("X-Plugin"))
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Proxy identifies the APIProxy that matched a request
+type Proxy struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Path      string `json:"path"`
+}
+
+// Request is the message sent to an external plugin. Response
+// is only set when the OnResponse method is invoked.
+type Request struct {
+	Plugin   string            `json:"plugin"`
+	Proxy    Proxy             `json:"proxy"`
+	Config   json.RawMessage   `json:"config,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Header   http.Header       `json:"header,omitempty"`
+	Body     []byte            `json:"body,omitempty"`
+	Response *Response         `json:"response,omitempty"`
+}
+
+// Response represents an HTTP response, either the one returned from the
+// upstream service or one supplied by a plugin in its place
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+// Result is the message returned by an external plugin. Header and Body,
+// if set, replace those of the request, or of the upstream response when
+// returned from OnResponse. Metadata is recorded on the tracing span.
+// If Response is returned from OnRequest, it is returned to the client
+// in place of proxying the request upstream.
+type Result struct {
+	Header   http.Header       `json:"header,omitempty"`
+	Body     []byte            `json:"body,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Response *Response         `json:"response,omitempty"`
+	Error    *Error            `json:"error,omitempty"`
+}
+
+// Error is returned by an external plugin to terminate a request
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
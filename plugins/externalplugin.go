@@ -0,0 +1,376 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/plugins/external"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	externalFailClosed = "failClosed"
+	externalFailOpen   = "failOpen"
+)
+
+type externalMetadataKey string
+
+// UnavailableError is returned when an external plugin could not be invoked
+type UnavailableError struct {
+	Plugin string
+	Err    error
+}
+
+// Error will return the error message associated with the error
+func (e UnavailableError) Error() string {
+	return fmt.Sprintf("external plugin %s is unavailable: %s", e.Plugin, e.Err.Error())
+}
+
+// Status returns the HTTP error code associated with the error
+func (e UnavailableError) Status() int {
+	return http.StatusBadGateway
+}
+
+// externalMessageOverhead is the room left in each gRPC message for
+// everything but the body, such as the URL and headers
+const externalMessageOverhead = http.DefaultMaxHeaderBytes
+
+type externalPlugin struct {
+	name        string
+	client      *external.Client
+	maxBodySize int64
+}
+
+func newExternalPlugin(plugin spec.Plugin) (*Plugin, error) {
+	if err := validateExternal(plugin.External); err != nil {
+		return nil, utils.StatusError{Code: http.StatusInternalServerError, Err: err}
+	}
+	opts, maxBodySize, err := externalOptions(plugin.External)
+	if err != nil {
+		return nil, utils.StatusError{Code: http.StatusInternalServerError, Err: err}
+	}
+	client, err := external.Dial(plugin.External.Address, opts)
+	if err != nil {
+		return nil, utils.StatusError{
+			Code: http.StatusInternalServerError,
+			Err:  fmt.Errorf("could not connect to external plugin %s: %s", plugin.Name, err.Error()),
+		}
+	}
+	var p Plugin = externalPlugin{plugin.Name, client, maxBodySize}
+	return &p, nil
+}
+
+// externalOptions returns the options used to connect to an external
+// plugin and the largest body that may be sent to it. Messages are sized
+// to fit the largest body so that larger bodies are rejected before they
+// are sent instead of failing the gRPC call.
+func externalOptions(e *spec.ExternalPlugin) (external.Options, int64, error) {
+	opts := external.Options{MaxMessageSize: math.MaxInt32}
+	maxBodySize := int64(viper.GetInt(config.FlagPluginsExternalMaxBodySize.GetLong()))
+	if maxBodySize > 0 && maxBodySize < math.MaxInt32-externalMessageOverhead {
+		opts.MaxMessageSize = int(maxBodySize) + externalMessageOverhead
+	}
+	if !e.TLS {
+		return opts, maxBodySize, nil
+	}
+
+	opts.TLS = &tls.Config{}
+	if path := viper.GetString(config.FlagPluginsExternalTLSCaFile.GetLong()); path != "" {
+		ca, err := ioutil.ReadFile(path)
+		if err != nil {
+			return opts, 0, fmt.Errorf("could not read external plugin ca file: %s", err.Error())
+		}
+		opts.TLS.RootCAs = x509.NewCertPool()
+		if !opts.TLS.RootCAs.AppendCertsFromPEM(ca) {
+			return opts, 0, fmt.Errorf("external plugin ca file %s contains no certificates", path)
+		}
+	}
+	certFile := viper.GetString(config.FlagPluginsExternalTLSCertFile.GetLong())
+	keyFile := viper.GetString(config.FlagPluginsExternalTLSKeyFile.GetLong())
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return opts, 0, fmt.Errorf("could not load external plugin client certificate: %s", err.Error())
+		}
+		opts.TLS.Certificates = []tls.Certificate{cert}
+	}
+	return opts, maxBodySize, nil
+}
+
+func validateExternal(e *spec.ExternalPlugin) error {
+	if e.Address == "" {
+		return errors.New("external plugin address must not be empty")
+	}
+	if e.Timeout != "" {
+		if _, err := time.ParseDuration(e.Timeout); err != nil {
+			return fmt.Errorf("invalid external plugin timeout %s", e.Timeout)
+		}
+	}
+	switch e.FailurePolicy {
+	case "", externalFailClosed, externalFailOpen:
+		return nil
+	}
+	return fmt.Errorf("invalid external plugin failure policy %s", e.FailurePolicy)
+}
+
+// Init is a no-op as external plugins manage their own resources
+func (p externalPlugin) Init(config map[string]interface{}, logger *logrus.Entry) error {
+	return nil
+}
+
+// Close closes the connection to the external plugin
+func (p externalPlugin) Close() error {
+	return p.client.Close()
+}
+
+// Healthy always reports an external plugin as healthy as
+// its availability is governed by its failure policy
+func (p externalPlugin) Healthy() error {
+	return nil
+}
+
+// OnRequest invokes the external plugin without any configuration
+func (p externalPlugin) OnRequest(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, req *http.Request, span opentracing.Span) error {
+	return p.OnRequestWithConfig(ctx, m, proxy, nil, req, span)
+}
+
+// OnResponse invokes the external plugin without any configuration
+func (p externalPlugin) OnResponse(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, req *http.Request, resp *http.Response, span opentracing.Span) error {
+	return p.OnResponseWithConfig(ctx, m, proxy, nil, req, resp, span)
+}
+
+// OnRequestWithConfig invokes the OnRequest method of the external plugin and
+// applies the result to the request. If the plugin responds to the request
+// itself, the response is returned as an error.
+func (p externalPlugin) OnRequestWithConfig(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, span opentracing.Span) error {
+	result, err := p.OnRequestWithResult(ctx, m, proxy, config, req, span)
+	if err != nil || result.Response == nil {
+		return err
+	}
+	body, _ := ioutil.ReadAll(result.Response.Body)
+	return utils.StatusError{Code: result.Response.StatusCode, Err: errors.New(string(body))}
+}
+
+// OnRequestWithResult invokes the OnRequest method of the external plugin
+// and applies the result to the request. If the plugin responds to the
+// request itself, that response is returned.
+func (p externalPlugin) OnRequestWithResult(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, span opentracing.Span) (*RequestResult, error) {
+	body, err := readBody(&req.Body, req.ContentLength, p.maxBodySize)
+	if err == errBodyTooLarge {
+		return nil, utils.StatusError{
+			Code: http.StatusRequestEntityTooLarge,
+			Err:  fmt.Errorf("request body exceeds %d bytes and can not be sent to external plugin %s", p.maxBodySize, p.name),
+		}
+	}
+	if err != nil {
+		return nil, utils.StatusError{Code: http.StatusInternalServerError, Err: err}
+	}
+
+	result, err := p.client.OnRequest(ctx, p.newRequest(proxy, config, req, body))
+	if err != nil {
+		return nil, p.invokeError(err)
+	}
+	if err := resultError(result); err != nil {
+		return nil, err
+	}
+
+	p.setMetadata(req, span, result.Metadata)
+	if result.Response != nil {
+		return &RequestResult{Response: &http.Response{
+			StatusCode:    result.Response.StatusCode,
+			Status:        fmt.Sprintf("%d %s", result.Response.StatusCode, http.StatusText(result.Response.StatusCode)),
+			Header:        result.Response.Header,
+			Body:          ioutil.NopCloser(bytes.NewReader(result.Response.Body)),
+			ContentLength: int64(len(result.Response.Body)),
+			Request:       req,
+		}}, nil
+	}
+	if result.Header != nil {
+		req.Header = result.Header
+	}
+	if result.Body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(result.Body))
+		req.ContentLength = int64(len(result.Body))
+	}
+	return &RequestResult{}, nil
+}
+
+// OnResponseWithConfig invokes the OnResponse method of the external plugin
+// and applies the result to the response
+func (p externalPlugin) OnResponseWithConfig(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, resp *http.Response, span opentracing.Span) error {
+	body, err := readBody(&resp.Body, resp.ContentLength, p.maxBodySize)
+	if err == errBodyTooLarge {
+		return utils.StatusError{
+			Code: http.StatusBadGateway,
+			Err:  fmt.Errorf("response body exceeds %d bytes and can not be sent to external plugin %s", p.maxBodySize, p.name),
+		}
+	}
+	if err != nil {
+		return utils.StatusError{Code: http.StatusInternalServerError, Err: err}
+	}
+
+	r := p.newRequest(proxy, config, req, nil)
+	r.Response = &external.Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}
+	result, err := p.client.OnResponse(ctx, r)
+	if err != nil {
+		return p.invokeError(err)
+	}
+	if err := resultError(result); err != nil {
+		return err
+	}
+
+	if result.Response != nil {
+		resp.StatusCode = result.Response.StatusCode
+		resp.Status = fmt.Sprintf("%d %s", result.Response.StatusCode, http.StatusText(result.Response.StatusCode))
+		result.Header, result.Body = result.Response.Header, result.Response.Body
+		if result.Body == nil {
+			result.Body = []byte{}
+		}
+	}
+	if result.Header != nil {
+		resp.Header = result.Header
+	}
+	if result.Body != nil {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(result.Body))
+		resp.ContentLength = int64(len(result.Body))
+	}
+	for k, v := range result.Metadata {
+		span.SetTag(k, v)
+	}
+	return nil
+}
+
+func (p externalPlugin) newRequest(proxy spec.APIProxy, config json.RawMessage, req *http.Request, body []byte) *external.Request {
+	r := &external.Request{
+		Plugin: p.name,
+		Proxy: external.Proxy{
+			Name:      proxy.ObjectMeta.Name,
+			Namespace: proxy.ObjectMeta.Namespace,
+			Path:      proxy.Spec.Path,
+		},
+		Config: config,
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header,
+		Body:   body,
+	}
+	if md, ok := req.Context().Value(externalMetadataKey(p.name)).(map[string]string); ok {
+		r.Metadata = md
+	}
+	return r
+}
+
+// setMetadata records metadata returned by OnRequest on the tracing span
+// and with the request so that it is sent with the OnResponse call
+func (p externalPlugin) setMetadata(req *http.Request, span opentracing.Span, md map[string]string) {
+	if len(md) < 1 {
+		return
+	}
+	for k, v := range md {
+		span.SetTag(k, v)
+	}
+	*req = *req.WithContext(context.WithValue(req.Context(), externalMetadataKey(p.name), md))
+}
+
+// invokeError classifies a failed call to the plugin. Only a plugin that
+// could not be reached in time is unavailable. Any other failure, such as a
+// message that is too large or could not be decoded, would recur however
+// often the call is retried and so must not be bypassed by its failure policy.
+func (p externalPlugin) invokeError(err error) error {
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return UnavailableError{p.name, err}
+	}
+	return utils.StatusError{
+		Code: http.StatusBadGateway,
+		Err:  fmt.Errorf("external plugin %s failed: %s", p.name, err.Error()),
+	}
+}
+
+func resultError(result *external.Result) error {
+	if result.Error == nil {
+		return nil
+	}
+	code := result.Error.Code
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	return utils.StatusError{Code: code, Err: errors.New(result.Error.Message)}
+}
+
+var errBodyTooLarge = errors.New("body too large")
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readBody reads a body of at most max bytes, unless max is not positive,
+// and replaces it with a copy so that it can be read again
+func readBody(body *io.ReadCloser, length, max int64) ([]byte, error) {
+	if *body == nil {
+		return nil, nil
+	}
+	if max > 0 && length > max {
+		return nil, errBodyTooLarge
+	}
+	r := io.Reader(*body)
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read body: %s", err.Error())
+	}
+	if max > 0 && int64(len(data)) > max {
+		// leave the body intact in case the plugin's onError policy
+		// allows the request to proceed without it
+		*body = multiReadCloser{io.MultiReader(bytes.NewReader(data), *body), *body}
+		return nil, errBodyTooLarge
+	}
+	(*body).Close()
+	*body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
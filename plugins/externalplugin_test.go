@@ -0,0 +1,218 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/plugins/external"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	netcontext "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+type fakeExternalServer struct{}
+
+func (s fakeExternalServer) OnRequest(ctx netcontext.Context, req *external.Request) (*external.Result, error) {
+	switch req.Header.Get("X-Test") {
+	case "error":
+		return &external.Result{Error: &external.Error{Code: http.StatusForbidden, Message: "forbidden"}}, nil
+	case "respond":
+		return &external.Result{Response: &external.Response{StatusCode: http.StatusTeapot, Body: []byte("short and stout")}}, nil
+	case "sleep":
+		time.Sleep(time.Second)
+	}
+	header := http.Header{}
+	header.Set("X-Proxy", req.Proxy.Namespace+"/"+req.Proxy.Name)
+	header.Set("X-Config", string(req.Config))
+	return &external.Result{
+		Header:   header,
+		Body:     bytes.ToUpper(req.Body),
+		Metadata: map[string]string{"request-id": "abc"},
+	}, nil
+}
+
+func (s fakeExternalServer) OnResponse(ctx netcontext.Context, req *external.Request) (*external.Result, error) {
+	return &external.Result{
+		Response: &external.Response{
+			StatusCode: req.Response.StatusCode + 1,
+			Header:     http.Header{"X-Metadata": []string{req.Metadata["request-id"]}},
+			Body:       append(req.Response.Body, []byte(" world")...),
+		},
+	}, nil
+}
+
+func startFakeExternalServer(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "plugins")
+	assert.Nil(t, err)
+	socket := filepath.Join(dir, "plugin.sock")
+	lis, err := net.Listen("unix", socket)
+	assert.Nil(t, err)
+	s := external.NewServer()
+	external.RegisterServer(s, fakeExternalServer{})
+	go s.Serve(lis)
+	return "unix://" + socket, func() {
+		s.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestExternalPlugin(t *testing.T) {
+	address, stop := startFakeExternalServer(t)
+	defer stop()
+
+	p, err := GetPlugin(spec.Plugin{Name: "external", External: &spec.ExternalPlugin{Address: address}})
+	assert.Nil(t, err)
+	configurable, ok := (*p).(ConfigurablePlugin)
+	assert.True(t, ok)
+
+	proxy := spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "foo"},
+		Spec:       spec.APIProxySpec{Path: "/foo"},
+	}
+	span := opentracing.StartSpan("test span")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := httptest.NewRequest("POST", "/foo/bar", bytes.NewBufferString("hello"))
+	assert.Nil(t, configurable.OnRequestWithConfig(ctx, nil, proxy, json.RawMessage(`{"a":1}`), req, span))
+	assert.Equal(t, "foo/proxy", req.Header.Get("X-Proxy"))
+	assert.Equal(t, `{"a":1}`, req.Header.Get("X-Config"))
+	assert.Equal(t, int64(5), req.ContentLength)
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, "HELLO", string(body))
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewBufferString("hello"))}
+	assert.Nil(t, configurable.OnResponseWithConfig(ctx, nil, proxy, nil, req, resp, span))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "abc", resp.Header.Get("X-Metadata"), "metadata from OnRequest should be sent to OnResponse")
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "hello world", string(body))
+
+	req = httptest.NewRequest("GET", "/foo", nil)
+	req.Header.Set("X-Test", "error")
+	err = configurable.OnRequest(ctx, nil, proxy, req, span)
+	assert.Equal(t, "forbidden", err.Error())
+	assert.Equal(t, http.StatusForbidden, err.(utils.StatusError).Status())
+
+	req.Header.Set("X-Test", "respond")
+	err = configurable.OnRequest(ctx, nil, proxy, req, span)
+	assert.Equal(t, "short and stout", err.Error())
+	assert.Equal(t, http.StatusTeapot, err.(utils.StatusError).Status())
+
+	responder, ok := (*p).(ResponderPlugin)
+	assert.True(t, ok)
+	result, err := responder.OnRequestWithResult(ctx, nil, proxy, nil, req, span)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusTeapot, result.Response.StatusCode)
+	assert.Equal(t, "418 I'm a teapot", result.Response.Status)
+	assert.Equal(t, req, result.Response.Request)
+	body, _ = ioutil.ReadAll(result.Response.Body)
+	assert.Equal(t, "short and stout", string(body))
+
+	req.Header.Del("X-Test")
+	result, err = responder.OnRequestWithResult(ctx, nil, proxy, nil, req, span)
+	assert.Nil(t, err)
+	assert.Nil(t, result.Response)
+}
+
+func TestValidateExternal(t *testing.T) {
+	assert.Nil(t, validateExternal(&spec.ExternalPlugin{Address: "localhost:9000"}))
+	assert.Nil(t, validateExternal(&spec.ExternalPlugin{Address: "unix:///tmp/plugin.sock", Timeout: "100ms", FailurePolicy: "failClosed"}))
+	assert.Equal(t, "external plugin address must not be empty", validateExternal(&spec.ExternalPlugin{}).Error())
+	assert.Equal(t, "invalid external plugin timeout foo", validateExternal(&spec.ExternalPlugin{Address: "localhost:9000", Timeout: "foo"}).Error())
+	assert.Equal(t, "invalid external plugin failure policy ignore", validateExternal(&spec.ExternalPlugin{Address: "localhost:9000", FailurePolicy: "ignore"}).Error())
+
+	status := Registry.Validate(spec.APIProxy{
+		Spec: spec.APIProxySpec{
+			Plugins: []spec.Plugin{{Name: "external", External: &spec.ExternalPlugin{Address: "localhost:9000", FailurePolicy: "ignore"}}},
+		},
+	})
+	assert.Equal(t, "invalid external plugin failure policy ignore", status.GetFailedPlugin().Error)
+	Registry.Clear()
+}
+
+func TestRegistryKey(t *testing.T) {
+	assert.Equal(t, "example_1.0.0", registryKey(spec.Plugin{Name: "example", Version: "1.0.0"}))
+	assert.Equal(t, "example@localhost:9000", registryKey(spec.Plugin{Name: "example", External: &spec.ExternalPlugin{Address: "localhost:9000"}}))
+	assert.Equal(t, "example@tls://localhost:9000", registryKey(spec.Plugin{Name: "example", External: &spec.ExternalPlugin{Address: "localhost:9000", TLS: true}}))
+}
+
+func TestExternalPluginMaxBodySize(t *testing.T) {
+	address, stop := startFakeExternalServer(t)
+	defer stop()
+	viper.Set(config.FlagPluginsExternalMaxBodySize.GetLong(), 5)
+	defer viper.Set(config.FlagPluginsExternalMaxBodySize.GetLong(), nil)
+
+	p, err := GetPlugin(spec.Plugin{Name: "external", External: &spec.ExternalPlugin{Address: address}})
+	assert.Nil(t, err)
+	span := opentracing.StartSpan("test span")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := httptest.NewRequest("POST", "/foo", bytes.NewBufferString("hello"))
+	assert.Nil(t, (*p).OnRequest(ctx, nil, spec.APIProxy{}, req, span))
+
+	req = httptest.NewRequest("POST", "/foo", bytes.NewBufferString("hello world"))
+	req.ContentLength = -1
+	err = (*p).OnRequest(ctx, nil, spec.APIProxy{}, req, span)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, err.(utils.StatusError).Status())
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, "hello world", string(body), "the body should be left intact")
+
+	req = httptest.NewRequest("POST", "/foo", bytes.NewBufferString("hello world"))
+	err = (*p).OnRequest(ctx, nil, spec.APIProxy{}, req, span)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, err.(utils.StatusError).Status(), "a known content length should be rejected before reading")
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: -1, Body: ioutil.NopCloser(bytes.NewBufferString("hello world"))}
+	err = (*p).OnResponse(ctx, nil, spec.APIProxy{}, req, resp, span)
+	assert.Equal(t, http.StatusBadGateway, err.(utils.StatusError).Status())
+}
+
+func TestExternalPluginInvokeError(t *testing.T) {
+	p := externalPlugin{name: "external"}
+	_, ok := p.invokeError(grpc.Errorf(codes.Unavailable, "connection refused")).(UnavailableError)
+	assert.True(t, ok)
+	_, ok = p.invokeError(grpc.Errorf(codes.DeadlineExceeded, "deadline exceeded")).(UnavailableError)
+	assert.True(t, ok)
+
+	for _, code := range []codes.Code{codes.ResourceExhausted, codes.Internal, codes.Unknown} {
+		err := p.invokeError(grpc.Errorf(code, "failed"))
+		assert.Equal(t, http.StatusBadGateway, err.(utils.StatusError).Status(), "only unreachable plugins should be unavailable")
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/traffic"
+	"github.com/spf13/viper"
+)
+
+const (
+	// counters are recorded in the traffic store under a namespace that
+	// is not a valid Kubernetes namespace so they can not collide with
+	// the traffic of an ApiKeyBinding
+	hostTrafficNamespace = "kanali.plugins"
+	maxCounterKeyLength  = 253
+)
+
+// Host is the API that Kanali exposes to plugins. It provides a key/value
+// store and windowed counters that are namespaced to the plugin they were
+// handed to.
+type Host interface {
+	// Get returns the value stored for a key, if the key exists and has not expired
+	Get(key string) ([]byte, bool)
+	// Set stores a value for a key. If ttl is greater than zero, the key
+	// expires once ttl has elapsed. An error is returned if the plugin
+	// already stores the maximum number of keys.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes a key
+	Delete(key string)
+	// Increment adds one to a counter and returns the number of increments
+	// made to it within the trailing window, including this one
+	Increment(key string, window time.Duration) (int, error)
+	// Count returns the number of increments made to a counter within the
+	// trailing window
+	Count(key string, window time.Duration) (int, error)
+}
+
+// HostPlugin is implemented by plugins that use the Host API. SetHost is
+// invoked once when the plugin is loaded, before its Lifecycle Init.
+//
+// Values in the key/value store are local to each Kanali instance.
+// Increments to counters are sent to every other Kanali instance using
+// the same mechanism as ApiKeyBinding traffic, so counts are cluster wide
+// and eventually consistent. Increments are kept for the largest window a
+// counter has been counted in on this instance, or for the traffic
+// retention if it has not been counted here.
+type HostPlugin interface {
+	SetHost(host Host)
+}
+
+type hostEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e hostEntry) isExpired(currTime time.Time) bool {
+	return !e.expires.IsZero() && !currTime.Before(e.expires)
+}
+
+type hostFactory struct {
+	mutex   sync.RWMutex
+	entries map[string]map[string]hostEntry
+	windows map[string]map[string]time.Duration
+}
+
+var hostStore = &hostFactory{
+	entries: map[string]map[string]hostEntry{},
+	windows: map[string]map[string]time.Duration{},
+}
+
+type host struct {
+	plugin string
+	store  *hostFactory
+}
+
+func newHost(plugin string) Host {
+	return host{plugin, hostStore}
+}
+
+// Get returns the value stored for a key
+func (h host) Get(key string) ([]byte, bool) {
+	currTime := time.Now()
+	h.store.mutex.RLock()
+	entry, ok := h.store.entries[h.plugin][key]
+	h.store.mutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if entry.isExpired(currTime) {
+		h.store.deleteExpired(h.plugin, key, currTime)
+		return nil, false
+	}
+	return copyValue(entry.value), true
+}
+
+// Set stores a value for a key
+func (h host) Set(key string, value []byte, ttl time.Duration) error {
+	h.store.mutex.Lock()
+	defer h.store.mutex.Unlock()
+	currTime := time.Now()
+	entry := hostEntry{value: copyValue(value)}
+	if ttl > 0 {
+		entry.expires = currTime.Add(ttl)
+	}
+	entries, ok := h.store.entries[h.plugin]
+	if !ok {
+		entries = map[string]hostEntry{}
+		h.store.entries[h.plugin] = entries
+	}
+	if _, ok := entries[key]; !ok {
+		max := viper.GetInt(config.FlagPluginsHostMaxEntries.GetLong())
+		if max > 0 && len(entries) >= max {
+			removeExpired(entries, currTime)
+		}
+		if max > 0 && len(entries) >= max {
+			return fmt.Errorf("plugin %s can not store more than %d keys", h.plugin, max)
+		}
+	}
+	entries[key] = entry
+	return nil
+}
+
+// Delete removes a key
+func (h host) Delete(key string) {
+	h.store.mutex.Lock()
+	defer h.store.mutex.Unlock()
+	delete(h.store.entries[h.plugin], key)
+}
+
+// Increment adds one to a counter
+func (h host) Increment(key string, window time.Duration) (int, error) {
+	if err := validateCounter(h.plugin, key, window); err != nil {
+		return 0, err
+	}
+	if !h.store.setWindow(h.plugin, key, window) {
+		return 0, fmt.Errorf("plugin %s can not use more than %d counters", h.plugin, viper.GetInt(config.FlagPluginsHostMaxEntries.GetLong()))
+	}
+	traffic.Emit(hostTrafficNamespace, h.plugin, key)
+	return spec.TrafficStore.Count(hostTrafficNamespace, h.plugin, key, time.Now().Add(-window)), nil
+}
+
+// Count returns the number of increments made to a counter
+func (h host) Count(key string, window time.Duration) (int, error) {
+	if err := validateCounter(h.plugin, key, window); err != nil {
+		return 0, err
+	}
+	// a counter that can not be recorded has not been incremented on this
+	// instance and its increments from other instances are kept for the
+	// traffic retention instead of its window
+	h.store.setWindow(h.plugin, key, window)
+	return spec.TrafficStore.Count(hostTrafficNamespace, h.plugin, key, time.Now().Add(-window)), nil
+}
+
+// setWindow records the largest window a counter has been counted in. It
+// reports false if the counter is new and the plugin already uses the
+// maximum number of counters.
+func (f *hostFactory) setWindow(plugin, key string, window time.Duration) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	windows, ok := f.windows[plugin]
+	if !ok {
+		windows = map[string]time.Duration{}
+		f.windows[plugin] = windows
+	}
+	if _, ok := windows[key]; !ok {
+		if max := viper.GetInt(config.FlagPluginsHostMaxEntries.GetLong()); max > 0 && len(windows) >= max {
+			return false
+		}
+	}
+	if window > windows[key] {
+		windows[key] = window
+	}
+	return true
+}
+
+// deleteExpired removes a key if it is still expired. The key may
+// have been set again since it was found to be expired.
+func (f *hostFactory) deleteExpired(plugin, key string, currTime time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if entry, ok := f.entries[plugin][key]; ok && entry.isExpired(currTime) {
+		delete(f.entries[plugin], key)
+	}
+}
+
+func validateCounter(plugin, key string, window time.Duration) error {
+	if window <= 0 {
+		return fmt.Errorf("invalid counter window %s", window)
+	}
+	if strings.Contains(plugin, ",") {
+		return fmt.Errorf("plugin %s can not use counters as its name contains a comma", plugin)
+	}
+	if key == "" {
+		return errors.New("counter key must not be empty")
+	}
+	if len(key) > maxCounterKeyLength {
+		return fmt.Errorf("counter key must not be longer than %d characters", maxCounterKeyLength)
+	}
+	if strings.Contains(key, ",") {
+		return errors.New("counter key must not contain a comma")
+	}
+	return nil
+}
+
+func (f *hostFactory) clear() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for k := range f.entries {
+		delete(f.entries, k)
+	}
+	for k := range f.windows {
+		delete(f.windows, k)
+	}
+}
+
+// RunHostSweeper periodically removes expired keys from the key/value
+// store of every plugin and increments that are outside the window of
+// their counter.
+func RunHostSweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		hostStore.sweep(time.Now(), viper.GetDuration(config.FlagServerTrafficRetention.GetLong()))
+	}
+}
+
+func (f *hostFactory) sweep(currTime time.Time, retention time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for plugin, entries := range f.entries {
+		removeExpired(entries, currTime)
+		if len(entries) < 1 {
+			delete(f.entries, plugin)
+		}
+	}
+	spec.TrafficStore.Trim(hostTrafficNamespace, func(plugin, key string) time.Time {
+		if window, ok := f.windows[plugin][key]; ok {
+			return currTime.Add(-window)
+		}
+		if retention > 0 {
+			return currTime.Add(-retention)
+		}
+		return time.Time{}
+	})
+	// windows are forgotten along with the last increment of their counter
+	for plugin, windows := range f.windows {
+		for key := range windows {
+			if spec.TrafficStore.Count(hostTrafficNamespace, plugin, key, time.Time{}) < 1 {
+				delete(windows, key)
+			}
+		}
+		if len(windows) < 1 {
+			delete(f.windows, plugin)
+		}
+	}
+}
+
+func removeExpired(entries map[string]hostEntry, currTime time.Time) {
+	for key, entry := range entries {
+		if entry.isExpired(currTime) {
+			delete(entries, key)
+		}
+	}
+}
+
+// copyValue copies a value so that neither plugins nor the
+// store can modify the other's copy
+func copyValue(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/traffic"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHostPlugin struct {
+	fakePlugin
+	host Host
+}
+
+func (plugin *fakeHostPlugin) SetHost(host Host) {
+	plugin.host = host
+}
+
+func TestHostKeyValue(t *testing.T) {
+	defer hostStore.clear()
+	one, two := newHost("one"), newHost("two")
+
+	_, ok := one.Get("foo")
+	assert.False(t, ok)
+
+	assert.Nil(t, one.Set("foo", []byte("bar"), 0))
+	value, ok := one.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+	_, ok = two.Get("foo")
+	assert.False(t, ok, "keys should be namespaced to a plugin")
+
+	one.Delete("foo")
+	_, ok = one.Get("foo")
+	assert.False(t, ok)
+
+	assert.Nil(t, one.Set("foo", []byte("bar"), time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	_, ok = one.Get("foo")
+	assert.False(t, ok, "expired keys should not be returned")
+	_, ok = hostStore.entries["one"]["foo"]
+	assert.False(t, ok, "expired keys should be removed")
+}
+
+func TestHostMaxEntries(t *testing.T) {
+	defer hostStore.clear()
+	viper.Set(config.FlagPluginsHostMaxEntries.GetLong(), 2)
+	defer viper.Set(config.FlagPluginsHostMaxEntries.GetLong(), nil)
+	one, two := newHost("one"), newHost("two")
+
+	assert.Nil(t, one.Set("foo", []byte("bar"), 0))
+	assert.Nil(t, one.Set("bar", []byte("bar"), time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, one.Set("car", []byte("bar"), 0), "expired keys should be evicted to make room")
+	assert.Equal(t, "plugin one can not store more than 2 keys", one.Set("dar", []byte("bar"), 0).Error())
+	assert.Nil(t, one.Set("foo", []byte("car"), 0), "existing keys should be replaceable")
+	assert.Nil(t, two.Set("foo", []byte("bar"), 0), "the limit should apply to each plugin")
+}
+
+func TestHostMaxCounters(t *testing.T) {
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+	defer hostStore.clear()
+	viper.Set(config.FlagPluginsHostMaxEntries.GetLong(), 2)
+	defer viper.Set(config.FlagPluginsHostMaxEntries.GetLong(), nil)
+	one, two := newHost("one"), newHost("two")
+
+	_, err := one.Increment("tenant-a", time.Minute)
+	assert.Nil(t, err)
+	_, err = one.Count("tenant-b", time.Minute)
+	assert.Nil(t, err)
+	_, err = one.Increment("tenant-c", time.Minute)
+	assert.Equal(t, "plugin one can not use more than 2 counters", err.Error())
+	assert.Equal(t, 0, spec.TrafficStore.Count(hostTrafficNamespace, "one", "tenant-c", time.Time{}), "rejected increments should not be recorded")
+	count, err := one.Count("tenant-c", time.Minute)
+	assert.Nil(t, err, "counters can be counted beyond the limit")
+	assert.Equal(t, 0, count)
+	_, ok := hostStore.windows["one"]["tenant-c"]
+	assert.False(t, ok, "counters beyond the limit should not be recorded")
+
+	count, err = one.Increment("tenant-a", time.Hour)
+	assert.Nil(t, err, "existing counters should be usable")
+	assert.Equal(t, 2, count)
+	_, err = two.Increment("tenant-c", time.Minute)
+	assert.Nil(t, err, "the limit should apply to each plugin")
+
+	hostStore.sweep(time.Now(), 0)
+	_, err = one.Increment("tenant-c", time.Minute)
+	assert.Nil(t, err, "counters that were never incremented should be forgotten to make room")
+}
+
+func TestHostValueCopy(t *testing.T) {
+	defer hostStore.clear()
+	one := newHost("one")
+
+	value := []byte("bar")
+	assert.Nil(t, one.Set("foo", value, 0))
+	value[0] = 'c'
+	stored, _ := one.Get("foo")
+	assert.Equal(t, "bar", string(stored), "the stored value should not change with the value that was set")
+	stored[0] = 'c'
+	stored, _ = one.Get("foo")
+	assert.Equal(t, "bar", string(stored), "the stored value should not change with the value that was returned")
+}
+
+func TestHostDeleteExpired(t *testing.T) {
+	defer hostStore.clear()
+	one := newHost("one")
+
+	currTime := time.Now()
+	assert.Nil(t, one.Set("foo", []byte("bar"), time.Hour))
+	hostStore.deleteExpired("one", "foo", currTime)
+	_, ok := one.Get("foo")
+	assert.True(t, ok, "a key that was set again since it expired should be kept")
+	hostStore.deleteExpired("one", "foo", currTime.Add(2*time.Hour))
+	_, ok = one.Get("foo")
+	assert.False(t, ok)
+}
+
+func TestHostSweep(t *testing.T) {
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+	defer hostStore.clear()
+	one := newHost("one")
+
+	assert.Nil(t, one.Set("foo", []byte("bar"), time.Nanosecond))
+	assert.Nil(t, one.Set("bar", []byte("bar"), 0))
+	_, err := one.Increment("tenant-a", time.Minute)
+	assert.Nil(t, err)
+	_, err = one.Count("tenant-a", time.Hour)
+	assert.Nil(t, err)
+	_, err = one.Increment("tenant-b", time.Minute)
+	assert.Nil(t, err)
+	traffic.Emit(hostTrafficNamespace, "one", "tenant-c")
+
+	hostStore.sweep(time.Now().Add(30*time.Minute), 10*time.Minute)
+	_, ok := hostStore.entries["one"]["foo"]
+	assert.False(t, ok, "expired keys should be removed")
+	_, ok = hostStore.entries["one"]["bar"]
+	assert.True(t, ok)
+	count, _ := one.Count("tenant-a", time.Hour)
+	assert.Equal(t, 1, count, "increments within the largest window of a counter should be kept")
+	assert.Equal(t, 0, spec.TrafficStore.Count(hostTrafficNamespace, "one", "tenant-b", time.Time{}), "increments outside the window should be removed")
+	assert.Equal(t, 0, spec.TrafficStore.Count(hostTrafficNamespace, "one", "tenant-c", time.Time{}), "increments of unknown counters should be kept for the retention")
+	_, ok = hostStore.windows["one"]["tenant-b"]
+	assert.False(t, ok, "windows of empty counters should be forgotten")
+
+	hostStore.sweep(time.Now().Add(2*time.Hour), 0)
+	assert.True(t, spec.TrafficStore.IsEmpty())
+	assert.Equal(t, 0, len(hostStore.windows))
+}
+
+func TestHostCounters(t *testing.T) {
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+	defer hostStore.clear()
+	one, two := newHost("one"), newHost("two")
+
+	count, err := one.Count("tenant-a", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	for i := 1; i <= 3; i++ {
+		count, err = one.Increment("tenant-a", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, i, count)
+	}
+	count, _ = two.Count("tenant-a", time.Minute)
+	assert.Equal(t, 0, count, "counters should be namespaced to a plugin")
+	assert.Equal(t, 3, spec.TrafficStore.Count(hostTrafficNamespace, "one", "tenant-a", time.Now().Add(-time.Minute)))
+
+	_, err = one.Increment("tenant-a", 0)
+	assert.Equal(t, "invalid counter window 0s", err.Error())
+	count, _ = one.Count("tenant-a", time.Minute)
+	assert.Equal(t, 3, count, "invalid increments should not be recorded")
+
+	_, err = one.Count("", time.Minute)
+	assert.Equal(t, "counter key must not be empty", err.Error())
+	_, err = one.Increment("a,b", time.Minute)
+	assert.Equal(t, "counter key must not contain a comma", err.Error())
+	_, err = one.Increment(strings.Repeat("a", 254), time.Minute)
+	assert.Equal(t, "counter key must not be longer than 253 characters", err.Error())
+	_, err = newHost("a,b").Increment("tenant-a", time.Minute)
+	assert.Equal(t, "plugin a,b can not use counters as its name contains a comma", err.Error())
+}
+
+func TestRegistrySetHost(t *testing.T) {
+	plugin := &fakeHostPlugin{}
+	registry := newRegistry(func(p spec.Plugin) (*Plugin, error) {
+		var loaded Plugin = plugin
+		return &loaded, nil
+	})
+	_, err := registry.Get(spec.Plugin{Name: "host", Version: "1.0.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, "host", plugin.host.(host).plugin)
+}
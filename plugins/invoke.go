@@ -0,0 +1,238 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+const (
+	onErrorFail = "fail"
+	onErrorSkip = "skip"
+)
+
+// Hook identifies a plugin lifecycle hook
+type Hook string
+
+const (
+	// OnRequestHook is invoked before a request is proxied upstream
+	OnRequestHook Hook = "OnRequest"
+	// OnResponseHook is invoked before a response is written to the client
+	OnResponseHook Hook = "OnResponse"
+)
+
+func (h Hook) metricName() string {
+	if h == OnRequestHook {
+		return "on_request"
+	}
+	return "on_response"
+}
+
+// Invoke calls fn, which executes a single lifecycle hook of a plugin,
+// with a context bounded by the plugin's timeout. The duration and outcome
+// of the call are recorded as metrics. If the call fails and the plugin's
+// onError policy is skip, or if an external plugin is unavailable and its
+// failure policy is failOpen, the error is logged and the request is
+// allowed to proceed.
+//
+// Plugins that run in the Kanali process are called in their own goroutine
+// so that a plugin that does not respect the context it is given can not
+// block a request past its timeout or after the client has gone away. Such
+// a plugin may continue to run in the background, so it is handed copies of
+// the metrics, request and response, which is nil for OnRequest, and its
+// changes are only applied if it returns in time. A plugin that is abandoned
+// after it started reading the request or response body always fails the
+// request as the body can no longer be passed on intact. Once too many calls
+// to a plugin have been abandoned and are still running, further calls are
+// rejected until some of them return.
+func Invoke(ctx context.Context, m *metrics.Metrics, plugin spec.Plugin, hook Hook, req *http.Request, resp *http.Response, fn func(context.Context, *metrics.Metrics, *http.Request, *http.Response) error) error {
+	timeout, err := getTimeout(plugin)
+	if err != nil {
+		return utils.StatusError{Code: http.StatusInternalServerError, Err: err}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	t0 := time.Now()
+	outcome, consumed, err := call(ctx, m, plugin, hook, timeout, req, resp, fn)
+	if m != nil {
+		name := fmt.Sprintf("plugin_%s_%s", plugin.Name, hook.metricName())
+		m.Add(
+			metrics.Metric{Name: name + "_time", Value: int(time.Now().Sub(t0) / time.Millisecond), Index: false},
+			metrics.Metric{Name: name + "_outcome", Value: outcome, Index: true},
+		)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(UnavailableError); ok && plugin.External != nil && plugin.External.FailurePolicy == externalFailOpen {
+		logrus.Warnf("%s. proceeding as its failure policy is %s", err.Error(), externalFailOpen)
+		return nil
+	}
+	if plugin.OnError == onErrorSkip && !consumed {
+		logrus.Warnf("%s of plugin %s failed: %s. proceeding as its onError policy is %s", hook, plugin.Name, err.Error(), onErrorSkip)
+		return nil
+	}
+	return err
+}
+
+// call invokes fn and reports its outcome and whether an abandoned plugin
+// had consumed part of the request or response body
+func call(ctx context.Context, m *metrics.Metrics, plugin spec.Plugin, hook Hook, timeout time.Duration, req *http.Request, resp *http.Response, fn func(context.Context, *metrics.Metrics, *http.Request, *http.Response) error) (string, bool, error) {
+	// external plugins are bounded by the deadline of the gRPC call
+	if plugin.External != nil {
+		if err := fn(ctx, m, req, resp); err != nil {
+			return "error", false, err
+		}
+		return "success", false, nil
+	}
+
+	key := registryKey(plugin)
+	if max := viper.GetInt(config.FlagPluginsMaxAbandonedCalls.GetLong()); max > 0 && abandonedCalls.count(key) >= max {
+		return "rejected", false, utils.StatusError{
+			Code: http.StatusServiceUnavailable,
+			Err:  fmt.Errorf("plugin %s has %d calls that did not complete in time", plugin.Name, max),
+		}
+	}
+
+	type result struct {
+		outcome string
+		err     error
+	}
+	s := newSandbox(req, resp)
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logrus.Errorf("%s paniced: %v", hook, r)
+				done <- result{"panic", fmt.Errorf("%s paniced", hook)}
+			}
+		}()
+		if err := fn(ctx, s.m, s.req, s.resp); err != nil {
+			done <- result{"error", err}
+			return
+		}
+		done <- result{"success", nil}
+	}()
+
+	select {
+	case r := <-done:
+		s.apply(m, req, resp)
+		return r.outcome, false, r.err
+	case <-ctx.Done():
+		consumed := s.abandon()
+		abandonedCalls.add(key)
+		go func() {
+			<-done
+			abandonedCalls.done(key)
+		}()
+		if ctx.Err() == context.DeadlineExceeded {
+			return "timeout", consumed, utils.StatusError{
+				Code: http.StatusGatewayTimeout,
+				Err:  fmt.Errorf("%s of plugin %s did not complete within %s", hook, plugin.Name, timeout),
+			}
+		}
+		return "cancelled", consumed, utils.StatusError{
+			Code: http.StatusServiceUnavailable,
+			Err:  fmt.Errorf("request was cancelled during %s of plugin %s", hook, plugin.Name),
+		}
+	}
+}
+
+// abandonedCalls counts, per plugin, the calls that were abandoned
+// because they did not complete in time and are still running
+var abandonedCalls = &callCounter{counts: map[string]int{}}
+
+type callCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func (c *callCounter) count(key string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.counts[key]
+}
+
+func (c *callCounter) add(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[key]++
+}
+
+func (c *callCounter) done(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts[key]--; c.counts[key] < 1 {
+		delete(c.counts, key)
+	}
+}
+
+// getTimeout returns the timeout of a single call to a plugin. Plugins
+// that run in the Kanali process have no timeout unless one is specified.
+func getTimeout(plugin spec.Plugin) (time.Duration, error) {
+	if plugin.Timeout != "" {
+		d, err := time.ParseDuration(plugin.Timeout)
+		if err != nil {
+			return 0, fmt.Errorf("invalid plugin timeout %s", plugin.Timeout)
+		}
+		return d, nil
+	}
+	if plugin.External == nil {
+		return 0, nil
+	}
+	if plugin.External.Timeout != "" {
+		d, err := time.ParseDuration(plugin.External.Timeout)
+		if err != nil {
+			return 0, fmt.Errorf("invalid external plugin timeout %s", plugin.External.Timeout)
+		}
+		return d, nil
+	}
+	return viper.GetDuration(config.FlagPluginsExternalTimeout.GetLong()), nil
+}
+
+func validatePolicy(plugin spec.Plugin) error {
+	if plugin.Timeout != "" {
+		if _, err := time.ParseDuration(plugin.Timeout); err != nil {
+			return fmt.Errorf("invalid plugin timeout %s", plugin.Timeout)
+		}
+	}
+	switch plugin.OnError {
+	case "", onErrorFail, onErrorSkip:
+		return nil
+	}
+	return fmt.Errorf("invalid plugin onError policy %s", plugin.OnError)
+}
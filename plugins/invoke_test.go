@@ -0,0 +1,269 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoke(t *testing.T) {
+	m := &metrics.Metrics{}
+	called := false
+	assert.Nil(t, Invoke(context.Background(), m, spec.Plugin{Name: "local"}, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		_, ok := ctx.Deadline()
+		assert.False(t, ok, "local plugins should not have a timeout by default")
+		called = true
+		return nil
+	}))
+	assert.True(t, called)
+	assert.Equal(t, "success", m.Get("plugin_local_on_request_outcome").Value)
+	assert.NotNil(t, m.Get("plugin_local_on_request_time"))
+
+	err := Invoke(context.Background(), m, spec.Plugin{Name: "local"}, OnResponseHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		return utils.StatusError{Code: http.StatusForbidden, Err: errors.New("forbidden")}
+	})
+	assert.Equal(t, "forbidden", err.Error())
+	assert.Equal(t, "error", m.Get("plugin_local_on_response_outcome").Value)
+
+	err = Invoke(context.Background(), nil, spec.Plugin{Name: "local"}, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		panic("intentional")
+	})
+	assert.Equal(t, "OnRequest paniced", err.Error())
+
+	assert.Equal(t, "invalid plugin timeout foo", Invoke(context.Background(), nil, spec.Plugin{Name: "local", Timeout: "foo"}, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		return nil
+	}).Error())
+}
+
+func TestInvokeTimeout(t *testing.T) {
+	m := &metrics.Metrics{}
+	plugin := spec.Plugin{Name: "local", Timeout: "10ms"}
+	block := make(chan struct{})
+	defer close(block)
+	hang := func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		// this plugin ignores its context
+		<-block
+		return nil
+	}
+
+	t0 := time.Now()
+	err := Invoke(context.Background(), m, plugin, OnRequestHook, nil, nil, hang)
+	assert.True(t, time.Now().Sub(t0) < time.Second, "a hanging plugin should not block past its timeout")
+	assert.Equal(t, "OnRequest of plugin local did not complete within 10ms", err.Error())
+	assert.Equal(t, http.StatusGatewayTimeout, err.(utils.StatusError).Status())
+	assert.Equal(t, "timeout", m.Get("plugin_local_on_request_outcome").Value)
+
+	plugin.OnError = "skip"
+	assert.Nil(t, Invoke(context.Background(), nil, plugin, OnRequestHook, nil, nil, hang))
+	assert.Nil(t, Invoke(context.Background(), nil, plugin, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		return errors.New("forbidden")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Invoke(ctx, nil, spec.Plugin{Name: "local"}, OnResponseHook, nil, nil, hang)
+	assert.Equal(t, "request was cancelled during OnResponse of plugin local", err.Error())
+}
+
+func TestInvokeSandbox(t *testing.T) {
+	m := &metrics.Metrics{}
+	req := httptest.NewRequest("GET", "/foo", nil)
+	resp := &http.Response{Header: http.Header{}, Request: req}
+	assert.Nil(t, Invoke(context.Background(), m, spec.Plugin{Name: "local"}, OnResponseHook, req, resp, func(ctx context.Context, m *metrics.Metrics, req *http.Request, resp *http.Response) error {
+		m.Add(metrics.Metric{Name: "foo", Value: "bar"})
+		req.Header.Set("X-Request", "foo")
+		resp.Header.Set("X-Response", "bar")
+		assert.Equal(t, req, resp.Request)
+		return nil
+	}))
+	assert.Equal(t, "foo", req.Header.Get("X-Request"), "changes should be applied once the plugin returns")
+	assert.Equal(t, "bar", resp.Header.Get("X-Response"))
+	assert.Equal(t, req, resp.Request)
+	assert.Equal(t, "bar", m.Get("foo").Value)
+
+	// a plugin that panics may be interrupted at any point
+	assert.NotNil(t, Invoke(context.Background(), nil, spec.Plugin{Name: "local"}, OnRequestHook, req, nil, func(ctx context.Context, _ *metrics.Metrics, req *http.Request, _ *http.Response) error {
+		req.Header.Set("X-Request", "bar")
+		panic("intentional")
+	}))
+	assert.Equal(t, "bar", req.Header.Get("X-Request"))
+
+	err := Invoke(context.Background(), nil, spec.Plugin{Name: "local"}, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		return UnavailableError{Plugin: "local", Err: errors.New("unavailable")}
+	})
+	assert.Equal(t, "external plugin local is unavailable: unavailable", err.Error(), "only external plugins have a failure policy")
+}
+
+func TestInvokeTimeoutSkip(t *testing.T) {
+	plugin := spec.Plugin{Name: "local", Timeout: "10ms", OnError: "skip"}
+	req := httptest.NewRequest("POST", "/foo", strings.NewReader("foo"))
+	block := make(chan struct{})
+	done := make(chan struct{})
+
+	assert.Nil(t, Invoke(context.Background(), nil, plugin, OnRequestHook, req, nil, func(ctx context.Context, _ *metrics.Metrics, req *http.Request, _ *http.Response) error {
+		defer close(done)
+		// this plugin keeps modifying the request after its timeout
+		<-block
+		for i := 0; i < 100; i++ {
+			req.Header.Set("X-Plugin", "foo")
+		}
+		return nil
+	}))
+	close(block)
+	for i := 0; i < 100; i++ {
+		req.Header.Set("X-Step", "bar")
+	}
+	<-done
+	assert.Equal(t, "", req.Header.Get("X-Plugin"), "an abandoned plugin should not modify the request")
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, "foo", string(body))
+
+	req = httptest.NewRequest("POST", "/foo", strings.NewReader("foo"))
+	returned := make(chan struct{})
+	reads := make(chan error, 1)
+	err := Invoke(context.Background(), nil, plugin, OnRequestHook, req, nil, func(ctx context.Context, _ *metrics.Metrics, req *http.Request, _ *http.Response) error {
+		p := make([]byte, 1)
+		req.Body.Read(p)
+		<-returned
+		_, err := req.Body.Read(p)
+		reads <- err
+		return nil
+	})
+	close(returned)
+	assert.Equal(t, "OnRequest of plugin local did not complete within 10ms", err.Error(), "a plugin that consumed the body should not be skipped")
+	assert.Equal(t, errAbandoned, <-reads)
+}
+
+func TestInvokeExternal(t *testing.T) {
+	address, stop := startFakeExternalServer(t)
+	defer stop()
+
+	plugin := spec.Plugin{Name: "external", External: &spec.ExternalPlugin{Address: address, Timeout: "10ms"}}
+	p, err := GetPlugin(plugin)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest("GET", "/foo", nil)
+	req.Header.Set("X-Test", "sleep")
+	call := func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		return (*p).OnRequest(ctx, nil, spec.APIProxy{}, req, opentracing.StartSpan("test span"))
+	}
+
+	m := &metrics.Metrics{}
+	err = Invoke(context.Background(), m, plugin, OnRequestHook, nil, nil, call)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadGateway, err.(UnavailableError).Status())
+	assert.Equal(t, "error", m.Get("plugin_external_on_request_outcome").Value)
+
+	plugin.External.FailurePolicy = "failOpen"
+	assert.Nil(t, Invoke(context.Background(), nil, plugin, OnRequestHook, nil, nil, call))
+
+	assert.Equal(t, "forbidden", Invoke(context.Background(), nil, plugin, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		return errors.New("forbidden")
+	}).Error(), "errors returned by the plugin should not be ignored")
+
+	plugin.OnError = "skip"
+	assert.Nil(t, Invoke(context.Background(), nil, plugin, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		return errors.New("forbidden")
+	}))
+
+	plugin.Timeout = "1h"
+	assert.Nil(t, Invoke(context.Background(), nil, plugin, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		deadline, _ := ctx.Deadline()
+		assert.True(t, deadline.Sub(time.Now()) > time.Minute, "the plugin timeout should take precedence")
+		return nil
+	}))
+
+	plugin.Timeout = ""
+	plugin.External.Timeout = "foo"
+	assert.Equal(t, "invalid external plugin timeout foo", Invoke(context.Background(), nil, plugin, OnRequestHook, nil, nil, call).Error())
+}
+
+func TestGetTimeout(t *testing.T) {
+	d, err := getTimeout(spec.Plugin{Name: "local"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), d)
+	d, _ = getTimeout(spec.Plugin{Name: "local", Timeout: "250ms"})
+	assert.Equal(t, 250*time.Millisecond, d)
+	d, _ = getTimeout(spec.Plugin{Name: "external", External: &spec.ExternalPlugin{Address: "localhost:9000", Timeout: "2s"}})
+	assert.Equal(t, 2*time.Second, d)
+	d, _ = getTimeout(spec.Plugin{Name: "external", Timeout: "3s", External: &spec.ExternalPlugin{Address: "localhost:9000", Timeout: "2s"}})
+	assert.Equal(t, 3*time.Second, d)
+}
+
+func TestValidatePolicy(t *testing.T) {
+	assert.Nil(t, validatePolicy(spec.Plugin{Name: "local"}))
+	assert.Nil(t, validatePolicy(spec.Plugin{Name: "local", Timeout: "100ms", OnError: "skip"}))
+	assert.Nil(t, validatePolicy(spec.Plugin{Name: "local", OnError: "fail"}))
+	assert.Equal(t, "invalid plugin timeout foo", validatePolicy(spec.Plugin{Name: "local", Timeout: "foo"}).Error())
+	assert.Equal(t, "invalid plugin onError policy ignore", validatePolicy(spec.Plugin{Name: "local", OnError: "ignore"}).Error())
+}
+
+func TestInvokeMaxAbandonedCalls(t *testing.T) {
+	viper.Set(config.FlagPluginsMaxAbandonedCalls.GetLong(), 1)
+	defer viper.Set(config.FlagPluginsMaxAbandonedCalls.GetLong(), nil)
+
+	plugin := spec.Plugin{Name: "local", Timeout: "10ms"}
+	block := make(chan struct{})
+	returned := make(chan struct{})
+	m := &metrics.Metrics{}
+	err := Invoke(context.Background(), m, plugin, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		defer close(returned)
+		<-block
+		return nil
+	})
+	assert.Equal(t, http.StatusGatewayTimeout, err.(utils.StatusError).Status())
+	assert.Equal(t, 1, abandonedCalls.count("local"))
+
+	called := false
+	m = &metrics.Metrics{}
+	err = Invoke(context.Background(), m, plugin, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		called = true
+		return nil
+	})
+	assert.False(t, called, "a plugin with too many abandoned calls should not be called")
+	assert.Equal(t, "plugin local has 1 calls that did not complete in time", err.Error())
+	assert.Equal(t, http.StatusServiceUnavailable, err.(utils.StatusError).Status())
+	assert.Equal(t, "rejected", m.Get("plugin_local_on_request_outcome").Value)
+
+	close(block)
+	<-returned
+	for abandonedCalls.count("local") > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, Invoke(context.Background(), nil, plugin, OnRequestHook, nil, nil, func(ctx context.Context, _ *metrics.Metrics, _ *http.Request, _ *http.Response) error {
+		return nil
+	}), "calls should be accepted once abandoned calls return")
+}
@@ -22,10 +22,12 @@ package plugins
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	pluginPkg "plugin"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/northwesternmutual/kanali/config"
 	"github.com/northwesternmutual/kanali/metrics"
 	"github.com/northwesternmutual/kanali/spec"
@@ -43,9 +45,81 @@ type Plugin interface {
 	OnResponse(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, req *http.Request, resp *http.Response, span opentracing.Span) error
 }
 
-// GetPlugin will use the Go plugin package and extract
+// ConfigurablePlugin is a Plugin that accepts the configuration defined for
+// it on each APIProxy. If a plugin implements this interface, these methods
+// will be used in place of OnRequest and OnResponse.
+type ConfigurablePlugin interface {
+	Plugin
+	OnRequestWithConfig(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, span opentracing.Span) error
+	OnResponseWithConfig(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, resp *http.Response, span opentracing.Span) error
+}
+
+// RequestResult is the result of a plugin's OnRequest lifecycle hook. If
+// Response is set, the request is not proxied upstream. Instead, the response
+// is passed to the OnResponse lifecycle hook of every plugin and returned to
+// the client.
+type RequestResult struct {
+	Response *http.Response
+}
+
+// ResponderPlugin is a Plugin that may respond to a request itself, for
+// example to serve a cached response, redirect or issue an authentication
+// challenge. If a plugin implements this interface, OnRequestWithResult
+// will be used in place of OnRequest and OnRequestWithConfig.
+type ResponderPlugin interface {
+	Plugin
+	OnRequestWithResult(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, span opentracing.Span) (*RequestResult, error)
+}
+
+// Lifecycle is implemented by plugins that need to set up resources, such as
+// connection pools or background workers, before they are used and to clean
+// them up when Kanali shuts down. Init is invoked once when the plugin is
+// loaded with the configuration under plugins.<plugin name> and a logger.
+// Healthy is invoked by readiness checks.
+type Lifecycle interface {
+	Init(config map[string]interface{}, logger *logrus.Entry) error
+	Close() error
+	Healthy() error
+}
+
+// SchemaProvider is implemented by plugins that export a JSON schema
+// describing the configuration they accept
+type SchemaProvider interface {
+	ConfigSchema() []byte
+}
+
+// ValidateConfig will validate a plugin's configuration against the
+// schema exported by the plugin, if any
+func ValidateConfig(p Plugin, config json.RawMessage) error {
+	provider, ok := p.(SchemaProvider)
+	if !ok {
+		return nil
+	}
+	schema, err := ParseSchema(provider.ConfigSchema())
+	if err != nil {
+		return err
+	}
+	return schema.Validate(config)
+}
+
+// GetPlugin will return a client for an external plugin, a plugin registered
+// with Register or, if there is none, use the Go plugin package and extract
 // the plugin
 func GetPlugin(plugin spec.Plugin) (*Plugin, error) {
+	if plugin.External != nil {
+		return newExternalPlugin(plugin)
+	}
+
+	if p, ok := getCompiledPlugin(plugin); ok {
+		if p == nil {
+			return nil, utils.StatusError{
+				Code: http.StatusInternalServerError,
+				Err:  fmt.Errorf("plugin %s was registered with a factory that returned nil", plugin.Name),
+			}
+		}
+		return &p, nil
+	}
+
 	path, err := utils.GetAbsPath(viper.GetString(config.FlagPluginsLocation.GetLong()))
 	if err != nil {
 		return nil, utils.StatusError{Code: http.StatusInternalServerError, Err: fmt.Errorf("file path %s could not be found", viper.GetString(config.FlagPluginsLocation.GetLong()))}
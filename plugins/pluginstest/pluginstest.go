@@ -0,0 +1,230 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package pluginstest provides utilities for testing Kanali plugins without
+// running Kanali. A Harness invokes a plugin through the same steps that
+// Kanali uses for every request so that plugins can be tested against the
+// behaviour they will see in production.
+package pluginstest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/plugins"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/steps"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// Harness runs a plugin through the PluginsOnRequestStep and
+// PluginsOnResponseStep steps. The plugin is added to the plugin registry
+// and a mock tracer is installed as the global tracer until the harness is
+// closed, so harnesses must not be used by tests that run in parallel.
+type Harness struct {
+	// Proxy is the APIProxy that every request is assumed to match. It uses
+	// only the plugin under test and may be modified before a request is run.
+	Proxy spec.APIProxy
+	// Metrics holds the metrics recorded for the most recent request
+	Metrics *metrics.Metrics
+	// Tracer records the spans started by Kanali and the plugin
+	Tracer *mocktracer.MockTracer
+
+	plugin spec.Plugin
+	tracer opentracing.Tracer
+}
+
+// New returns a Harness for a plugin. The plugin is registered under the
+// name given in its definition, along with any version, configuration and
+// policies, and is initialized as it would be when Kanali loads it. The
+// configuration is validated against the plugin's schema, if it exports one.
+func New(plugin spec.Plugin, p plugins.Plugin) (*Harness, error) {
+	if err := plugins.Registry.Set(plugin, p); err != nil {
+		return nil, err
+	}
+	h := &Harness{
+		Proxy:   NewProxy(plugin),
+		Metrics: &metrics.Metrics{},
+		Tracer:  mocktracer.New(),
+		plugin:  plugin,
+		tracer:  opentracing.GlobalTracer(),
+	}
+	opentracing.SetGlobalTracer(h.Tracer)
+	h.Proxy.Status = plugins.Registry.Validate(h.Proxy)
+	if failed := h.Proxy.Status.GetFailedPlugin(); failed != nil {
+		h.Close()
+		return nil, fmt.Errorf("plugin %s is unavailable: %s", failed.Name, failed.GetReason())
+	}
+	return h, nil
+}
+
+// NewProxy returns an APIProxy that uses a single plugin
+// and opts out of every default plugin
+func NewProxy(plugin spec.Plugin) spec.APIProxy {
+	return spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "pluginstest",
+			Namespace: "default",
+		},
+		Spec: spec.APIProxySpec{
+			Path:                  "/",
+			Plugins:               []spec.Plugin{plugin},
+			ExcludeDefaultPlugins: []string{"*"},
+		},
+	}
+}
+
+// NewRequest returns an incoming request, as Kanali would receive it,
+// suitable for passing to OnRequest and OnResponse
+func NewRequest(method, target string, body io.Reader) *http.Request {
+	return httptest.NewRequest(method, target, body)
+}
+
+// NewResponse returns an upstream response suitable for passing to OnResponse
+func NewResponse(statusCode int, header http.Header, body string) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+// OnRequest runs a request through the PluginsOnRequestStep. If the plugin
+// responded to the request itself, its response is returned. As OnRequest
+// starts a new request, the metrics of the previous request are discarded.
+func (h *Harness) OnRequest(req *http.Request) (*http.Response, error) {
+	span := h.Tracer.StartSpan(fmt.Sprintf("%s %s", req.Method, req.URL.Path))
+	defer span.Finish()
+
+	h.Metrics = &metrics.Metrics{}
+	resp := &http.Response{}
+	if err := (steps.PluginsOnRequestStep{}).Do(req.Context(), &h.Proxy, h.Metrics, httptest.NewRecorder(), req, resp, span); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 0 {
+		return nil, nil
+	}
+	return resp, nil
+}
+
+// OnResponse runs a request and the response to it
+// through the PluginsOnResponseStep
+func (h *Harness) OnResponse(req *http.Request, resp *http.Response) error {
+	span := h.Tracer.StartSpan(fmt.Sprintf("%s %s", req.Method, req.URL.Path))
+	defer span.Finish()
+
+	if resp.Request == nil {
+		resp.Request = req
+	}
+	return (steps.PluginsOnResponseStep{}).Do(req.Context(), &h.Proxy, h.Metrics, httptest.NewRecorder(), req, resp, span)
+}
+
+// Do runs a request through the entire plugin lifecycle. The request is
+// passed to OnRequest and then, unless the plugin responded to it itself,
+// to upstream. The resulting response is passed to OnResponse and returned.
+func (h *Harness) Do(req *http.Request, upstream http.Handler) (*http.Response, error) {
+	resp, err := h.OnRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		recorder := httptest.NewRecorder()
+		upstream.ServeHTTP(recorder, req)
+		resp = recorder.Result()
+	}
+	if err := h.OnResponse(req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Metric returns the value of the first metric recorded with a name
+func (h *Harness) Metric(name string) (interface{}, bool) {
+	metric := h.Metrics.Get(name)
+	if metric == nil {
+		return nil, false
+	}
+	return metric.Value, true
+}
+
+// Close removes the plugin from the registry, closing it if it implements
+// the Lifecycle interface, and restores the previous global tracer
+func (h *Harness) Close() error {
+	opentracing.SetGlobalTracer(h.tracer)
+	return plugins.Registry.Delete(h.plugin)
+}
+
+// AssertMetric fails a test if a metric was not recorded with a value
+func (h *Harness) AssertMetric(t testing.TB, name string, value interface{}) {
+	actual, ok := h.Metric(name)
+	if !ok {
+		t.Errorf("metric %s was not recorded", name)
+		return
+	}
+	if !reflect.DeepEqual(actual, value) {
+		t.Errorf("metric %s is %v, expected %v", name, actual, value)
+	}
+}
+
+// AssertHeader fails a test if a header of a request or response does not have a value
+func AssertHeader(t testing.TB, header http.Header, key, value string) {
+	if actual := header.Get(key); actual != value {
+		t.Errorf("header %s is %q, expected %q", key, actual, value)
+	}
+}
+
+// AssertResponse fails a test if a response does not have a status code and
+// body. The body is read and replaced so that it can be read again.
+func AssertResponse(t testing.TB, resp *http.Response, statusCode int, body string) {
+	if resp == nil {
+		t.Errorf("response is nil, expected %d", statusCode)
+		return
+	}
+	if resp.StatusCode != statusCode {
+		t.Errorf("response status code is %d, expected %d", resp.StatusCode, statusCode)
+	}
+	actual, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("could not read response body: %s", err.Error())
+		return
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(actual))
+	if string(actual) != body {
+		t.Errorf("response body is %q, expected %q", string(actual), body)
+	}
+}
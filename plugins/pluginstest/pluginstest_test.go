@@ -0,0 +1,208 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pluginstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/plugins"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type examplePlugin struct {
+	closed bool
+}
+
+func (p *examplePlugin) OnRequest(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, req *http.Request, span opentracing.Span) error {
+	return errors.New("OnRequestWithResult should be used")
+}
+
+func (p *examplePlugin) OnResponse(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, req *http.Request, resp *http.Response, span opentracing.Span) error {
+	return errors.New("OnResponseWithConfig should be used")
+}
+
+func (p *examplePlugin) OnRequestWithResult(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, span opentracing.Span) (*plugins.RequestResult, error) {
+	c := struct {
+		Header string `json:"header"`
+	}{}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	switch req.Header.Get("X-Test") {
+	case "forbidden":
+		return nil, utils.StatusError{Code: http.StatusForbidden, Err: errors.New("forbidden")}
+	case "cached":
+		return &plugins.RequestResult{Response: NewResponse(http.StatusOK, nil, "cached")}, nil
+	}
+	m.Add(metrics.Metric{Name: "example", Value: "request", Index: true})
+	span.SetTag("example", true)
+	req.Header.Set(c.Header, "request")
+	return &plugins.RequestResult{}, nil
+}
+
+func (p *examplePlugin) OnRequestWithConfig(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, span opentracing.Span) error {
+	return errors.New("OnRequestWithResult should be used")
+}
+
+func (p *examplePlugin) OnResponseWithConfig(ctx context.Context, m *metrics.Metrics, proxy spec.APIProxy, config json.RawMessage, req *http.Request, resp *http.Response, span opentracing.Span) error {
+	resp.Header.Set("X-Example", "response")
+	return nil
+}
+
+func (p *examplePlugin) ConfigSchema() []byte {
+	return []byte(`{"type":"object","required":["header"],"properties":{"header":{"type":"string"}}}`)
+}
+
+func (p *examplePlugin) Init(config map[string]interface{}, logger *logrus.Entry) error {
+	return nil
+}
+
+func (p *examplePlugin) Close() error {
+	p.closed = true
+	return nil
+}
+
+func (p *examplePlugin) Healthy() error {
+	return nil
+}
+
+func TestHarness(t *testing.T) {
+	p := &examplePlugin{}
+	h, err := New(spec.Plugin{Name: "example", Config: json.RawMessage(`{"header":"X-Foo"}`)}, p)
+	assert.Nil(t, err)
+
+	req := NewRequest("GET", "/foo", nil)
+	resp, err := h.OnRequest(req)
+	assert.Nil(t, err)
+	assert.Nil(t, resp)
+	AssertHeader(t, req.Header, "X-Foo", "request")
+	h.AssertMetric(t, "example", "request")
+	h.AssertMetric(t, "plugin_example_on_request_outcome", "success")
+
+	resp = NewResponse(http.StatusOK, nil, "hello")
+	assert.Nil(t, h.OnResponse(req, resp))
+	AssertHeader(t, resp.Header, "X-Example", "response")
+	AssertResponse(t, resp, http.StatusOK, "hello")
+	AssertResponse(t, resp, http.StatusOK, "hello")
+
+	spans := h.Tracer.FinishedSpans()
+	assert.Equal(t, "PLUGIN: ON_REQUEST: example", spans[0].OperationName)
+	assert.Equal(t, true, spans[0].Tag("example"))
+
+	req = NewRequest("GET", "/foo", nil)
+	req.Header.Set("X-Test", "forbidden")
+	_, err = h.OnRequest(req)
+	assert.Equal(t, http.StatusForbidden, err.(utils.Error).Status())
+	h.AssertMetric(t, "plugin_example_on_request_outcome", "error")
+	_, ok := h.Metric("example")
+	assert.False(t, ok, "metrics should be reset for every request")
+
+	assert.Nil(t, h.Close())
+	assert.True(t, p.closed)
+	_, err = plugins.Registry.Get(spec.Plugin{Name: "example"})
+	assert.NotNil(t, err, "the plugin should be removed from the registry")
+	assert.NotEqual(t, h.Tracer, opentracing.GlobalTracer())
+}
+
+func TestHarnessDo(t *testing.T) {
+	h, err := New(spec.Plugin{Name: "example", Config: json.RawMessage(`{"header":"X-Foo"}`)}, &examplePlugin{})
+	assert.Nil(t, err)
+	defer h.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.Header.Get("X-Foo")))
+	})
+
+	resp, err := h.Do(NewRequest("POST", "/foo", strings.NewReader("body")), upstream)
+	assert.Nil(t, err)
+	AssertResponse(t, resp, http.StatusCreated, "request")
+	AssertHeader(t, resp.Header, "X-Example", "response")
+
+	req := NewRequest("GET", "/foo", nil)
+	req.Header.Set("X-Test", "cached")
+	resp, err = h.Do(req, upstream)
+	assert.Nil(t, err)
+	AssertResponse(t, resp, http.StatusOK, "cached")
+	AssertHeader(t, resp.Header, "X-Example", "response")
+	h.AssertMetric(t, "short_circuit_plugin", "example")
+}
+
+func TestHarnessInvalidConfig(t *testing.T) {
+	h, err := New(spec.Plugin{Name: "example"}, &examplePlugin{})
+	assert.Nil(t, h)
+	assert.Equal(t, "plugin example is unavailable: invalid config: config.header is required", err.Error())
+	_, err = plugins.Registry.Get(spec.Plugin{Name: "example"})
+	assert.NotNil(t, err, "the plugin should be removed from the registry")
+}
+
+func TestNewResponse(t *testing.T) {
+	resp := NewResponse(http.StatusNotFound, http.Header{"X-Foo": []string{"bar"}}, "not found")
+	assert.Equal(t, "404 Not Found", resp.Status)
+	assert.Equal(t, int64(9), resp.ContentLength)
+	assert.Equal(t, "bar", resp.Header.Get("X-Foo"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "not found", string(body))
+}
+
+type fakeTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *fakeTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	h := &Harness{Metrics: &metrics.Metrics{{Name: "foo", Value: "bar"}}}
+	tb := &fakeTB{}
+	h.AssertMetric(tb, "foo", "bar")
+	AssertHeader(tb, http.Header{"X-Foo": []string{"bar"}}, "X-Foo", "bar")
+	AssertResponse(tb, NewResponse(http.StatusOK, nil, "ok"), http.StatusOK, "ok")
+	assert.Equal(t, 0, len(tb.errors))
+
+	h.AssertMetric(tb, "missing", "bar")
+	h.AssertMetric(tb, "foo", "car")
+	AssertHeader(tb, http.Header{}, "X-Foo", "bar")
+	AssertResponse(tb, NewResponse(http.StatusOK, nil, "ok"), http.StatusNotFound, "not found")
+	AssertResponse(tb, nil, http.StatusOK, "")
+	assert.Equal(t, []string{
+		"metric missing was not recorded",
+		"metric foo is bar, expected car",
+		`header X-Foo is "", expected "bar"`,
+		"response status code is 200, expected 404",
+		`response body is "ok", expected "not found"`,
+		"response is nil, expected 200",
+	}, tb.errors)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/northwesternmutual/kanali/spec"
+)
+
+// Factory creates an instance of a plugin that has been compiled into Kanali
+type Factory func() Plugin
+
+var compiled = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: map[string]Factory{}}
+
+// Register makes a plugin that has been compiled into Kanali available
+// under a name and version. Compiled in plugins take precedence over
+// plugins with the same name and version in the plugins location.
+// If Register is called twice with the same name and version or if
+// the factory is nil, it panics.
+func Register(name, version string, factory Factory) {
+	compiled.Lock()
+	defer compiled.Unlock()
+	if factory == nil {
+		panic(fmt.Sprintf("plugin %s has a nil factory", name))
+	}
+	key := spec.Plugin{Name: name, Version: version}.GetFileName()
+	if _, ok := compiled.factories[key]; ok {
+		panic(fmt.Sprintf("plugin %s has already been registered", key))
+	}
+	compiled.factories[key] = factory
+}
+
+func getCompiledPlugin(plugin spec.Plugin) (Plugin, bool) {
+	compiled.RLock()
+	defer compiled.RUnlock()
+	factory, ok := compiled.factories[plugin.GetFileName()]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"testing"
+
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	defer func() {
+		compiled.factories = map[string]Factory{}
+	}()
+
+	Register("compiled", "1.0.0", func() Plugin { return fakePlugin{} })
+	Register("compiled", "", func() Plugin { return fakePlugin{} })
+	Register("nil", "", func() Plugin { return nil })
+
+	assert.Panics(t, func() { Register("compiled", "1.0.0", func() Plugin { return fakePlugin{} }) })
+	assert.Panics(t, func() { Register("other", "", nil) })
+
+	p, err := GetPlugin(spec.Plugin{Name: "compiled", Version: "1.0.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, fakePlugin{}, *p)
+
+	p, err = GetPlugin(spec.Plugin{Name: "compiled"})
+	assert.Nil(t, err)
+	assert.Equal(t, fakePlugin{}, *p)
+
+	p, err = GetPlugin(spec.Plugin{Name: "nil"})
+	assert.Nil(t, p)
+	assert.Equal(t, "plugin nil was registered with a factory that returned nil", err.Error())
+
+	p, err = GetPlugin(spec.Plugin{Name: "compiled", Version: "2.0.0"})
+	assert.Nil(t, p)
+	assert.NotNil(t, err, "unregistered plugins should be loaded from the plugins location")
+}
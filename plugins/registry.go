@@ -0,0 +1,373 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+type registryEntry struct {
+	plugin Plugin
+	err    error
+	// done is closed once the attempt to load the plugin has completed
+	done chan struct{}
+}
+
+// isLoaded reports whether the attempt to load the plugin has completed
+func (e *registryEntry) isLoaded() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// RegistryFactory is factory that implements a concurrency safe cache
+// of loaded plugins keyed by plugin name and version, and by address
+// for external plugins. Plugins are loaded outside of the lock of the
+// registry so that loading one plugin does not block requests that use
+// others, and each plugin is only loaded by one caller at a time.
+type RegistryFactory struct {
+	mutex   sync.RWMutex
+	entries map[string]*registryEntry
+	load    func(spec.Plugin) (*Plugin, error)
+	// users holds the APIProxys that use each plugin and
+	// uses holds the plugins used by each APIProxy
+	users map[string]map[string]bool
+	uses  map[string][]spec.Plugin
+}
+
+// Registry holds every plugin that Kanali has attempted to load.
+// It should not be mutated directly!
+var Registry *RegistryFactory
+
+func init() {
+	Registry = newRegistry(GetPlugin)
+}
+
+func newRegistry(load func(spec.Plugin) (*Plugin, error)) *RegistryFactory {
+	return &RegistryFactory{
+		entries: map[string]*registryEntry{},
+		load:    load,
+		users:   map[string]map[string]bool{},
+		uses:    map[string][]spec.Plugin{},
+	}
+}
+
+// Clear will remove all plugins from the registry
+func (r *RegistryFactory) Clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = map[string]*registryEntry{}
+	r.users = map[string]map[string]bool{}
+	r.uses = map[string][]spec.Plugin{}
+}
+
+// Load will load a plugin if it has not already been loaded successfully.
+// The result of the attempt, whether successful or not, is cached and
+// previous failures are retried.
+func (r *RegistryFactory) Load(p spec.Plugin) (Plugin, error) {
+	return r.get(p, true)
+}
+
+// Get returns a previously loaded plugin or the error encountered when it
+// was loaded. A plugin is only loaded if it has never been attempted before.
+// Failures are retried by Load, which is called whenever an APIProxy using
+// the plugin is modified or its failed plugins are revalidated.
+func (r *RegistryFactory) Get(p spec.Plugin) (Plugin, error) {
+	return r.get(p, false)
+}
+
+func (r *RegistryFactory) get(p spec.Plugin, retry bool) (Plugin, error) {
+	key := registryKey(p)
+
+	r.mutex.RLock()
+	entry, ok := r.entries[key]
+	r.mutex.RUnlock()
+	if ok && entry.isLoaded() && (entry.err == nil || !retry) {
+		return entry.plugin, entry.err
+	}
+
+	r.mutex.Lock()
+	entry, ok = r.entries[key]
+	if ok && (!entry.isLoaded() || entry.err == nil || !retry) {
+		r.mutex.Unlock()
+		// another caller is loading the plugin
+		<-entry.done
+		return entry.plugin, entry.err
+	}
+	entry = &registryEntry{done: make(chan struct{})}
+	r.entries[key] = entry
+	r.mutex.Unlock()
+
+	if loaded, err := r.load(p); err != nil {
+		entry.err = err
+	} else {
+		entry.plugin, entry.err = initPlugin(p, *loaded)
+	}
+	close(entry.done)
+
+	r.mutex.RLock()
+	deleted := r.entries[key] != entry
+	r.mutex.RUnlock()
+	if deleted {
+		// the plugin was deleted while it was being loaded
+		closePlugin(key, entry)
+	}
+	return entry.plugin, entry.err
+}
+
+// Set initializes an instance of a plugin and adds it to the registry in
+// place of loading it. This allows a plugin to be used without compiling
+// it, such as in the tests of the plugin.
+func (r *RegistryFactory) Set(p spec.Plugin, plugin Plugin) error {
+	entry := &registryEntry{done: make(chan struct{})}
+	entry.plugin, entry.err = initPlugin(p, plugin)
+	close(entry.done)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries[registryKey(p)] = entry
+	return entry.err
+}
+
+// Delete will close a plugin if it implements the Lifecycle
+// interface and remove it from the registry
+func (r *RegistryFactory) Delete(p spec.Plugin) error {
+	r.mutex.Lock()
+	entry := r.remove(registryKey(p))
+	r.mutex.Unlock()
+	if entry == nil || !entry.isLoaded() {
+		// a plugin that is still loading is closed once it is loaded
+		return nil
+	}
+	if lifecycle, ok := entry.plugin.(Lifecycle); ok {
+		return lifecycle.Close()
+	}
+	return nil
+}
+
+// remove removes a plugin from the registry and returns its entry, if any.
+// The caller must hold the lock of the registry.
+func (r *RegistryFactory) remove(key string) *registryEntry {
+	entry := r.entries[key]
+	delete(r.entries, key)
+	delete(r.users, key)
+	return entry
+}
+
+// Use records the plugins used by an APIProxy. Plugins that are no longer
+// used by any APIProxy are deleted from the registry so that they are
+// closed and their connections are released.
+func (r *RegistryFactory) Use(proxy spec.APIProxy) {
+	r.setUses(proxyKey(proxy), proxy.GetPlugins())
+}
+
+// Release records that an APIProxy no longer uses any plugins
+func (r *RegistryFactory) Release(proxy spec.APIProxy) {
+	r.setUses(proxyKey(proxy), nil)
+}
+
+func (r *RegistryFactory) setUses(proxy string, plugins []spec.Plugin) {
+	r.mutex.Lock()
+	previous := r.uses[proxy]
+	if len(plugins) > 0 {
+		r.uses[proxy] = plugins
+	} else {
+		delete(r.uses, proxy)
+	}
+	for _, p := range plugins {
+		key := registryKey(p)
+		if _, ok := r.users[key]; !ok {
+			r.users[key] = map[string]bool{}
+		}
+		r.users[key][proxy] = true
+	}
+	unused := map[string]*registryEntry{}
+	for _, p := range previous {
+		key := registryKey(p)
+		if !r.users[key][proxy] || usesPlugin(plugins, key) {
+			continue
+		}
+		delete(r.users[key], proxy)
+		if len(r.users[key]) < 1 {
+			if entry := r.remove(key); entry != nil {
+				unused[key] = entry
+			}
+		}
+	}
+	r.mutex.Unlock()
+
+	// a plugin that is still loading is closed once it is loaded
+	for key, entry := range unused {
+		if entry.isLoaded() {
+			closePlugin(key, entry)
+		}
+	}
+}
+
+func usesPlugin(plugins []spec.Plugin, key string) bool {
+	for _, p := range plugins {
+		if registryKey(p) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func proxyKey(proxy spec.APIProxy) string {
+	return fmt.Sprintf("%s/%s", proxy.ObjectMeta.Namespace, proxy.ObjectMeta.Name)
+}
+
+func closePlugin(key string, entry *registryEntry) {
+	if lifecycle, ok := entry.plugin.(Lifecycle); ok {
+		if err := lifecycle.Close(); err != nil {
+			logrus.Errorf("could not close plugin %s: %s", key, err.Error())
+		}
+	}
+}
+
+// initPlugin initializes a plugin and returns it, or
+// nil along with the error if it could not be initialized
+func initPlugin(p spec.Plugin, plugin Plugin) (Plugin, error) {
+	if hostPlugin, ok := plugin.(HostPlugin); ok {
+		hostPlugin.SetHost(newHost(p.Name))
+	}
+	lifecycle, ok := plugin.(Lifecycle)
+	if !ok {
+		return plugin, nil
+	}
+	config := viper.GetStringMap(fmt.Sprintf("plugins.%s", p.Name))
+	if err := lifecycle.Init(config, logrus.WithField("plugin", p.Name)); err != nil {
+		return nil, utils.StatusError{
+			Code: http.StatusInternalServerError,
+			Err:  fmt.Errorf("could not initialize plugin %s: %s", p.Name, err.Error()),
+		}
+	}
+	return plugin, nil
+}
+
+// Close will close every loaded plugin that implements the Lifecycle
+// interface and remove all plugins from the registry
+func (r *RegistryFactory) Close() {
+	r.mutex.Lock()
+	entries := r.entries
+	r.entries = map[string]*registryEntry{}
+	r.users = map[string]map[string]bool{}
+	r.uses = map[string][]spec.Plugin{}
+	r.mutex.Unlock()
+	for key, entry := range entries {
+		if entry.isLoaded() {
+			closePlugin(key, entry)
+		}
+	}
+}
+
+// CheckHealth returns the status of an APIProxy with the health of each
+// of its plugins checked again. Plugins are not loaded to do so, so the
+// status of plugins that could not be loaded is returned unchanged.
+func (r *RegistryFactory) CheckHealth(proxy spec.APIProxy) spec.APIProxyStatus {
+	plugins := proxy.GetPlugins()
+	if len(plugins) != len(proxy.Status.Plugins) {
+		// the plugins used by the APIProxy have changed since it was validated
+		return r.Validate(proxy)
+	}
+	status := spec.APIProxyStatus{}
+	status.Plugins = append(status.Plugins, proxy.Status.Plugins...)
+	for i, plugin := range plugins {
+		if status.Plugins[i].Name != plugin.Name || status.Plugins[i].Version != plugin.Version {
+			return r.Validate(proxy)
+		}
+		if !status.Plugins[i].Loaded || status.Plugins[i].Error != "" {
+			continue
+		}
+		status.Plugins[i].Unhealthy = ""
+		if err := r.health(plugin); err != nil {
+			status.Plugins[i].Unhealthy = err.Error()
+		}
+	}
+	return status
+}
+
+// health returns the error reported by a loaded plugin
+// that implements the Lifecycle interface and is not healthy
+func (r *RegistryFactory) health(p spec.Plugin) error {
+	r.mutex.RLock()
+	entry, ok := r.entries[registryKey(p)]
+	r.mutex.RUnlock()
+	if !ok || !entry.isLoaded() || entry.err != nil {
+		return nil
+	}
+	lifecycle, ok := entry.plugin.(Lifecycle)
+	if !ok {
+		return nil
+	}
+	return lifecycle.Healthy()
+}
+
+// Validate attempts to load every plugin used by an APIProxy, validates
+// its configuration and health and reports the result of each attempt. The plugins
+// are recorded as used by the APIProxy until it uses others or Release
+// is called.
+func (r *RegistryFactory) Validate(proxy spec.APIProxy) spec.APIProxyStatus {
+	status := spec.APIProxyStatus{}
+	for _, plugin := range proxy.GetPlugins() {
+		result := spec.PluginStatus{
+			Name:    plugin.Name,
+			Version: plugin.Version,
+			Loaded:  true,
+		}
+		p, err := r.Load(plugin)
+		if err == nil && plugin.External != nil {
+			err = validateExternal(plugin.External)
+		}
+		if err != nil {
+			result.Loaded = false
+			result.Error = err.Error()
+		} else if err := ValidateConfig(p, plugin.Config); err != nil {
+			result.Error = fmt.Sprintf("invalid config: %s", err.Error())
+		} else if err := validatePolicy(plugin); err != nil {
+			result.Error = err.Error()
+		} else if err := r.health(plugin); err != nil {
+			result.Unhealthy = err.Error()
+		}
+		status.Plugins = append(status.Plugins, result)
+	}
+	r.Use(proxy)
+	return status
+}
+
+func registryKey(p spec.Plugin) string {
+	if p.External == nil {
+		return p.GetFileName()
+	}
+	if p.External.TLS {
+		return fmt.Sprintf("%s@tls://%s", p.GetFileName(), p.External.Address)
+	}
+	return fmt.Sprintf("%s@%s", p.GetFileName(), p.External.Address)
+}
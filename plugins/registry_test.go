@@ -0,0 +1,383 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+type fakePlugin struct{}
+
+func (plugin fakePlugin) OnRequest(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, span opentracing.Span) error {
+	return nil
+}
+
+func (plugin fakePlugin) OnResponse(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, resp *http.Response, span opentracing.Span) error {
+	return nil
+}
+
+type fakeSchemaPlugin struct {
+	fakePlugin
+}
+
+func (plugin fakeSchemaPlugin) ConfigSchema() []byte {
+	return []byte(`{"type":"object","required":["header"]}`)
+}
+
+type fakeLifecyclePlugin struct {
+	fakePlugin
+	config  map[string]interface{}
+	logger  *logrus.Entry
+	inits   int
+	closed  bool
+	initErr error
+	healthy error
+}
+
+func (plugin *fakeLifecyclePlugin) Init(config map[string]interface{}, logger *logrus.Entry) error {
+	plugin.inits++
+	plugin.config = config
+	plugin.logger = logger
+	return plugin.initErr
+}
+
+func (plugin *fakeLifecyclePlugin) Close() error {
+	plugin.closed = true
+	return errors.New("already closed")
+}
+
+func (plugin *fakeLifecyclePlugin) Healthy() error {
+	return plugin.healthy
+}
+
+func getTestRegistry(calls map[string]int) *RegistryFactory {
+	return newRegistry(func(p spec.Plugin) (*Plugin, error) {
+		calls[p.GetFileName()]++
+		if p.Name == "broken" {
+			return nil, errors.New("could not open plugin broken")
+		}
+		var plugin Plugin = fakePlugin{}
+		if p.Name == "schema" {
+			plugin = fakeSchemaPlugin{}
+		}
+		return &plugin, nil
+	})
+}
+
+func TestRegistryGet(t *testing.T) {
+	calls := map[string]int{}
+	registry := getTestRegistry(calls)
+
+	for i := 0; i < 3; i++ {
+		p, err := registry.Get(spec.Plugin{Name: "example", Version: "1.0.0"})
+		assert.Nil(t, err)
+		assert.NotNil(t, p)
+	}
+	assert.Equal(t, 1, calls["example_1.0.0"], "plugin should only be loaded once")
+
+	registry.Get(spec.Plugin{Name: "example", Version: "2.0.0"})
+	assert.Equal(t, 1, calls["example_2.0.0"], "each version should be loaded separately")
+
+	for i := 0; i < 3; i++ {
+		p, err := registry.Get(spec.Plugin{Name: "broken"})
+		assert.Nil(t, p)
+		assert.Equal(t, "could not open plugin broken", err.Error())
+	}
+	assert.Equal(t, 1, calls["broken"], "failures should be cached")
+
+	registry.Clear()
+	registry.Get(spec.Plugin{Name: "example", Version: "1.0.0"})
+	assert.Equal(t, 2, calls["example_1.0.0"])
+}
+
+func TestRegistryLoad(t *testing.T) {
+	calls := map[string]int{}
+	registry := getTestRegistry(calls)
+
+	registry.Load(spec.Plugin{Name: "example"})
+	registry.Load(spec.Plugin{Name: "example"})
+	assert.Equal(t, 1, calls["example"])
+
+	registry.Load(spec.Plugin{Name: "broken"})
+	_, err := registry.Load(spec.Plugin{Name: "broken"})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, calls["broken"], "failures should be retried")
+}
+
+func TestRegistryValidate(t *testing.T) {
+	registry := getTestRegistry(map[string]int{})
+
+	status := registry.Validate(spec.APIProxy{})
+	assert.Equal(t, 0, len(status.Plugins))
+
+	status = registry.Validate(spec.APIProxy{
+		Spec: spec.APIProxySpec{
+			Plugins: []spec.Plugin{
+				{Name: "example", Version: "1.0.0"},
+				{Name: "broken"},
+			},
+		},
+	})
+	assert.Equal(t, []spec.PluginStatus{
+		{Name: "example", Version: "1.0.0", Loaded: true},
+		{Name: "broken", Error: "could not open plugin broken"},
+	}, status.Plugins)
+	assert.Equal(t, "broken", status.GetFailedPlugin().Name)
+
+	viper.Set(config.FlagPluginsDefaultChain.GetLong(), []string{"audit:1.0.0"})
+	defer viper.Set(config.FlagPluginsDefaultChain.GetLong(), nil)
+	status = registry.Validate(spec.APIProxy{
+		Spec: spec.APIProxySpec{
+			Plugins: []spec.Plugin{{Name: "example"}},
+		},
+	})
+	assert.Equal(t, []spec.PluginStatus{
+		{Name: "audit", Version: "1.0.0", Loaded: true},
+		{Name: "example", Loaded: true},
+	}, status.Plugins, "default plugins should be validated")
+}
+
+func TestRegistryValidateConfig(t *testing.T) {
+	registry := getTestRegistry(map[string]int{})
+
+	status := registry.Validate(spec.APIProxy{
+		Spec: spec.APIProxySpec{
+			Plugins: []spec.Plugin{
+				{Name: "example", Config: json.RawMessage(`{"anything":true}`)},
+				{Name: "schema", Config: json.RawMessage(`{"header":"X-Foo"}`)},
+			},
+		},
+	})
+	assert.Nil(t, status.GetFailedPlugin())
+
+	status = registry.Validate(spec.APIProxy{
+		Spec: spec.APIProxySpec{
+			Plugins: []spec.Plugin{
+				{Name: "schema", Config: json.RawMessage(`{}`)},
+			},
+		},
+	})
+	assert.Equal(t, []spec.PluginStatus{
+		{Name: "schema", Loaded: true, Error: "invalid config: config.header is required"},
+	}, status.Plugins)
+	assert.Equal(t, "schema", status.GetFailedPlugin().Name)
+}
+
+func TestRegistryLifecycle(t *testing.T) {
+	viper.Set("plugins.lifecycle.header", "X-Foo")
+	defer viper.Set("plugins.lifecycle", nil)
+
+	lifecycle := &fakeLifecyclePlugin{}
+	broken := &fakeLifecyclePlugin{initErr: errors.New("connection refused")}
+	registry := newRegistry(func(p spec.Plugin) (*Plugin, error) {
+		var plugin Plugin = lifecycle
+		if p.Name == "broken" {
+			plugin = broken
+		}
+		return &plugin, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		p, err := registry.Get(spec.Plugin{Name: "lifecycle"})
+		assert.Nil(t, err)
+		assert.NotNil(t, p)
+	}
+	assert.Equal(t, 1, lifecycle.inits, "plugins should only be initialized once")
+	assert.Equal(t, "X-Foo", lifecycle.config["header"])
+	assert.Equal(t, "lifecycle", lifecycle.logger.Data["plugin"])
+
+	p, err := registry.Get(spec.Plugin{Name: "broken"})
+	assert.Nil(t, p)
+	assert.Equal(t, "could not initialize plugin broken: connection refused", err.Error())
+	assert.Equal(t, http.StatusInternalServerError, err.(utils.StatusError).Status())
+
+	proxy := spec.APIProxy{Spec: spec.APIProxySpec{Plugins: []spec.Plugin{{Name: "lifecycle"}, {Name: "broken"}}}}
+	proxy.Status = registry.Validate(proxy)
+	assert.Equal(t, "", proxy.Status.Plugins[0].Unhealthy)
+	assert.Equal(t, proxy.Status, registry.CheckHealth(proxy))
+
+	lifecycle.healthy = errors.New("unhealthy")
+	broken.healthy = errors.New("unhealthy")
+	status := registry.CheckHealth(proxy)
+	assert.Equal(t, "unhealthy", status.Plugins[0].Unhealthy)
+	assert.Equal(t, "", status.Plugins[1].Unhealthy, "plugins that failed to initialize should not be checked")
+	assert.Equal(t, "", proxy.Status.Plugins[0].Unhealthy, "the status of the proxy should not be modified")
+	assert.Equal(t, "unhealthy", registry.Validate(proxy).Plugins[0].Unhealthy)
+
+	lifecycle.healthy = nil
+	proxy.Status = status
+	assert.Equal(t, "", registry.CheckHealth(proxy).Plugins[0].Unhealthy, "plugins that recover should be reported as healthy")
+
+	registry.Close()
+	assert.True(t, lifecycle.closed)
+	assert.False(t, broken.closed, "plugins that failed to initialize should not be closed")
+	assert.Equal(t, 0, len(registry.entries))
+}
+
+func TestRegistrySetDelete(t *testing.T) {
+	registry := getTestRegistry(map[string]int{})
+	lifecycle := &fakeLifecyclePlugin{}
+
+	assert.Nil(t, registry.Set(spec.Plugin{Name: "lifecycle"}, lifecycle))
+	assert.Equal(t, 1, lifecycle.inits, "plugins should be initialized when they are set")
+	p, err := registry.Get(spec.Plugin{Name: "lifecycle"})
+	assert.Nil(t, err)
+	assert.Equal(t, lifecycle, p)
+
+	assert.Equal(t, "already closed", registry.Delete(spec.Plugin{Name: "lifecycle"}).Error())
+	assert.True(t, lifecycle.closed)
+	assert.Equal(t, 0, len(registry.entries))
+	assert.Nil(t, registry.Delete(spec.Plugin{Name: "lifecycle"}))
+
+	broken := &fakeLifecyclePlugin{initErr: errors.New("connection refused")}
+	assert.Equal(t, "could not initialize plugin broken: connection refused", registry.Set(spec.Plugin{Name: "broken"}, broken).Error())
+	_, err = registry.Get(spec.Plugin{Name: "broken"})
+	assert.NotNil(t, err)
+	assert.Nil(t, registry.Delete(spec.Plugin{Name: "broken"}))
+	assert.False(t, broken.closed, "plugins that failed to initialize should not be closed")
+}
+
+func TestRegistryLoadConcurrently(t *testing.T) {
+	assert := assert.New(t)
+
+	var mutex sync.Mutex
+	calls := map[string]int{}
+	release := make(chan struct{})
+	registry := newRegistry(func(p spec.Plugin) (*Plugin, error) {
+		mutex.Lock()
+		calls[p.Name]++
+		mutex.Unlock()
+		if p.Name == "slow" {
+			<-release
+		}
+		var plugin Plugin = fakePlugin{}
+		return &plugin, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := registry.Get(spec.Plugin{Name: "slow"})
+			assert.Nil(err)
+			assert.NotNil(p)
+		}()
+	}
+
+	for {
+		mutex.Lock()
+		started := calls["slow"] > 0
+		mutex.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p, err := registry.Get(spec.Plugin{Name: "example"})
+	assert.Nil(err)
+	assert.NotNil(p, "loading a plugin should not block other plugins")
+
+	close(release)
+	wg.Wait()
+	assert.Equal(1, calls["slow"], "a plugin should only be loaded once when requested concurrently")
+}
+
+func TestRegistryDeleteWhileLoading(t *testing.T) {
+	lifecycle := &fakeLifecyclePlugin{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	registry := newRegistry(func(p spec.Plugin) (*Plugin, error) {
+		close(started)
+		<-release
+		var plugin Plugin = lifecycle
+		return &plugin, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		registry.Get(spec.Plugin{Name: "lifecycle"})
+		close(done)
+	}()
+	<-started
+	assert.Nil(t, registry.Delete(spec.Plugin{Name: "lifecycle"}))
+	close(release)
+	<-done
+	assert.True(t, lifecycle.closed, "a plugin deleted while loading should be closed once loaded")
+	assert.Equal(t, 0, len(registry.entries))
+}
+
+func TestRegistryUseRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	loaded := map[string]*fakeLifecyclePlugin{}
+	registry := newRegistry(func(p spec.Plugin) (*Plugin, error) {
+		loaded[p.Name] = &fakeLifecyclePlugin{}
+		var plugin Plugin = loaded[p.Name]
+		return &plugin, nil
+	})
+
+	newProxy := func(name string, plugins ...string) spec.APIProxy {
+		proxy := spec.APIProxy{ObjectMeta: api.ObjectMeta{Name: name, Namespace: "foo"}}
+		for _, plugin := range plugins {
+			proxy.Spec.Plugins = append(proxy.Spec.Plugins, spec.Plugin{Name: plugin})
+		}
+		return proxy
+	}
+
+	registry.Validate(newProxy("one", "x", "y"))
+	registry.Validate(newProxy("two", "y"))
+	x, y := loaded["x"], loaded["y"]
+
+	registry.Validate(newProxy("one", "y"))
+	assert.True(x.closed, "plugins that are no longer used should be closed")
+	_, ok := registry.entries["x"]
+	assert.False(ok)
+
+	registry.Release(newProxy("two"))
+	assert.False(y.closed, "plugins that are still used should not be closed")
+
+	registry.Release(newProxy("one"))
+	assert.True(y.closed)
+	assert.Equal(0, len(registry.entries))
+	assert.Equal(0, len(registry.users))
+	assert.Equal(0, len(registry.uses))
+
+	registry.Get(spec.Plugin{Name: "x"})
+	registry.Release(newProxy("one"))
+	assert.Equal(1, len(registry.entries), "plugins that were never used by a proxy should be kept")
+}
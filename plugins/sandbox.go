@@ -0,0 +1,190 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/northwesternmutual/kanali/metrics"
+)
+
+var errAbandoned = errors.New("plugin did not complete in time and may no longer read the body")
+
+// sandbox holds the copies of the metrics, request and response that are
+// handed to a plugin running in its own goroutine. The changes the plugin
+// makes are only applied to the originals once it has returned, so that a
+// plugin that is abandoned can not race with the remaining steps.
+type sandbox struct {
+	m        *metrics.Metrics
+	req      *http.Request
+	resp     *http.Response
+	reqBody  *sandboxBody
+	respBody *sandboxBody
+}
+
+func newSandbox(req *http.Request, resp *http.Response) *sandbox {
+	s := &sandbox{m: &metrics.Metrics{}}
+	if req != nil {
+		s.req = cloneRequest(req)
+		s.reqBody = newSandboxBody(req.Body)
+		if s.reqBody != nil {
+			s.req.Body = s.reqBody
+		}
+	}
+	if resp != nil {
+		s.resp = cloneResponse(resp)
+		s.respBody = newSandboxBody(resp.Body)
+		if s.respBody != nil {
+			s.resp.Body = s.respBody
+		}
+		if resp.Request == req {
+			s.resp.Request = s.req
+		}
+	}
+	return s
+}
+
+// apply copies the changes made by a plugin that has returned to the originals
+func (s *sandbox) apply(m *metrics.Metrics, req *http.Request, resp *http.Response) {
+	if m != nil {
+		m.Add(*s.m...)
+	}
+	if req != nil {
+		*req = *s.req
+		if s.reqBody != nil && req.Body == s.reqBody {
+			req.Body = s.reqBody.ReadCloser
+		}
+	}
+	if resp != nil {
+		*resp = *s.resp
+		if s.respBody != nil && resp.Body == s.respBody {
+			resp.Body = s.respBody.ReadCloser
+		}
+		if resp.Request == s.req {
+			resp.Request = req
+		}
+	}
+}
+
+// abandon prevents a plugin that is still running from reading the
+// original bodies any further and reports whether it already had
+func (s *sandbox) abandon() bool {
+	consumed := false
+	for _, body := range []*sandboxBody{s.reqBody, s.respBody} {
+		if body != nil && body.abandon() {
+			consumed = true
+		}
+	}
+	return consumed
+}
+
+// sandboxBody guards the body of a request or response
+// while it is being read by a plugin
+type sandboxBody struct {
+	io.ReadCloser
+	mutex     sync.Mutex
+	used      bool
+	abandoned bool
+}
+
+func newSandboxBody(body io.ReadCloser) *sandboxBody {
+	if body == nil || body == http.NoBody {
+		return nil
+	}
+	return &sandboxBody{ReadCloser: body}
+}
+
+func (b *sandboxBody) use() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.abandoned {
+		return errAbandoned
+	}
+	b.used = true
+	return nil
+}
+
+func (b *sandboxBody) abandon() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.abandoned = true
+	return b.used
+}
+
+// Read reads from the original body unless the plugin has been abandoned
+func (b *sandboxBody) Read(p []byte) (int, error) {
+	if err := b.use(); err != nil {
+		return 0, err
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// Close closes the original body unless the plugin has been abandoned
+func (b *sandboxBody) Close() error {
+	if err := b.use(); err != nil {
+		return err
+	}
+	return b.ReadCloser.Close()
+}
+
+func cloneRequest(r *http.Request) *http.Request {
+	c := new(http.Request)
+	*c = *r
+	if r.URL != nil {
+		u := *r.URL
+		c.URL = &u
+	}
+	c.Header = cloneHeader(r.Header)
+	c.Trailer = cloneHeader(r.Trailer)
+	c.Form = cloneValues(r.Form)
+	c.PostForm = cloneValues(r.PostForm)
+	return c
+}
+
+func cloneResponse(r *http.Response) *http.Response {
+	c := new(http.Response)
+	*c = *r
+	c.Header = cloneHeader(r.Header)
+	c.Trailer = cloneHeader(r.Trailer)
+	return c
+}
+
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+func cloneValues(v url.Values) url.Values {
+	if v == nil {
+		return nil
+	}
+	return url.Values(cloneHeader(http.Header(v)))
+}
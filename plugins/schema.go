@@ -0,0 +1,174 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is the subset of JSON Schema that can be used by a plugin
+// to describe the configuration it accepts
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+// ParseSchema parses a JSON encoded schema
+func ParseSchema(data []byte) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("schema is not valid json: %s", err.Error())
+	}
+	return s, nil
+}
+
+// Validate reports whether a JSON encoded configuration conforms to the schema.
+// An empty configuration is validated as an empty object.
+func (s *Schema) Validate(config json.RawMessage) error {
+	if len(config) < 1 {
+		config = json.RawMessage("{}")
+	}
+	var v interface{}
+	if err := json.Unmarshal(config, &v); err != nil {
+		return fmt.Errorf("config is not valid json: %s", err.Error())
+	}
+	return s.validate("config", v)
+}
+
+func (s *Schema) validate(path string, v interface{}) error {
+	if s.Type != "" && !isType(s.Type, v) {
+		return fmt.Errorf("%s must be of type %s", path, s.Type)
+	}
+
+	if len(s.Enum) > 0 && !isEnum(s.Enum, v) {
+		return fmt.Errorf("%s must be one of the allowed values", path)
+	}
+
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		return s.validateObject(path, typed)
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range typed {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case float64:
+		if s.Minimum != nil && typed < *s.Minimum {
+			return fmt.Errorf("%s must be greater than or equal to %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && typed > *s.Maximum {
+			return fmt.Errorf("%s must be less than or equal to %v", path, *s.Maximum)
+		}
+	case string:
+		if s.Pattern == "" {
+			return nil
+		}
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s has an invalid pattern in its schema: %s", path, err.Error())
+		}
+		if !re.MatchString(typed) {
+			return fmt.Errorf("%s must match %s", path, s.Pattern)
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateObject(path string, obj map[string]interface{}) error {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s.%s is required", path, name)
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return fmt.Errorf("%s.%s is not allowed", path, name)
+			}
+			continue
+		}
+		if err := property.validate(fmt.Sprintf("%s.%s", path, name), obj[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isType(t string, v interface{}) bool {
+	switch strings.ToLower(t) {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+func isEnum(enum []interface{}, v interface{}) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(allowed, v) {
+			return true
+		}
+	}
+	return false
+}
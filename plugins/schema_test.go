@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package plugins
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSchema = `{
+  "type": "object",
+  "required": ["header"],
+  "additionalProperties": false,
+  "properties": {
+    "header": {"type": "string", "pattern": "^X-"},
+    "mode": {"enum": ["strict", "lenient"]},
+    "limit": {"type": "integer", "minimum": 1, "maximum": 10},
+    "paths": {"type": "array", "items": {"type": "string"}}
+  }
+}`
+
+func TestParseSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(testSchema))
+	assert.Nil(t, err)
+	assert.Equal(t, "object", s.Type)
+	assert.Equal(t, []string{"header"}, s.Required)
+	assert.Equal(t, 4, len(s.Properties))
+
+	_, err = ParseSchema([]byte("{"))
+	assert.NotNil(t, err)
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s, _ := ParseSchema([]byte(testSchema))
+
+	assert.Nil(t, s.Validate(json.RawMessage(`{"header":"X-Foo"}`)))
+	assert.Nil(t, s.Validate(json.RawMessage(`{"header":"X-Foo","mode":"strict","limit":5,"paths":["/a","/b"]}`)))
+
+	tests := map[string]string{
+		``:                                 "config.header is required",
+		`[]`:                               "config must be of type object",
+		`{`:                                "config is not valid json: unexpected end of JSON input",
+		`{"header":1}`:                     "config.header must be of type string",
+		`{"header":"Foo"}`:                 "config.header must match ^X-",
+		`{"header":"X-Foo","mode":"fast"}`: "config.mode must be one of the allowed values",
+		`{"header":"X-Foo","limit":1.5}`:   "config.limit must be of type integer",
+		`{"header":"X-Foo","limit":0}`:     "config.limit must be greater than or equal to 1",
+		`{"header":"X-Foo","limit":11}`:    "config.limit must be less than or equal to 10",
+		`{"header":"X-Foo","paths":[1]}`:   "config.paths[0] must be of type string",
+		`{"header":"X-Foo","other":true}`:  "config.other is not allowed",
+	}
+	for config, msg := range tests {
+		err := s.Validate(json.RawMessage(config))
+		if assert.NotNil(t, err, config) {
+			assert.Equal(t, msg, err.Error(), config)
+		}
+	}
+
+	assert.Nil(t, (&Schema{}).Validate(json.RawMessage(`{"anything":["goes"]}`)))
+	assert.NotNil(t, (&Schema{Type: "string", Pattern: "["}).Validate(json.RawMessage(`"foo"`)))
+}
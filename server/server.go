@@ -69,11 +69,7 @@ func Start(influxCtlr *monitor.InfluxController) {
 			logrus.Fatal("could not load server cert/key pair")
 			os.Exit(1)
 		}
-		listener, lerr = tls.Listen("tcp4", address, &tls.Config{Certificates: []tls.Certificate{cert}, Rand: rand.Reader})
-		if lerr != nil {
-			logrus.Fatal("error creating https net listener")
-			os.Exit(1)
-		}
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, Rand: rand.Reader}
 		// is bi-direction ssl required
 		if viper.GetString(config.FlagTLSCaFile.GetLong()) != "" {
 			caCert, err := ioutil.ReadFile(viper.GetString(config.FlagTLSCaFile.GetLong()))
@@ -84,12 +80,14 @@ func Start(influxCtlr *monitor.InfluxController) {
 			// load and set client certificate
 			caCertPool := x509.NewCertPool()
 			caCertPool.AppendCertsFromPEM(caCert)
-			tlsConfig := &tls.Config{
-				ClientCAs:  caCertPool,
-				ClientAuth: tls.RequireAndVerifyClientCert,
-			}
+			tlsConfig.ClientCAs = caCertPool
+			tlsConfig.ClientAuth = getClientAuthType()
 			tlsConfig.BuildNameToCertificate()
-			server.TLSConfig = tlsConfig
+		}
+		listener, lerr = tls.Listen("tcp4", address, tlsConfig)
+		if lerr != nil {
+			logrus.Fatal("error creating https net listener")
+			os.Exit(1)
 		}
 	}
 
@@ -106,6 +104,15 @@ func Start(influxCtlr *monitor.InfluxController) {
 
 }
 
+// getClientAuthType returns the policy for client certificates when mutual TLS is
+// enabled. Certificates are always verified if they are presented.
+func getClientAuthType() tls.ClientAuthType {
+	if viper.GetBool(config.FlagTLSClientCertOptional.GetLong()) {
+		return tls.VerifyClientCertIfGiven
+	}
+	return tls.RequireAndVerifyClientCert
+}
+
 func getKanaliPort() int {
 	if viper.GetInt(config.FlagServerPort.GetLong()) > 0 {
 		return viper.GetInt(config.FlagServerPort.GetLong())
@@ -21,6 +21,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"testing"
 
 	"github.com/northwesternmutual/kanali/config"
@@ -39,3 +40,11 @@ func TestGetKanaliPort(t *testing.T) {
 	viper.Set(config.FlagTLSKeyFile.GetLong(), "bye")
 	assert.Equal(t, getKanaliPort(), 443)
 }
+
+func TestGetClientAuthType(t *testing.T) {
+	defer viper.Set(config.FlagTLSClientCertOptional.GetLong(), false)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, getClientAuthType())
+
+	viper.Set(config.FlagTLSClientCertOptional.GetLong(), true)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, getClientAuthType())
+}
@@ -21,15 +21,14 @@
 package server
 
 import (
-	"bytes"
 	"fmt"
 	"net"
-	"os"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/northwesternmutual/kanali/config"
 	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/traffic"
 	"github.com/spf13/viper"
 )
 
@@ -67,16 +66,16 @@ func StartUDPServer() (e error) {
 		}
 	}()
 
-	// [NAMESPACE],[PROXYNAME],[KEYNAME] <= 761
-	buf := make([]byte, k8sNameMaxSize*3+2)
+	// [NAMESPACE],[PROXYNAMESPACE]/[PROXYNAME],[KEYNAME]_[SUBPATH]_[VERB] < 1024
+	buf := make([]byte, k8sNameMaxSize*4+3)
 
 	for {
 		n, _, err := conn.ReadFromUDP(buf)
 		if err != nil {
 			return err
 		}
-		if err := spec.TrafficStore.Set(string(buf[0:n])); err != nil {
-			logrus.Errorf("could not add traffic point to store: %s", err.Error())
+		if err := traffic.Receive(string(buf[0:n]), time.Now()); err != nil {
+			logrus.Errorf("could not record gram from peer: %s", err.Error())
 		}
 	}
 
@@ -84,52 +83,5 @@ func StartUDPServer() (e error) {
 
 // Emit will send a message to all other Kanali instances.
 func Emit(binding spec.APIKeyBinding, keyName string, currTime time.Time) {
-
-	for _, addr := range spec.KanaliEndpoints.Subsets[0].Addresses {
-
-		if os.Getenv("POD_IP") == addr.IP {
-			if err := spec.TrafficStore.Set(encodeKanaliGram(binding.ObjectMeta.Namespace, binding.Spec.APIProxyName, keyName, ",")); err != nil {
-				logrus.Errorf("could not add traffic point to store: %s", err.Error())
-			}
-			continue
-		}
-
-		go func(ip string) {
-
-			serverAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", ip, viper.GetInt(config.FlagServerPeerUDPPort.GetLong())))
-			if err != nil {
-				logrus.Warnf("error resolving UDP address for %s:%d", ip, viper.GetInt(config.FlagServerPeerUDPPort.GetLong()))
-				return
-			}
-
-			conn, err := net.DialUDP("udp", nil, serverAddr)
-			if err != nil {
-				logrus.Warnf("error dialing %s:%d", ip, viper.GetInt(config.FlagServerPeerUDPPort.GetLong()))
-				return
-			}
-
-			_, err = conn.Write([]byte(fmt.Sprintf("%s,%s,%s", binding.ObjectMeta.Namespace, binding.Spec.APIProxyName, keyName)))
-			if err != nil {
-				logrus.Warnf("error writing traffic to %s:%d", ip, viper.GetString(config.FlagServerPeerUDPPort.GetLong()))
-				return
-			}
-
-			if err := conn.Close(); err != nil {
-				logrus.Errorf(err.Error())
-			}
-
-		}(addr.IP)
-
-	}
-
-}
-
-func encodeKanaliGram(nSpace, pName, keyName, delimiter string) string {
-	var buffer bytes.Buffer
-	buffer.WriteString(nSpace)
-	buffer.WriteString(delimiter)
-	buffer.WriteString(pName)
-	buffer.WriteString(delimiter)
-	buffer.WriteString(keyName)
-	return buffer.String()
+	traffic.Emit(binding.ObjectMeta.Namespace, binding.GetTrafficProxyName(), keyName)
 }
@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package signing
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceCache remembers the nonces of signed requests so that a request
+// can not be replayed. Nonces only need to be remembered for as long as
+// the timestamp of the request they were used in is within the allowed
+// clock skew. It is safe for concurrent use.
+type NonceCache struct {
+	mutex     sync.Mutex
+	nonces    map[string]time.Time
+	lastPurge time.Time
+}
+
+// NewNonceCache creates an empty nonce cache
+func NewNonceCache() *NonceCache {
+	return &NonceCache{nonces: map[string]time.Time{}}
+}
+
+// Use records a nonce until it expires and reports whether it was
+// unused. Expired nonces are purged periodically.
+func (c *NonceCache) Use(nonce string, expires, currTime time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if currTime.Sub(c.lastPurge) > time.Minute {
+		c.purge(currTime)
+	}
+
+	if exp, ok := c.nonces[nonce]; ok && currTime.Before(exp) {
+		return false
+	}
+	c.nonces[nonce] = expires
+	return true
+}
+
+// Len returns the number of nonces being remembered
+func (c *NonceCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.nonces)
+}
+
+func (c *NonceCache) purge(currTime time.Time) {
+	for nonce, exp := range c.nonces {
+		if !currTime.Before(exp) {
+			delete(c.nonces, nonce)
+		}
+	}
+	c.lastPurge = currTime
+}
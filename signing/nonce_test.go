@@ -0,0 +1,45 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package signing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNonceCache(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1500000000, 0)
+	cache := NewNonceCache()
+
+	assert.True(cache.Use("one", now.Add(time.Minute), now))
+	assert.False(cache.Use("one", now.Add(time.Minute), now), "nonces should not be reusable")
+	assert.True(cache.Use("two", now.Add(2*time.Minute), now))
+	assert.Equal(2, cache.Len())
+
+	assert.True(cache.Use("one", now.Add(3*time.Minute), now.Add(90*time.Second)), "expired nonces should be reusable")
+	assert.Equal(2, cache.Len())
+
+	cache.Use("three", now.Add(4*time.Minute), now.Add(3*time.Minute+time.Second))
+	assert.Equal(1, cache.Len(), "expired nonces should be purged")
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Scheme is the Authorization header scheme of signed requests
+	Scheme = "KANALI-HMAC-SHA256"
+	// TimestampHeader holds the unix time at which a request was signed
+	TimestampHeader = "X-Kanali-Timestamp"
+	// NonceHeader holds a value that is unique to each signed request
+	NonceHeader = "X-Kanali-Nonce"
+)
+
+var (
+	// ErrMalformed is returned when a signature can not be parsed
+	ErrMalformed = errors.New("malformed request signature")
+	// ErrTimestamp is returned when a request was not signed recently enough
+	ErrTimestamp = errors.New("request signature timestamp is missing or outside of the allowed clock skew")
+	// ErrNonce is returned when a request does not have a nonce
+	ErrNonce = errors.New("request signature nonce is missing")
+	// ErrBodyTooLarge is returned when the body of a signed request is too large to be verified
+	ErrBodyTooLarge = errors.New("request body is too large to verify its signature")
+)
+
+// Authorization is the parsed Authorization header of a signed request
+type Authorization struct {
+	KeyID     string
+	Headers   []string
+	Signature []byte
+}
+
+// IsSigned reports whether a request carries a request signature
+func IsSigned(r *http.Request) bool {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	return len(parts) == 2 && strings.EqualFold(parts[0], Scheme)
+}
+
+// ParseAuthorization parses an Authorization header of the form
+// KANALI-HMAC-SHA256 keyId="...",headers="host x-foo",signature="..."
+// where the signature is base64 encoded and headers is optional.
+func ParseAuthorization(header string) (*Authorization, error) {
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], Scheme) {
+		return nil, ErrMalformed
+	}
+	auth := &Authorization{Headers: []string{}}
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrMalformed
+		}
+		value := strings.Trim(kv[1], `"`)
+		switch kv[0] {
+		case "keyId":
+			auth.KeyID = value
+		case "headers":
+			for _, name := range strings.Fields(value) {
+				auth.Headers = append(auth.Headers, strings.ToLower(name))
+			}
+		case "signature":
+			signature, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, ErrMalformed
+			}
+			auth.Signature = signature
+		}
+	}
+	if auth.KeyID == "" || len(auth.Signature) < 1 {
+		return nil, ErrMalformed
+	}
+	return auth, nil
+}
+
+// String formats an Authorization header
+func (a Authorization) String() string {
+	return fmt.Sprintf(`%s keyId="%s",headers="%s",signature="%s"`, Scheme, a.KeyID, strings.Join(a.Headers, " "), base64.StdEncoding.EncodeToString(a.Signature))
+}
+
+// HasHeaders reports whether every one of the given headers is signed
+func (a Authorization) HasHeaders(headers []string) bool {
+	for _, required := range headers {
+		found := false
+		for _, name := range a.Headers {
+			if strings.EqualFold(name, required) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckTimestamp returns the timestamp of a signed request or ErrTimestamp
+// if the request was signed outside of the allowed clock skew
+func CheckTimestamp(r *http.Request, currTime time.Time, skew time.Duration) (time.Time, error) {
+	seconds, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return time.Time{}, ErrTimestamp
+	}
+	timestamp := time.Unix(seconds, 0)
+	if timestamp.Before(currTime.Add(-skew)) || timestamp.After(currTime.Add(skew)) {
+		return time.Time{}, ErrTimestamp
+	}
+	return timestamp, nil
+}
+
+// StringToSign builds the string that a request is signed over. The
+// body is read and replaced so that it can still be sent upstream. If
+// maxBodySize is positive, bodies larger than it are not read and
+// ErrBodyTooLarge is returned.
+func StringToSign(r *http.Request, headers []string, maxBodySize int64) (string, error) {
+	nonce := r.Header.Get(NonceHeader)
+	if nonce == "" {
+		return "", ErrNonce
+	}
+
+	digest, err := bodyDigest(r, maxBodySize)
+	if err != nil {
+		return "", err
+	}
+
+	path := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		path = path + "?" + r.URL.RawQuery
+	}
+
+	lines := []string{
+		strings.ToUpper(r.Method),
+		path,
+		r.Header.Get(TimestampHeader),
+		nonce,
+	}
+	for _, name := range headers {
+		lines = append(lines, fmt.Sprintf("%s:%s", strings.ToLower(name), getHeader(r, name)))
+	}
+	lines = append(lines, digest)
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// Sign computes the signature of a string to sign
+func Sign(key []byte, stringToSign string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	return mac.Sum(nil)
+}
+
+// Verify reports whether a signature was computed over a string
+// to sign using a key. The comparison is constant time.
+func Verify(key []byte, stringToSign string, signature []byte) bool {
+	return hmac.Equal(Sign(key, stringToSign), signature)
+}
+
+// SignRequest signs a request, setting its timestamp, nonce and
+// Authorization headers. It is intended for clients and tests.
+func SignRequest(r *http.Request, keyID string, key []byte, headers []string, nonce string, currTime time.Time) error {
+	r.Header.Set(TimestampHeader, strconv.FormatInt(currTime.Unix(), 10))
+	r.Header.Set(NonceHeader, nonce)
+	stringToSign, err := StringToSign(r, headers, 0)
+	if err != nil {
+		return err
+	}
+	lower := make([]string, len(headers))
+	for i, name := range headers {
+		lower[i] = strings.ToLower(name)
+	}
+	r.Header.Set("Authorization", Authorization{KeyID: keyID, Headers: lower, Signature: Sign(key, stringToSign)}.String())
+	return nil
+}
+
+func getHeader(r *http.Request, name string) string {
+	if strings.EqualFold(name, "host") {
+		return r.Host
+	}
+	return strings.Join(r.Header[http.CanonicalHeaderKey(name)], ",")
+}
+
+func bodyDigest(r *http.Request, maxBodySize int64) (string, error) {
+	sum := sha256.New()
+	if r.Body != nil {
+		var reader io.Reader = r.Body
+		if maxBodySize > 0 {
+			reader = io.LimitReader(r.Body, maxBodySize+1)
+		}
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return "", err
+		}
+		if maxBodySize > 0 && int64(len(body)) > maxBodySize {
+			return "", ErrBodyTooLarge
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sum.Write(body)
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
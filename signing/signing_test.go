@@ -0,0 +1,174 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package signing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRequest(method, rawURL, body string) *http.Request {
+	u, _ := url.Parse(rawURL)
+	r := &http.Request{Method: method, URL: u, Host: u.Host, Header: http.Header{}}
+	if body != "" {
+		r.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+	}
+	return r
+}
+
+func TestIsSigned(t *testing.T) {
+	r := newTestRequest("GET", "https://foo.bar.com/", "")
+	assert.False(t, IsSigned(r))
+	r.Header.Set("Authorization", "Bearer abc")
+	assert.False(t, IsSigned(r))
+	r.Header.Set("Authorization", `kanali-hmac-sha256 keyId="one"`)
+	assert.True(t, IsSigned(r))
+}
+
+func TestParseAuthorization(t *testing.T) {
+	assert := assert.New(t)
+
+	auth, err := ParseAuthorization(`KANALI-HMAC-SHA256 keyId="one", headers="Host X-Foo", signature="` + base64.StdEncoding.EncodeToString([]byte("sig")) + `"`)
+	assert.Nil(err)
+	assert.Equal("one", auth.KeyID)
+	assert.Equal([]string{"host", "x-foo"}, auth.Headers)
+	assert.Equal([]byte("sig"), auth.Signature)
+
+	parsed, err := ParseAuthorization(auth.String())
+	assert.Nil(err)
+	assert.Equal(auth, parsed)
+
+	auth, err = ParseAuthorization(`KANALI-HMAC-SHA256 keyId="one",signature="c2ln"`)
+	assert.Nil(err)
+	assert.Equal([]string{}, auth.Headers)
+
+	for _, header := range []string{
+		"",
+		"Bearer abc",
+		`KANALI-HMAC-SHA256 keyId="one"`,
+		`KANALI-HMAC-SHA256 signature="c2ln"`,
+		`KANALI-HMAC-SHA256 keyId="one",signature="!!!"`,
+		`KANALI-HMAC-SHA256 keyId`,
+	} {
+		_, err := ParseAuthorization(header)
+		assert.Equal(ErrMalformed, err, header)
+	}
+}
+
+func TestHasHeaders(t *testing.T) {
+	auth := Authorization{Headers: []string{"host", "x-foo"}}
+	assert.True(t, auth.HasHeaders(nil))
+	assert.True(t, auth.HasHeaders([]string{"Host", "X-Foo"}))
+	assert.False(t, auth.HasHeaders([]string{"host", "x-bar"}))
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1500000000, 0)
+
+	r := newTestRequest("GET", "https://foo.bar.com/", "")
+	_, err := CheckTimestamp(r, now, time.Minute)
+	assert.Equal(ErrTimestamp, err)
+
+	r.Header.Set(TimestampHeader, "1500000030")
+	timestamp, err := CheckTimestamp(r, now, time.Minute)
+	assert.Nil(err)
+	assert.Equal(time.Unix(1500000030, 0), timestamp)
+
+	r.Header.Set(TimestampHeader, "1499999900")
+	_, err = CheckTimestamp(r, now, time.Minute)
+	assert.Equal(ErrTimestamp, err)
+
+	r.Header.Set(TimestampHeader, "1500000100")
+	_, err = CheckTimestamp(r, now, time.Minute)
+	assert.Equal(ErrTimestamp, err)
+}
+
+func TestStringToSign(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newTestRequest("post", "https://foo.bar.com/api/v1/accounts?a=b", "hello")
+	_, err := StringToSign(r, nil, 0)
+	assert.Equal(ErrNonce, err)
+
+	r.Header.Set(TimestampHeader, "1500000000")
+	r.Header.Set(NonceHeader, "abc")
+	r.Header.Add("X-Foo", "one")
+	r.Header.Add("X-Foo", "two")
+
+	stringToSign, err := StringToSign(r, []string{"Host", "x-foo", "x-missing"}, 0)
+	assert.Nil(err)
+	assert.Equal("POST\n/api/v1/accounts?a=b\n1500000000\nabc\nhost:foo.bar.com\nx-foo:one,two\nx-missing:\n2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", stringToSign)
+
+	body, _ := ioutil.ReadAll(r.Body)
+	assert.Equal("hello", string(body), "the body should be readable after signing")
+
+	r = newTestRequest("GET", "https://foo.bar.com/", "")
+	r.Header.Set(NonceHeader, "abc")
+	stringToSign, _ = StringToSign(r, nil, 0)
+	assert.Equal("GET\n/\n\nabc\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", stringToSign)
+
+	r = newTestRequest("POST", "https://foo.bar.com/", "hello")
+	r.Header.Set(NonceHeader, "abc")
+	_, err = StringToSign(r, nil, 4)
+	assert.Equal(ErrBodyTooLarge, err)
+	r = newTestRequest("POST", "https://foo.bar.com/", "hello")
+	r.Header.Set(NonceHeader, "abc")
+	_, err = StringToSign(r, nil, 5)
+	assert.Nil(err)
+}
+
+func TestSignRequest(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1500000000, 0)
+	key := []byte("secret")
+
+	r := newTestRequest("PUT", "https://foo.bar.com/api/v1/accounts", "hello")
+	r.Header.Set("X-Foo", "bar")
+	assert.Nil(SignRequest(r, "one", key, []string{"Host", "X-Foo"}, "abc", now))
+	assert.Equal("1500000000", r.Header.Get(TimestampHeader))
+	assert.Equal("abc", r.Header.Get(NonceHeader))
+
+	auth, err := ParseAuthorization(r.Header.Get("Authorization"))
+	assert.Nil(err)
+	assert.Equal("one", auth.KeyID)
+	assert.Equal([]string{"host", "x-foo"}, auth.Headers)
+
+	stringToSign, _ := StringToSign(r, auth.Headers, 0)
+	assert.True(Verify(key, stringToSign, auth.Signature))
+	assert.False(Verify([]byte("other"), stringToSign, auth.Signature))
+
+	r.Header.Set("X-Foo", "baz")
+	stringToSign, _ = StringToSign(r, auth.Headers, 0)
+	assert.False(Verify(key, stringToSign, auth.Signature), "signed headers should not be modifiable")
+
+	r.Header.Set("X-Foo", "bar")
+	r.Body = ioutil.NopCloser(bytes.NewBufferString("goodbye"))
+	stringToSign, _ = StringToSign(r, auth.Headers, 0)
+	assert.False(Verify(key, stringToSign, auth.Signature), "the body should not be modifiable")
+}
@@ -21,12 +21,13 @@
 package spec
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"k8s.io/kubernetes/pkg/api"
@@ -47,27 +48,69 @@ type APIKey struct {
 	Spec                 APIKeySpec `json:"spec"`
 }
 
-// APIKeySpec represents the data fields for the APIKey TPR
+// APIKeySpec represents the data fields for the APIKey TPR. Once
+// decrypted, the data holds a keyed hash of the API key rather than
+// the API key itself, and the signing key holds the key that signed
+// requests are verified with.
 type APIKeySpec struct {
-	APIKeyData string `json:"data"`
+	APIKeyData string            `json:"data"`
+	KeyID      string            `json:"keyId,omitempty"`
+	ExpiresAt  *unversioned.Time `json:"expiresAt,omitempty"`
+	NotBefore  *unversioned.Time `json:"notBefore,omitempty"`
+	Disabled   bool              `json:"disabled,omitempty"`
+	SigningKey []byte            `json:"-"`
 }
 
+var (
+	// ErrAPIKeyDisabled is returned when an APIKey has been disabled
+	ErrAPIKeyDisabled = errors.New("api key is disabled")
+	// ErrAPIKeyExpired is returned when an APIKey is past its expiry
+	ErrAPIKeyExpired = errors.New("api key has expired")
+	// ErrAPIKeyNotYetValid is returned when an APIKey is used before its not before time
+	ErrAPIKeyNotYetValid = errors.New("api key is not yet valid")
+)
+
 // KeyFactory is factory that implements a concurrency safe store for Kanali APIKeys
 type KeyFactory struct {
-	mutex  sync.RWMutex
-	keyMap map[string]APIKey
+	mutex   sync.RWMutex
+	keyMap  map[string]APIKey
+	nameMap map[string]map[string]string
 }
 
 // KeyStore holds all Kanali APIKeys that Kanali has discovered
 // in a cluster. It should not be mutated directly!
 var KeyStore *KeyFactory
 
-// APIKeyDecryptionKey references the rsa private key that Kanali
-// will use to decrypt the data in an APIKey spec
-var APIKeyDecryptionKey *rsa.PrivateKey
+// apiKeyHashSalt is the per process secret that API keys are hashed with
+var apiKeyHashSalt []byte
 
 func init() {
-	KeyStore = &KeyFactory{sync.RWMutex{}, map[string]APIKey{}}
+	KeyStore = &KeyFactory{sync.RWMutex{}, map[string]APIKey{}, map[string]map[string]string{}}
+	apiKeyHashSalt = make([]byte, sha256.Size)
+	if _, err := rand.Read(apiKeyHashSalt); err != nil {
+		panic(err)
+	}
+}
+
+// HashAPIKey returns the keyed hash of an API key that
+// the key store indexes API keys by
+func HashAPIKey(data string) string {
+	return hashAPIKey([]byte(data))
+}
+
+func hashAPIKey(data []byte) string {
+	mac := hmac.New(sha256.New, apiKeyHashSalt)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeriveSigningKey returns the key that requests signed on behalf of an
+// API key are signed with. It is derived from the API key so that the
+// API key itself does not need to be retained.
+func DeriveSigningKey(data []byte) []byte {
+	mac := hmac.New(sha256.New, data)
+	mac.Write([]byte("kanali-request-signing"))
+	return mac.Sum(nil)
 }
 
 // Clear will remove all keys from the store
@@ -77,6 +120,9 @@ func (s *KeyFactory) Clear() {
 	for k := range s.keyMap {
 		delete(s.keyMap, k)
 	}
+	for k := range s.nameMap {
+		delete(s.nameMap, k)
+	}
 }
 
 // Update will update an APIKeyBinding
@@ -104,11 +150,21 @@ func (s *KeyFactory) Set(obj interface{}) error {
 
 func (s *KeyFactory) set(key APIKey) error {
 	logrus.Infof("Adding new APIKey named %s in namespace %s", key.ObjectMeta.Name, key.ObjectMeta.Namespace)
+	if s.nameMap[key.ObjectMeta.Name] == nil {
+		s.nameMap[key.ObjectMeta.Name] = map[string]string{}
+	}
+	// remove the previous data of a key that has been rotated
+	if old, ok := s.nameMap[key.ObjectMeta.Name][key.ObjectMeta.Namespace]; ok && old != key.Spec.APIKeyData {
+		delete(s.keyMap, old)
+	}
+	s.nameMap[key.ObjectMeta.Name][key.ObjectMeta.Namespace] = key.Spec.APIKeyData
 	s.keyMap[key.Spec.APIKeyData] = key
 	return nil
 }
 
-// Get retrieves a particual key in the store. If not found, nil is returned.
+// Get retrieves the key in the store that matches a presented API key.
+// If not found, nil is returned. If the key is disabled, expired or not
+// yet valid, the key is returned along with an error describing why.
 func (s *KeyFactory) Get(params ...interface{}) (interface{}, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -119,14 +175,43 @@ func (s *KeyFactory) Get(params ...interface{}) (interface{}, error) {
 	if !ok {
 		return nil, errors.New("when retrieving a key, use the keys name")
 	}
-	k, ok := s.keyMap[name]
+	k, ok := s.keyMap[HashAPIKey(name)]
 	if !ok {
 		return nil, nil
 	}
-	return k, nil
+	return k, k.CheckValidity(time.Now())
+}
+
+// GetByName returns every key in the store with a given name.
+// Keys with the same name may exist in different namespaces.
+func (s *KeyFactory) GetByName(name string) []APIKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := []APIKey{}
+	for _, hash := range s.nameMap[name] {
+		if key, ok := s.keyMap[hash]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ExpiringWithin returns every key in the store that
+// is not yet expired but will expire within a duration
+func (s *KeyFactory) ExpiringWithin(d time.Duration, currTime time.Time) []APIKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := []APIKey{}
+	for _, key := range s.keyMap {
+		if key.ExpiresWithin(d, currTime) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
-// Delete will remove a particular key from the store
+// Delete will remove a particular key from the store. The key is found by
+// its name and namespace so that it does not need to be decrypted.
 func (s *KeyFactory) Delete(obj interface{}) (interface{}, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -134,11 +219,19 @@ func (s *KeyFactory) Delete(obj interface{}) (interface{}, error) {
 	if !ok {
 		return nil, errors.New("there's no way this api key could've gotten in here")
 	}
-	actual, ok := s.keyMap[key.Spec.APIKeyData]
+	hash, ok := s.nameMap[key.ObjectMeta.Name][key.ObjectMeta.Namespace]
 	if !ok {
 		return nil, nil
 	}
-	delete(s.keyMap, key.Spec.APIKeyData)
+	delete(s.nameMap[key.ObjectMeta.Name], key.ObjectMeta.Namespace)
+	if len(s.nameMap[key.ObjectMeta.Name]) == 0 {
+		delete(s.nameMap, key.ObjectMeta.Name)
+	}
+	actual, ok := s.keyMap[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.keyMap, hash)
 	return actual, nil
 }
 
@@ -149,16 +242,45 @@ func (s *KeyFactory) IsEmpty() bool {
 	return len(s.keyMap) == 0
 }
 
-// Decrypt decrypts the data in an APIKey
+// Decrypt decrypts the data in an APIKey and replaces it with its keyed
+// hash along with the key signed requests are verified with. The
+// decrypted API key is never retained.
 func (k *APIKey) Decrypt() error {
 	cipherText, err := hex.DecodeString(k.Spec.APIKeyData)
 	if err != nil {
 		return err
 	}
-	unencryptedAPIKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, APIKeyDecryptionKey, cipherText, []byte("kanali"))
+	unencryptedAPIKey, err := APIKeyDecryptionKeys.Decrypt(k.Spec.KeyID, cipherText)
 	if err != nil {
 		return err
 	}
-	k.Spec.APIKeyData = string(unencryptedAPIKey)
+	k.Spec.APIKeyData = hashAPIKey(unencryptedAPIKey)
+	k.Spec.SigningKey = DeriveSigningKey(unencryptedAPIKey)
+	for i := range unencryptedAPIKey {
+		unencryptedAPIKey[i] = 0
+	}
 	return nil
 }
+
+// CheckValidity returns an error if an APIKey can not be used at a given time
+func (k APIKey) CheckValidity(currTime time.Time) error {
+	if k.Spec.Disabled {
+		return ErrAPIKeyDisabled
+	}
+	if k.Spec.NotBefore != nil && currTime.Before(k.Spec.NotBefore.Time) {
+		return ErrAPIKeyNotYetValid
+	}
+	if k.Spec.ExpiresAt != nil && !currTime.Before(k.Spec.ExpiresAt.Time) {
+		return ErrAPIKeyExpired
+	}
+	return nil
+}
+
+// ExpiresWithin reports whether an APIKey that has not
+// yet expired will expire within a duration
+func (k APIKey) ExpiresWithin(d time.Duration, currTime time.Time) bool {
+	if k.Spec.ExpiresAt == nil || !currTime.Before(k.Spec.ExpiresAt.Time) {
+		return false
+	}
+	return k.Spec.ExpiresAt.Time.Sub(currTime) <= d
+}
@@ -22,8 +22,10 @@ package spec
 
 import (
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"k8s.io/kubernetes/pkg/api"
@@ -56,9 +58,9 @@ func TestAPIKeySet(t *testing.T) {
 	store.Set(keyList.Keys[2])
 	err := store.Set(APIProxy{})
 	assert.Equal("grrr - you're only allowed add api keys to the api key store.... duh", err.Error(), "error expected")
-	assert.Equal(keyList.Keys[0], store.keyMap["iamencrypted1"], message)
-	assert.Equal(keyList.Keys[1], store.keyMap["iamencrypted2"], message)
-	assert.Equal(keyList.Keys[2], store.keyMap["iamencrypted3"], message)
+	assert.Equal(keyList.Keys[0], store.keyMap[HashAPIKey("iamencrypted1")], message)
+	assert.Equal(keyList.Keys[1], store.keyMap[HashAPIKey("iamencrypted2")], message)
+	assert.Equal(keyList.Keys[2], store.keyMap[HashAPIKey("iamencrypted3")], message)
 }
 
 func TestAPIKeyUpdate(t *testing.T) {
@@ -73,9 +75,9 @@ func TestAPIKeyUpdate(t *testing.T) {
 	store.Update(keyList.Keys[2])
 	err := store.Update(APIProxy{})
 	assert.Equal("grrr - you're only allowed add api keys to the api key store.... duh", err.Error(), "error expected")
-	assert.Equal(keyList.Keys[0], store.keyMap["iamencrypted1"], message)
-	assert.Equal(keyList.Keys[1], store.keyMap["iamencrypted2"], message)
-	assert.Equal(keyList.Keys[2], store.keyMap["iamencrypted3"], message)
+	assert.Equal(keyList.Keys[0], store.keyMap[HashAPIKey("iamencrypted1")], message)
+	assert.Equal(keyList.Keys[1], store.keyMap[HashAPIKey("iamencrypted2")], message)
+	assert.Equal(keyList.Keys[2], store.keyMap[HashAPIKey("iamencrypted3")], message)
 }
 
 func TestAPIKeyClear(t *testing.T) {
@@ -159,6 +161,40 @@ func TestAPIKeyDelete(t *testing.T) {
 	assert.Equal(keyList.Keys[2], result, "deleted key should be returned")
 }
 
+func TestAPIKeyGetByName(t *testing.T) {
+	assert := assert.New(t)
+	store := KeyStore
+	keyList := getTestAPIKeyList()
+
+	store.Clear()
+	defer store.Clear()
+	store.Set(keyList.Keys[0])
+	store.Set(keyList.Keys[1])
+
+	assert.Equal([]APIKey{keyList.Keys[0]}, store.GetByName("abc123"))
+	assert.Equal([]APIKey{}, store.GetByName("ghi789"))
+
+	otherNamespace := keyList.Keys[0]
+	otherNamespace.ObjectMeta.Namespace = "bar"
+	otherNamespace.Spec.APIKeyData = HashAPIKey("iamencrypted4")
+	store.Set(otherNamespace)
+	assert.Equal(2, len(store.GetByName("abc123")))
+
+	rotated := keyList.Keys[0]
+	rotated.Spec.APIKeyData = HashAPIKey("iamrotated")
+	store.Set(rotated)
+	key, _ := store.Get("iamencrypted1")
+	assert.Nil(key, "the previous data of a rotated key should be removed")
+	assert.Equal(2, len(store.GetByName("abc123")))
+
+	store.Delete(rotated)
+	assert.Equal([]APIKey{otherNamespace}, store.GetByName("abc123"))
+	store.Delete(otherNamespace)
+	assert.Equal([]APIKey{}, store.GetByName("abc123"))
+	store.Delete(keyList.Keys[1])
+	assert.Equal(0, len(store.nameMap))
+}
+
 func TestDecrypt(t *testing.T) {
 
 	assert := assert.New(t)
@@ -199,7 +235,7 @@ I0teIby/h2MN7yPNjFgIq64JneHpKwEH04+qy/dWQafxrpBlf9tfwg==
 		assert.Fail(err.Error())
 	}
 
-	APIKeyDecryptionKey = privateKey
+	APIKeyDecryptionKeys.Set(DecryptionKey{Key: privateKey})
 
 	apiKey := &APIKey{
 		TypeMeta: unversioned.TypeMeta{},
@@ -216,7 +252,8 @@ I0teIby/h2MN7yPNjFgIq64JneHpKwEH04+qy/dWQafxrpBlf9tfwg==
 		assert.Fail(err.Error())
 	}
 
-	assert.Equal("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9", apiKey.Spec.APIKeyData)
+	assert.Equal(HashAPIKey("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9"), apiKey.Spec.APIKeyData, "only the hash of the api key should be retained")
+	assert.Equal(DeriveSigningKey([]byte("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9")), apiKey.Spec.SigningKey, "the signing key should be derived from the api key")
 
 	apiKey.Spec.APIKeyData = ":=-0"
 
@@ -259,7 +296,7 @@ vV+J+U6XO9amQH/QNUNg/JJObJhQjeGvunZk3FkMXzAh+lUC264d28UYiMTwP/Md
 	if err != nil {
 		assert.Fail(err.Error())
 	}
-	APIKeyDecryptionKey = privateKey
+	APIKeyDecryptionKeys.Set(DecryptionKey{Key: privateKey})
 
 	err = apiKey.Decrypt()
 	assert.NotNil(err)
@@ -279,7 +316,7 @@ func getTestAPIKeyList() *APIKeyList {
 					Namespace: "foo",
 				},
 				Spec: APIKeySpec{
-					APIKeyData: "iamencrypted1",
+					APIKeyData: HashAPIKey("iamencrypted1"),
 				},
 			},
 			{
@@ -289,7 +326,7 @@ func getTestAPIKeyList() *APIKeyList {
 					Namespace: "foo",
 				},
 				Spec: APIKeySpec{
-					APIKeyData: "iamencrypted2",
+					APIKeyData: HashAPIKey("iamencrypted2"),
 				},
 			},
 			{
@@ -299,10 +336,82 @@ func getTestAPIKeyList() *APIKeyList {
 					Namespace: "foo",
 				},
 				Spec: APIKeySpec{
-					APIKeyData: "iamencrypted3",
+					APIKeyData: HashAPIKey("iamencrypted3"),
 				},
 			},
 		},
 	}
 
 }
+
+func TestHashAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(HashAPIKey("foo"), HashAPIKey("foo"))
+	assert.NotEqual(HashAPIKey("foo"), HashAPIKey("bar"))
+	assert.NotContains(HashAPIKey("foo"), "foo")
+	assert.Equal(64, len(HashAPIKey("foo")))
+}
+
+func TestDeriveSigningKey(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(DeriveSigningKey([]byte("foo")), DeriveSigningKey([]byte("foo")))
+	assert.NotEqual(DeriveSigningKey([]byte("foo")), DeriveSigningKey([]byte("bar")))
+	assert.Equal("32af0eaddc7d634a2d79d1d0c1982f2f31dc5301ace6d7e8eb01088b426769dd", hex.EncodeToString(DeriveSigningKey([]byte("foo"))))
+}
+
+func TestAPIKeyCheckValidity(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	past := unversioned.NewTime(now.Add(-time.Hour))
+	future := unversioned.NewTime(now.Add(time.Hour))
+
+	assert.Nil(APIKey{}.CheckValidity(now))
+	assert.Equal(ErrAPIKeyDisabled, APIKey{Spec: APIKeySpec{Disabled: true}}.CheckValidity(now))
+	assert.Equal(ErrAPIKeyExpired, APIKey{Spec: APIKeySpec{ExpiresAt: &past}}.CheckValidity(now))
+	assert.Equal(ErrAPIKeyExpired, APIKey{Spec: APIKeySpec{ExpiresAt: &future}}.CheckValidity(future.Time))
+	assert.Nil(APIKey{Spec: APIKeySpec{ExpiresAt: &future}}.CheckValidity(now))
+	assert.Equal(ErrAPIKeyNotYetValid, APIKey{Spec: APIKeySpec{NotBefore: &future}}.CheckValidity(now))
+	assert.Nil(APIKey{Spec: APIKeySpec{NotBefore: &past, ExpiresAt: &future}}.CheckValidity(now))
+}
+
+func TestAPIKeyExpiresWithin(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	past := unversioned.NewTime(now.Add(-time.Hour))
+	future := unversioned.NewTime(now.Add(time.Hour))
+
+	assert.False(APIKey{}.ExpiresWithin(time.Hour, now))
+	assert.False(APIKey{Spec: APIKeySpec{ExpiresAt: &past}}.ExpiresWithin(time.Hour, now), "expired keys are not expiring soon")
+	assert.True(APIKey{Spec: APIKeySpec{ExpiresAt: &future}}.ExpiresWithin(time.Hour, now))
+	assert.False(APIKey{Spec: APIKeySpec{ExpiresAt: &future}}.ExpiresWithin(time.Minute, now))
+}
+
+func TestAPIKeyGetValidity(t *testing.T) {
+	assert := assert.New(t)
+	store := KeyStore
+	defer store.Clear()
+	future := unversioned.NewTime(time.Now().Add(time.Hour))
+
+	store.Clear()
+	store.Set(APIKey{
+		ObjectMeta: api.ObjectMeta{Name: "abc123"},
+		Spec:       APIKeySpec{APIKeyData: HashAPIKey("one"), Disabled: true},
+	})
+	store.Set(APIKey{
+		ObjectMeta: api.ObjectMeta{Name: "def456"},
+		Spec:       APIKeySpec{APIKeyData: HashAPIKey("two"), ExpiresAt: &future},
+	})
+
+	key, err := store.Get("one")
+	assert.Equal(ErrAPIKeyDisabled, err)
+	assert.Equal("abc123", key.(APIKey).ObjectMeta.Name, "invalid keys should still be returned")
+
+	key, err = store.Get("two")
+	assert.Nil(err)
+	assert.NotNil(key)
+
+	expiring := store.ExpiringWithin(2*time.Hour, time.Now())
+	assert.Equal(1, len(expiring))
+	assert.Equal("def456", expiring[0].ObjectMeta.Name)
+	assert.Equal(0, len(store.ExpiringWithin(time.Minute, time.Now())))
+}
@@ -21,12 +21,18 @@
 package spec
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/utils"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/unversioned"
 )
@@ -47,8 +53,21 @@ type APIKeyBinding struct {
 
 // APIKeyBindingSpec represents the data fields for the APIKeyBinding TPR
 type APIKeyBindingSpec struct {
-	APIProxyName string `json:"proxy"`
-	Keys         []Key  `json:"keys"`
+	APIProxyName      string      `json:"proxy"`
+	APIProxyNamespace string      `json:"proxyNamespace,omitempty"`
+	Keys              []Key       `json:"keys"`
+	Principals        []Principal `json:"principals,omitempty"`
+}
+
+// Principal defines a mutual TLS client that has some level of
+// permissions to the proxy this binding is bound to. A client
+// certificate identifies a principal if it has the common name and,
+// if any are given, at least one of the subject alternative names.
+// Principal names share a namespace with the keys of the binding.
+type Principal struct {
+	Key        `json:",inline"`
+	CommonName string   `json:"commonName,omitempty"`
+	SANs       []string `json:"sans,omitempty"`
 }
 
 // Rate defines rate limit rule
@@ -60,18 +79,38 @@ type Rate struct {
 // Path represents the fine grained subpath that
 // finer permissions will be assined for this apikey
 type Path struct {
-	Path string `json:"path,omitempty"`
-	Rule Rule   `json:"rule,omitempty"`
+	Path       string      `json:"path,omitempty"`
+	Rule       Rule        `json:"rule,omitempty"`
+	Quota      int         `json:"quota,omitempty"`
+	Rate       *Rate       `json:"rate,omitempty"`
+	VerbLimits []VerbLimit `json:"verbLimits,omitempty"`
+}
+
+// VerbLimit defines a quota and rate limit for a
+// single HTTP method on a subpath
+type VerbLimit struct {
+	Verb  string `json:"verb"`
+	Quota int    `json:"quota,omitempty"`
+	Rate  *Rate  `json:"rate,omitempty"`
+}
+
+// Limit is a quota and rate limit whose traffic
+// is tracked against its own bucket
+type Limit struct {
+	Bucket string
+	Quota  int
+	Rate   *Rate
 }
 
 // Key defines an apikey that has some level of permissions
 // the the proxy this binding is bound to
 type Key struct {
-	Name        string  `json:"name"`
-	Quota       int     `json:"quota,omitempty"`
-	Rate        *Rate   `json:"rate,omitempty"`
-	DefaultRule Rule    `json:"defaultRule,omitempty"`
-	Subpaths    []*Path `json:"subpaths,omitempty"`
+	Name        string    `json:"name"`
+	Quota       int       `json:"quota,omitempty"`
+	Rate        *Rate     `json:"rate,omitempty"`
+	DefaultRule Rule      `json:"defaultRule,omitempty"`
+	Subpaths    []*Path   `json:"subpaths,omitempty"`
+	IPFilter    *IPFilter `json:"ipFilter,omitempty"`
 }
 
 // Rule defines the global and granular rules that this
@@ -143,6 +182,17 @@ func (s *BindingFactory) Set(obj interface{}) error {
 func (s *BindingFactory) set(binding APIKeyBinding) error {
 	logrus.Infof("Adding new APIKeyBinding named %s in namespace %s", binding.ObjectMeta.Name, binding.ObjectMeta.Namespace)
 
+	for _, key := range binding.Spec.Keys {
+		if err := key.IPFilter.compile(); err != nil {
+			return fmt.Errorf("key %s has an %s", key.Name, err.Error())
+		}
+	}
+	for _, principal := range binding.Spec.Principals {
+		if err := principal.IPFilter.compile(); err != nil {
+			return fmt.Errorf("principal %s has an %s", principal.Name, err.Error())
+		}
+	}
+
 	for _, key := range binding.Spec.Keys {
 		for _, subpath := range key.Subpaths {
 			if subpath.Path[0] != '/' {
@@ -150,14 +200,21 @@ func (s *BindingFactory) set(binding APIKeyBinding) error {
 			}
 		}
 	}
+	for _, principal := range binding.Spec.Principals {
+		for _, subpath := range principal.Subpaths {
+			if subpath.Path[0] != '/' {
+				subpath.Path = "/" + subpath.Path
+			}
+		}
+	}
 
 	if s.bindingMap[binding.ObjectMeta.Namespace] == nil {
 		s.bindingMap[binding.ObjectMeta.Namespace] = map[string]APIKeyBinding{
-			binding.Spec.APIProxyName: binding,
+			binding.getStoreKey(): binding,
 		}
 		return nil
 	}
-	s.bindingMap[binding.ObjectMeta.Namespace][binding.Spec.APIProxyName] = binding
+	s.bindingMap[binding.ObjectMeta.Namespace][binding.getStoreKey()] = binding
 	return nil
 }
 
@@ -185,6 +242,32 @@ func (s *BindingFactory) Get(params ...interface{}) (interface{}, error) {
 	return nil, nil
 }
 
+// GetForProxy returns every binding that applies to an APIProxy. The
+// binding in the namespace of the proxy is first, followed by the bindings
+// in other namespaces that the proxy allows, ordered by namespace.
+func (s *BindingFactory) GetForProxy(proxy APIProxy) []APIKeyBinding {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	bindings := []APIKeyBinding{}
+	if binding, ok := s.bindingMap[proxy.ObjectMeta.Namespace][proxy.ObjectMeta.Name]; ok {
+		bindings = append(bindings, binding)
+	}
+	namespaces := []string{}
+	for namespace := range s.bindingMap {
+		if namespace != proxy.ObjectMeta.Namespace && proxy.AllowsBindingNamespace(namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	storeKey := getCrossNamespaceStoreKey(proxy.ObjectMeta.Namespace, proxy.ObjectMeta.Name)
+	for _, namespace := range namespaces {
+		if binding, ok := s.bindingMap[namespace][storeKey]; ok {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings
+}
+
 // Delete will remove a particular binding from the store
 func (s *BindingFactory) Delete(obj interface{}) (interface{}, error) {
 	s.mutex.Lock()
@@ -196,11 +279,11 @@ func (s *BindingFactory) Delete(obj interface{}) (interface{}, error) {
 	if !ok {
 		return nil, errors.New("there's no way this api key binding could've gotten in here")
 	}
-	val, ok := s.bindingMap[binding.ObjectMeta.Namespace][binding.Spec.APIProxyName]
+	val, ok := s.bindingMap[binding.ObjectMeta.Namespace][binding.getStoreKey()]
 	if !ok {
 		return nil, nil
 	}
-	delete(s.bindingMap[binding.ObjectMeta.Namespace], binding.Spec.APIProxyName)
+	delete(s.bindingMap[binding.ObjectMeta.Namespace], binding.getStoreKey())
 	if len(s.bindingMap[binding.ObjectMeta.Namespace]) == 0 {
 		delete(s.bindingMap, binding.ObjectMeta.Namespace)
 	}
@@ -210,13 +293,72 @@ func (s *BindingFactory) Delete(obj interface{}) (interface{}, error) {
 // GetRule returns the highest priority rule to use
 // for the incoming request path
 func (k *Key) GetRule(targetPath string) Rule {
+	if subpath := k.getSubpath(targetPath); subpath != nil {
+		return subpath.Rule
+	}
+	return k.DefaultRule
+}
+
+// IsAuthorized reports whether a rule grants access for an HTTP method
+func (r Rule) IsAuthorized(method string) bool {
+	if r.Global {
+		return true
+	}
+	if r.Granular == nil {
+		return false
+	}
+	for _, verb := range r.Granular.Verbs {
+		if strings.EqualFold(verb, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLimits returns every limit that applies to a request for the
+// incoming request path and HTTP method. The key wide limit is always
+// first, followed by the limits of the highest priority subpath.
+func (k *Key) GetLimits(targetPath, method string) []Limit {
+	limits := []Limit{{Bucket: k.Name, Quota: k.Quota, Rate: k.Rate}}
+
+	subpath := k.getSubpath(targetPath)
+	if subpath == nil {
+		return limits
+	}
+
+	if subpath.Quota > 0 || subpath.Rate != nil {
+		limits = append(limits, Limit{Bucket: getBucketName(k.Name, subpath.Path, ""), Quota: subpath.Quota, Rate: subpath.Rate})
+	}
+
+	for _, limit := range subpath.VerbLimits {
+		if !strings.EqualFold(limit.Verb, method) {
+			continue
+		}
+		limits = append(limits, Limit{Bucket: getBucketName(k.Name, subpath.Path, method), Quota: limit.Quota, Rate: limit.Rate})
+	}
+
+	return limits
+}
+
+func (k *Key) getSubpath(targetPath string) *Path {
 	for _, subpath := range k.Subpaths {
 		if result, err := regexp.MatchString("^"+subpath.Path, targetPath); err != nil || !result {
 			continue
 		}
-		return subpath.Rule
+		return subpath
 	}
-	return k.DefaultRule
+	return nil
+}
+
+// getBucketName returns the name that traffic for a subpath, and optionally
+// an HTTP method, is tracked against. Subpaths are regular expressions and
+// may contain any character so they are hashed. See BucketName.
+func getBucketName(keyName, path, method string) string {
+	sum := sha256.Sum256([]byte(path))
+	if method == "" {
+		return BucketName(keyName, hex.EncodeToString(sum[:8]))
+	}
+	return BucketName(keyName, hex.EncodeToString(sum[:8]), strings.ToUpper(method))
 }
 
 // GetAPIKey retrieves a pointer to a Key object for a given
@@ -231,3 +373,104 @@ func (b *APIKeyBinding) GetAPIKey(apiKeyName string) *Key {
 	return nil
 
 }
+
+// GetProxyNamespace returns the namespace of the APIProxy this binding
+// applies to. Unless specified, it is the namespace of the binding.
+func (b *APIKeyBinding) GetProxyNamespace() string {
+	if b.Spec.APIProxyNamespace == "" {
+		return b.ObjectMeta.Namespace
+	}
+	return b.Spec.APIProxyNamespace
+}
+
+// IsCrossNamespace reports whether this binding applies to
+// an APIProxy in a namespace other than its own
+func (b *APIKeyBinding) IsCrossNamespace() bool {
+	return b.GetProxyNamespace() != b.ObjectMeta.Namespace
+}
+
+// GetTrafficProxyName returns the proxy name that the traffic of this binding
+// is tracked under, alongside the namespace of the binding. The traffic of a
+// cross namespace binding is tracked under the namespace of the proxy as well
+// so that it can not collide with a proxy of the same name. Kubernetes names
+// can not contain a slash so there can be no collisions.
+func (b *APIKeyBinding) GetTrafficProxyName() string {
+	if !b.IsCrossNamespace() {
+		return b.Spec.APIProxyName
+	}
+	return getCrossNamespaceStoreKey(b.Spec.APIProxyNamespace, b.Spec.APIProxyName)
+}
+
+// GetBoundKey retrieves a pointer to the Key of this binding for an APIKey.
+// A cross namespace binding only binds APIKeys in its own namespace.
+func (b *APIKeyBinding) GetBoundKey(key APIKey) *Key {
+
+	if b.IsCrossNamespace() && key.ObjectMeta.Namespace != b.ObjectMeta.Namespace {
+		return nil
+	}
+	return b.GetAPIKey(key.ObjectMeta.Name)
+
+}
+
+// getStoreKey returns the key this binding is stored under within its namespace
+func (b *APIKeyBinding) getStoreKey() string {
+	return b.GetTrafficProxyName()
+}
+
+func getCrossNamespaceStoreKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// GetPrincipal retrieves a pointer to the Key of the first
+// principal that a verified client certificate identifies
+func (b *APIKeyBinding) GetPrincipal(cert *x509.Certificate) *Key {
+
+	for _, principal := range b.Spec.Principals {
+		if principal.Matches(cert) {
+			key := principal.Key
+			return &key
+		}
+	}
+	return nil
+
+}
+
+// getKey retrieves a pointer to the Key of an apikey
+// or principal with the given name
+func (b *APIKeyBinding) getKey(name string) *Key {
+
+	if key := b.GetAPIKey(name); key != nil {
+		return key
+	}
+	for _, principal := range b.Spec.Principals {
+		if strings.ToLower(principal.Name) == strings.ToLower(name) {
+			key := principal.Key
+			return &key
+		}
+	}
+	return nil
+
+}
+
+// Matches reports whether a client certificate identifies a principal.
+// A principal without a common name or subject alternative names
+// matches no certificates.
+func (p Principal) Matches(cert *x509.Certificate) bool {
+	if cert == nil || (p.CommonName == "" && len(p.SANs) < 1) {
+		return false
+	}
+	if p.CommonName != "" && p.CommonName != cert.Subject.CommonName {
+		return false
+	}
+	if len(p.SANs) < 1 {
+		return true
+	}
+	for _, san := range utils.GetSANs(cert) {
+		for _, expected := range p.SANs {
+			if san == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -21,6 +21,9 @@
 package spec
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -193,6 +196,45 @@ func TestGetRule(t *testing.T) {
 
 }
 
+func TestGetLimits(t *testing.T) {
+	assert := assert.New(t)
+	key := Key{
+		Name:  "franks-api-key",
+		Quota: 100,
+		Subpaths: []*Path{
+			{
+				Path:  "/reports",
+				Quota: 10,
+				VerbLimits: []VerbLimit{
+					{
+						Verb: "post",
+						Rate: &Rate{Amount: 1, Unit: "minute"},
+					},
+				},
+			},
+			{
+				Path: "/accounts",
+				Rule: Rule{Global: true},
+			},
+		},
+	}
+
+	assert.Equal([]Limit{{Bucket: "franks-api-key", Quota: 100}}, key.GetLimits("/foo", "GET"))
+	assert.Equal([]Limit{{Bucket: "franks-api-key", Quota: 100}}, key.GetLimits("/accounts", "GET"))
+
+	limits := key.GetLimits("/reports/123", "GET")
+	assert.Equal(2, len(limits))
+	assert.Equal(10, limits[1].Quota)
+	assert.Equal(getBucketName("franks-api-key", "/reports", ""), limits[1].Bucket)
+
+	limits = key.GetLimits("/reports", "POST")
+	assert.Equal(3, len(limits))
+	assert.Equal(&Rate{Amount: 1, Unit: "minute"}, limits[2].Rate)
+	assert.Equal(getBucketName("franks-api-key", "/reports", "POST"), limits[2].Bucket)
+	assert.NotEqual(limits[1].Bucket, limits[2].Bucket)
+	assert.True(strings.HasSuffix(limits[2].Bucket, "_POST"))
+}
+
 func TestAPIKeyBindingGet(t *testing.T) {
 	assert := assert.New(t)
 	store := BindingStore
@@ -223,6 +265,77 @@ func TestAPIKeyBindingGet(t *testing.T) {
 	assert.Equal(keyList.Bindings[2], result, message)
 }
 
+func TestAPIKeyBindingGetForProxy(t *testing.T) {
+	assert := assert.New(t)
+	store := BindingStore
+	store.Clear()
+	defer store.Clear()
+
+	newBinding := func(namespace, proxyNamespace string) APIKeyBinding {
+		return APIKeyBinding{
+			ObjectMeta: api.ObjectMeta{Name: "binding", Namespace: namespace},
+			Spec:       APIKeyBindingSpec{APIProxyName: "proxy", APIProxyNamespace: proxyNamespace},
+		}
+	}
+
+	own := newBinding("team", "")
+	platform := newBinding("platform", "team")
+	other := newBinding("other", "team")
+	local := newBinding("platform", "")
+	store.Set(own)
+	store.Set(platform)
+	store.Set(other)
+	store.Set(local)
+
+	proxy := APIProxy{ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "team"}}
+	assert.Equal([]APIKeyBinding{own}, store.GetForProxy(proxy), "cross namespace bindings should not apply unless allowed")
+
+	proxy.Spec.AllowedBindingNamespaces = []string{"platform"}
+	assert.Equal([]APIKeyBinding{own, platform}, store.GetForProxy(proxy))
+
+	proxy.Spec.AllowedBindingNamespaces = []string{"*"}
+	assert.Equal([]APIKeyBinding{own, other, platform}, store.GetForProxy(proxy))
+
+	result, _ := store.Get("proxy", "platform")
+	assert.Equal(local, result, "a cross namespace binding should not replace a binding in its own namespace")
+
+	store.Delete(own)
+	assert.Equal([]APIKeyBinding{other, platform}, store.GetForProxy(proxy))
+	store.Delete(platform)
+	assert.Equal([]APIKeyBinding{other}, store.GetForProxy(proxy))
+	result, _ = store.Get("proxy", "platform")
+	assert.Equal(local, result)
+}
+
+func TestAPIKeyBindingCrossNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	binding := APIKeyBinding{
+		ObjectMeta: api.ObjectMeta{Name: "binding", Namespace: "team"},
+		Spec: APIKeyBindingSpec{
+			APIProxyName: "proxy",
+			Keys:         []Key{{Name: "key"}},
+		},
+	}
+	key := APIKey{ObjectMeta: api.ObjectMeta{Name: "key", Namespace: "other"}}
+
+	assert.Equal("team", binding.GetProxyNamespace())
+	assert.False(binding.IsCrossNamespace())
+	assert.Equal("proxy", binding.GetTrafficProxyName())
+	assert.Equal("key", binding.GetBoundKey(key).Name)
+
+	binding.Spec.APIProxyNamespace = "team"
+	assert.False(binding.IsCrossNamespace())
+
+	binding.ObjectMeta.Namespace = "platform"
+	assert.Equal("team", binding.GetProxyNamespace())
+	assert.True(binding.IsCrossNamespace())
+	assert.Equal("team/proxy", binding.GetTrafficProxyName())
+	assert.Nil(binding.GetBoundKey(key), "a cross namespace binding should only bind keys in its own namespace")
+	key.ObjectMeta.Namespace = "platform"
+	assert.Equal("key", binding.GetBoundKey(key).Name)
+}
+
 func TestAPIKeyBindingDelete(t *testing.T) {
 	assert := assert.New(t)
 	store := BindingStore
@@ -404,3 +517,52 @@ func getTestAPIKeyBindingList() *APIKeyBindingList {
 	}
 
 }
+
+func TestRuleIsAuthorized(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(Rule{Global: true}.IsAuthorized("DELETE"))
+	assert.False(Rule{}.IsAuthorized("GET"))
+	assert.True(Rule{Granular: &GranularProxy{Verbs: []string{"get", "POST"}}}.IsAuthorized("GET"))
+	assert.True(Rule{Granular: &GranularProxy{Verbs: []string{"get", "POST"}}}.IsAuthorized("post"))
+	assert.False(Rule{Granular: &GranularProxy{Verbs: []string{"GET"}}}.IsAuthorized("PUT"))
+}
+
+func TestPrincipalMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "frank"},
+		DNSNames:       []string{"frank.example.com"},
+		EmailAddresses: []string{"frank@example.com"},
+	}
+
+	assert.False(Principal{}.Matches(cert))
+	assert.False(Principal{CommonName: "frank"}.Matches(nil))
+	assert.True(Principal{CommonName: "frank"}.Matches(cert))
+	assert.False(Principal{CommonName: "bob"}.Matches(cert))
+	assert.True(Principal{SANs: []string{"bob.example.com", "frank.example.com"}}.Matches(cert))
+	assert.True(Principal{SANs: []string{"frank@example.com"}}.Matches(cert))
+	assert.False(Principal{SANs: []string{"bob.example.com"}}.Matches(cert))
+	assert.True(Principal{CommonName: "frank", SANs: []string{"frank.example.com"}}.Matches(cert))
+	assert.False(Principal{CommonName: "bob", SANs: []string{"frank.example.com"}}.Matches(cert))
+}
+
+func TestGetPrincipal(t *testing.T) {
+	assert := assert.New(t)
+
+	binding := APIKeyBinding{
+		Spec: APIKeyBindingSpec{
+			Principals: []Principal{
+				{Key: Key{Name: "principal-one"}, CommonName: "bob"},
+				{Key: Key{Name: "principal-two", DefaultRule: Rule{Global: true}}, CommonName: "frank"},
+			},
+		},
+	}
+
+	key := binding.GetPrincipal(&x509.Certificate{Subject: pkix.Name{CommonName: "frank"}})
+	assert.Equal("principal-two", key.Name)
+	assert.True(key.DefaultRule.Global)
+	assert.Nil(binding.GetPrincipal(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}))
+	assert.Equal("principal-one", binding.getKey("Principal-One").Name)
+	assert.Nil(binding.getKey("principal-three"))
+}
@@ -21,13 +21,18 @@
 package spec
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"regexp"
 	"strings"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
 	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/unversioned"
 )
@@ -43,18 +48,124 @@ type APIProxyList struct {
 type APIProxy struct {
 	unversioned.TypeMeta `json:",inline"`
 	api.ObjectMeta       `json:"metadata,omitempty"`
-	Spec                 APIProxySpec `json:"spec"`
+	Spec                 APIProxySpec   `json:"spec"`
+	Status               APIProxyStatus `json:"status,omitempty"`
+	// plugins is the chain resolved by GetPlugins when the
+	// APIProxy is stored so that it is not resolved per request
+	plugins []Plugin
+}
+
+// APIProxyStatus represents the state of an APIProxy as observed by Kanali
+type APIProxyStatus struct {
+	Plugins []PluginStatus `json:"plugins,omitempty"`
+}
+
+// PluginStatus reports whether a plugin used by an APIProxy could be loaded
+// and configured and, if it implements health checks, whether it is healthy
+type PluginStatus struct {
+	Name      string `json:"name"`
+	Version   string `json:"version,omitempty"`
+	Loaded    bool   `json:"loaded"`
+	Error     string `json:"error,omitempty"`
+	Unhealthy string `json:"unhealthy,omitempty"`
 }
 
 // APIProxySpec represents the data fields for the APIProxy TPR
 type APIProxySpec struct {
-	Path    string   `json:"path"`
-	Target  string   `json:"target,omitempty"`
-	Mock    *Mock    `json:"mock,omitempty"`
-	Hosts   []Host   `json:"hosts,omitempty"`
-	Service Service  `json:"service,omitempty"`
-	Plugins []Plugin `json:"plugins,omitempty"`
-	SSL     SSL      `json:"ssl,omitempty"`
+	Path                     string       `json:"path"`
+	Target                   string       `json:"target,omitempty"`
+	Mock                     *Mock        `json:"mock,omitempty"`
+	Hosts                    []Host       `json:"hosts,omitempty"`
+	Service                  Service      `json:"service,omitempty"`
+	Plugins                  []Plugin     `json:"plugins,omitempty"`
+	SSL                      SSL          `json:"ssl,omitempty"`
+	RateLimit                *RateLimit   `json:"rateLimit,omitempty"`
+	Concurrency              *Concurrency `json:"concurrency,omitempty"`
+	APIKeyAuth               *APIKeyAuth  `json:"apiKey,omitempty"`
+	JWT                      *JWT         `json:"jwt,omitempty"`
+	IPFilter                 *IPFilter    `json:"ipFilter,omitempty"`
+	AllowedBindingNamespaces []string     `json:"allowedBindingNamespaces,omitempty"`
+	ExcludeDefaultPlugins    []string     `json:"excludeDefaultPlugins,omitempty"`
+}
+
+// IPFilter restricts the client IP addresses that may make requests.
+// Addresses may be given either as IP addresses or CIDR ranges.
+type IPFilter struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	// the parsed networks are cached when the filter is stored
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	compiled bool
+}
+
+// JWT enables validation of JSON Web Token bearer tokens for an APIProxy.
+// Signing keys are loaded from a JSON Web Key Set URL or a Secret.
+type JWT struct {
+	Issuer        string        `json:"issuer,omitempty"`
+	Audiences     []string      `json:"audiences,omitempty"`
+	JWKSURL       string        `json:"jwksUrl,omitempty"`
+	JWKSSecret    string        `json:"jwksSecret,omitempty"`
+	Rules         []JWTRule     `json:"rules,omitempty"`
+	ForwardClaims []ClaimHeader `json:"forwardClaims,omitempty"`
+}
+
+// JWTRule defines the scopes and claim values that a token must have
+// for requests to a subpath using any of the given HTTP methods
+type JWTRule struct {
+	Path   string            `json:"path,omitempty"`
+	Verbs  []string          `json:"verbs,omitempty"`
+	Scopes []string          `json:"scopes,omitempty"`
+	Claims map[string]string `json:"claims,omitempty"`
+}
+
+// ClaimHeader defines a token claim to forward upstream as a header
+type ClaimHeader struct {
+	Claim  string `json:"claim"`
+	Header string `json:"header"`
+}
+
+// APIKeyAuth enables built-in API key authentication for an APIProxy.
+// The key is read from a header or, if configured, a query parameter.
+// If enabled, requests without a key may instead be authenticated by a
+// verified client certificate that identifies a principal of the binding.
+type APIKeyAuth struct {
+	Header     string            `json:"header,omitempty"`
+	QueryParam string            `json:"queryParam,omitempty"`
+	ClientCert bool              `json:"clientCert,omitempty"`
+	Signature  *RequestSignature `json:"signature,omitempty"`
+	ForwardKey bool              `json:"forwardKey,omitempty"`
+}
+
+// RequestSignature enables authentication of requests signed with an
+// HMAC derived from an API key in place of presenting the key itself
+type RequestSignature struct {
+	Headers []string `json:"headers,omitempty"`
+}
+
+// Concurrency defines the maximum number of in flight requests to the
+// upstream service of an APIProxy along with a bounded wait queue
+type Concurrency struct {
+	MaxInFlight  int    `json:"maxInFlight"`
+	MaxQueue     int    `json:"maxQueue,omitempty"`
+	QueueTimeout string `json:"queueTimeout,omitempty"`
+}
+
+// RateLimit defines a rate limit and quota that applies to every request
+// to an APIProxy. Traffic is tracked separately for each derived key.
+type RateLimit struct {
+	Key   RateLimitKey `json:"key"`
+	Quota int          `json:"quota,omitempty"`
+	Rate  *Rate        `json:"rate,omitempty"`
+}
+
+// RateLimitKey defines how the key that traffic is tracked
+// against is derived from an incoming request
+type RateLimitKey struct {
+	Source  string `json:"source"`
+	Name    string `json:"name,omitempty"`
+	Segment int    `json:"segment,omitempty"`
 }
 
 // Mock represents a mock configuration
@@ -64,8 +175,29 @@ type Mock struct {
 
 // Plugin defines a plugin which may be version controlled
 type Plugin struct {
-	Name    string `json:"name"`
-	Version string `json:"version,omitempty"`
+	Name     string          `json:"name"`
+	Version  string          `json:"version,omitempty"`
+	Config   json.RawMessage `json:"config,omitempty"`
+	Timeout  string          `json:"timeout,omitempty"`
+	OnError  string          `json:"onError,omitempty"`
+	External *ExternalPlugin `json:"external,omitempty"`
+}
+
+// DefaultPlugin defines a plugin that is used by every APIProxy in the
+// cluster or in a namespace. A mandatory plugin can not be excluded by an
+// APIProxy and is always used with its default definition.
+type DefaultPlugin struct {
+	Plugin
+	Mandatory bool `json:"mandatory,omitempty"`
+}
+
+// ExternalPlugin defines a plugin that runs outside of the Kanali process,
+// such as in a sidecar container, and is invoked over gRPC
+type ExternalPlugin struct {
+	Address       string `json:"address"`
+	Timeout       string `json:"timeout,omitempty"`
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+	TLS           bool   `json:"tls,omitempty"`
 }
 
 // Host represents the name and SSL object to use for SNI
@@ -113,7 +245,10 @@ func (s *ProxyFactory) Update(obj interface{}) error {
 	if !ok {
 		return errors.New("parameter was not of type APIProxy")
 	}
-	normalize(&p)
+	if err := p.Spec.IPFilter.compile(); err != nil {
+		return err
+	}
+	resolve(&p)
 	return s.update(p)
 }
 
@@ -155,9 +290,12 @@ func (s *ProxyFactory) Set(obj interface{}) error {
 	if !ok {
 		return errors.New("parameter was not of type APIProxy")
 	}
+	if err := p.Spec.IPFilter.compile(); err != nil {
+		return err
+	}
 	p.Spec.Service.Namespace = p.ObjectMeta.Namespace
 	logrus.Debugf("adding APIProxy %s", p.ObjectMeta.Name)
-	normalize(&p)
+	resolve(&p)
 	s.proxyTree.doSet(strings.Split(p.Spec.Path[1:], "/"), &p)
 	return nil
 }
@@ -217,6 +355,46 @@ func (s *ProxyFactory) get(path string) interface{} {
 	return *rootNode.Value
 }
 
+// GetAll returns every proxy in the store
+func (s *ProxyFactory) GetAll() []APIProxy {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.proxyTree.getAll(nil)
+}
+
+func (n *proxyNode) getAll(proxies []APIProxy) []APIProxy {
+	if n.Value != nil {
+		proxies = append(proxies, *n.Value)
+	}
+	for _, child := range n.Children {
+		proxies = child.getAll(proxies)
+	}
+	return proxies
+}
+
+// SetStatus replaces the status of a proxy in the store. The status is only
+// replaced if the stored proxy is the same version as the given proxy so
+// that a status observed for an older version is never applied to a newer one.
+func (s *ProxyFactory) SetStatus(p APIProxy, status APIProxyStatus) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	normalize(&p)
+	node := s.proxyTree
+	for _, part := range strings.Split(p.Spec.Path[1:], "/") {
+		if node.Children[part] == nil {
+			return false
+		}
+		node = node.Children[part]
+	}
+	if node.Value == nil || !utils.CompareObjectMeta(p.ObjectMeta, node.Value.ObjectMeta) || p.ObjectMeta.ResourceVersion != node.Value.ObjectMeta.ResourceVersion {
+		return false
+	}
+	updated := *node.Value
+	updated.Status = status
+	node.Value = &updated
+	return true
+}
+
 // Delete will remove a particular proxy from the store
 func (s *ProxyFactory) Delete(obj interface{}) (interface{}, error) {
 	s.mutex.Lock()
@@ -275,3 +453,309 @@ func normalize(p *APIProxy) {
 	(*p).Spec.Path = utils.NormalizeURLPath(p.Spec.Path)
 	(*p).Spec.Target = utils.NormalizeURLPath(p.Spec.Target)
 }
+
+// resolve normalizes an APIProxy and resolves its plugin chain
+// before it is stored
+func resolve(p *APIProxy) {
+	normalize(p)
+	(*p).plugins = append([]Plugin{}, p.resolvePlugins()...)
+}
+
+// Matches reports whether a rule applies to a request for
+// the incoming request path and HTTP method. An empty path
+// or list of verbs matches every request.
+func (r JWTRule) Matches(targetPath, method string) bool {
+	if r.Path != "" {
+		if result, err := regexp.MatchString("^"+r.Path, targetPath); err != nil || !result {
+			return false
+		}
+	}
+	if len(r.Verbs) < 1 {
+		return true
+	}
+	for _, verb := range r.Verbs {
+		if strings.EqualFold(verb, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowed reports whether a client IP address is permitted by a filter.
+// Denied addresses take precedence over allowed addresses. If any allowed
+// addresses are given, every other address is denied. A nil filter
+// permits every address. A filter that was not compiled when it was
+// stored denies every address if any of its entries is invalid.
+func (f *IPFilter) IsAllowed(ip string) bool {
+	if f == nil {
+		return true
+	}
+	allow, deny := f.allow, f.deny
+	if !f.compiled {
+		var err error
+		if allow, err = parseNetworks(f.Allow); err != nil {
+			return false
+		}
+		if deny, err = parseNetworks(f.Deny); err != nil {
+			return false
+		}
+	}
+	if utils.ContainsIP(deny, ip) {
+		return false
+	}
+	if len(f.Allow) < 1 {
+		return true
+	}
+	return utils.ContainsIP(allow, ip)
+}
+
+// compile parses and caches the networks of a filter. An error is returned
+// if any entry is invalid, as ignoring a denied entry would permit addresses
+// that were meant to be denied.
+func (f *IPFilter) compile() error {
+	if f == nil {
+		return nil
+	}
+	allow, err := parseNetworks(f.Allow)
+	if err != nil {
+		return fmt.Errorf("invalid ip filter: %s", err.Error())
+	}
+	deny, err := parseNetworks(f.Deny)
+	if err != nil {
+		return fmt.Errorf("invalid ip filter: %s", err.Error())
+	}
+	f.allow, f.deny, f.compiled = allow, deny, true
+	return nil
+}
+
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		n, err := utils.ParseNetwork(network)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// AllowsBindingNamespace reports whether APIKeyBindings in
+// a namespace may apply to an APIProxy
+func (p APIProxy) AllowsBindingNamespace(namespace string) bool {
+	if namespace == p.ObjectMeta.Namespace {
+		return true
+	}
+	for _, allowed := range p.Spec.AllowedBindingNamespaces {
+		if allowed == "*" || allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPlugins returns the plugins that an APIProxy uses, in the order they
+// are invoked. The default chain is followed by the default chain of the
+// APIProxy's namespace and then by the APIProxy's own plugins. If the
+// APIProxy lists a plugin that is also a default, it is invoked once, in
+// the position of the default, using the APIProxy's definition unless the
+// default is mandatory. Defaults named in ExcludeDefaultPlugins, or all
+// defaults if it contains "*", are not used unless they are mandatory.
+// The chain of an APIProxy retrieved from the ProxyStore is resolved once
+// when it is added or updated.
+func (p APIProxy) GetPlugins() []Plugin {
+	if p.plugins != nil {
+		return p.plugins
+	}
+	return p.resolvePlugins()
+}
+
+func (p APIProxy) resolvePlugins() []Plugin {
+	defaults, err := getDefaultPlugins(p.ObjectMeta.Namespace)
+	if err != nil {
+		logrus.Errorf("could not parse default plugins of namespace %s: %s", p.ObjectMeta.Namespace, err.Error())
+	}
+	if len(defaults) < 1 {
+		return p.Spec.Plugins
+	}
+
+	excluded := map[string]bool{}
+	for _, name := range p.Spec.ExcludeDefaultPlugins {
+		excluded[name] = true
+	}
+	mandatory := map[string]bool{}
+	for _, plugin := range defaults {
+		if plugin.Mandatory {
+			mandatory[plugin.Name] = true
+		}
+	}
+
+	plugins := make([]Plugin, 0, len(defaults)+len(p.Spec.Plugins))
+	used := map[int]bool{}
+	seen := map[string]bool{}
+	for _, d := range defaults {
+		if seen[d.Name] {
+			continue
+		}
+		if !d.Mandatory && (mandatory[d.Name] || excluded["*"] || excluded[d.Name]) {
+			continue
+		}
+		seen[d.Name] = true
+		plugin := d.Plugin
+		for i, own := range p.Spec.Plugins {
+			if own.Name == plugin.Name {
+				if !d.Mandatory {
+					plugin = own
+				}
+				used[i] = true
+				break
+			}
+		}
+		plugins = append(plugins, plugin)
+	}
+	for i, plugin := range p.Spec.Plugins {
+		if !used[i] {
+			plugins = append(plugins, plugin)
+		}
+	}
+	return plugins
+}
+
+// ValidateDefaultPlugins reports whether the default chain and the
+// default chain of every namespace can be parsed
+func ValidateDefaultPlugins() error {
+	if _, err := parseDefaultChain(viper.Get(config.FlagPluginsDefaultChain.GetLong())); err != nil {
+		return fmt.Errorf("invalid default chain: %s", err.Error())
+	}
+	for namespace, chain := range getNamespaceDefaultChains() {
+		if _, err := parseDefaultChain(chain); err != nil {
+			return fmt.Errorf("invalid default chain of namespace %s: %s", namespace, err.Error())
+		}
+	}
+	return nil
+}
+
+func getDefaultPlugins(namespace string) ([]DefaultPlugin, error) {
+	defaults, err := parseDefaultChain(viper.Get(config.FlagPluginsDefaultChain.GetLong()))
+	if err != nil {
+		return nil, err
+	}
+	if chain, ok := getNamespaceDefaultChains()[namespace]; ok {
+		plugins, err := parseDefaultChain(chain)
+		if err != nil {
+			return nil, err
+		}
+		defaults = append(defaults, plugins...)
+	}
+	return defaults, nil
+}
+
+func getNamespaceDefaultChains() map[string]interface{} {
+	value := viper.Get(config.FlagPluginsNamespaceDefaultChains.GetLong())
+	if chains, ok := value.(map[string]string); ok {
+		m := make(map[string]interface{}, len(chains))
+		for namespace, chain := range chains {
+			m[namespace] = chain
+		}
+		return m
+	}
+	return viper.GetStringMap(config.FlagPluginsNamespaceDefaultChains.GetLong())
+}
+
+// parseDefaultChain parses a default chain, which is either a comma
+// separated list of name or name:version references or a list of such
+// references and of tables with the fields of a DefaultPlugin
+func parseDefaultChain(value interface{}) ([]DefaultPlugin, error) {
+	switch v := value.(type) {
+	case nil:
+		return []DefaultPlugin{}, nil
+	case string:
+		value = strings.Split(v, ",")
+	}
+	data, err := json.Marshal(stringKeys(value))
+	if err != nil {
+		return nil, err
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.New("default chain must be a list of plugins")
+	}
+
+	plugins := []DefaultPlugin{}
+	for _, entry := range entries {
+		var ref string
+		if err := json.Unmarshal(entry, &ref); err == nil {
+			if plugin, ok := parsePluginRef(ref); ok {
+				plugins = append(plugins, DefaultPlugin{Plugin: plugin})
+			}
+			continue
+		}
+		var plugin DefaultPlugin
+		if err := json.Unmarshal(entry, &plugin); err != nil {
+			return nil, fmt.Errorf("invalid default plugin: %s", err.Error())
+		}
+		if plugin.Name == "" {
+			return nil, errors.New("default plugin name must not be empty")
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+func parsePluginRef(ref string) (Plugin, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return Plugin{}, false
+	}
+	parts := strings.SplitN(ref, ":", 2)
+	plugin := Plugin{Name: parts[0]}
+	if len(parts) > 1 {
+		plugin.Version = parts[1]
+	}
+	return plugin, true
+}
+
+// stringKeys converts the maps decoded from YAML configuration
+// files, whose keys are not strings, so they can be encoded as JSON
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = stringKeys(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = stringKeys(value)
+		}
+		return l
+	}
+	return value
+}
+
+// GetFailedPlugin returns the status of the first plugin that could not be
+// loaded or configured, or is unhealthy, or nil if every plugin is ready to be used
+func (s APIProxyStatus) GetFailedPlugin() *PluginStatus {
+	for i, plugin := range s.Plugins {
+		if !plugin.Loaded || plugin.Error != "" || plugin.Unhealthy != "" {
+			return &s.Plugins[i]
+		}
+	}
+	return nil
+}
+
+// GetReason returns why a plugin is not ready to be used
+func (s PluginStatus) GetReason() string {
+	if s.Error == "" && s.Unhealthy != "" {
+		return fmt.Sprintf("unhealthy: %s", s.Unhealthy)
+	}
+	return s.Error
+}
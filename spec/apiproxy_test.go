@@ -21,8 +21,12 @@
 package spec
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
 
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/unversioned"
@@ -46,7 +50,7 @@ func TestAPIProxyGetProxyStore(t *testing.T) {
 func TestAPIProxySet(t *testing.T) {
 	assert := assert.New(t)
 	store := ProxyStore
-	proxyList := getTestAPIProxyList()
+	proxyList := getStoredTestAPIProxyList()
 
 	store.Clear()
 	store.Set(proxyList.Proxies[0])
@@ -62,7 +66,7 @@ func TestAPIProxySet(t *testing.T) {
 func TestAPIProxyUpdate(t *testing.T) {
 	assert := assert.New(t)
 	store := ProxyStore
-	proxyList := getTestAPIProxyList()
+	proxyList := getStoredTestAPIProxyList()
 
 	store.Clear()
 	store.Update(proxyList.Proxies[0])
@@ -156,7 +160,7 @@ func TestAPIProxyIsEmpty(t *testing.T) {
 func TestAPIProxyGet(t *testing.T) {
 	assert := assert.New(t)
 	store := ProxyStore
-	proxyList := getTestAPIProxyList()
+	proxyList := getStoredTestAPIProxyList()
 
 	store.Clear()
 	store.Set(proxyList.Proxies[0])
@@ -193,7 +197,7 @@ func TestAPIProxyGet(t *testing.T) {
 func TestAPIProxyDelete(t *testing.T) {
 	assert := assert.New(t)
 	store := ProxyStore
-	proxyList := getTestAPIProxyList()
+	proxyList := getStoredTestAPIProxyList()
 	message := "proxy not deleted correctly"
 
 	store.Clear()
@@ -231,6 +235,49 @@ func TestAPIProxyDelete(t *testing.T) {
 	assert.Nil(result, message)
 }
 
+func TestAPIProxyGetAll(t *testing.T) {
+	assert := assert.New(t)
+	store := ProxyStore
+	proxyList := getStoredTestAPIProxyList()
+
+	store.Clear()
+	assert.Equal(0, len(store.GetAll()))
+	store.Set(proxyList.Proxies[0])
+	store.Set(proxyList.Proxies[1])
+	store.Set(proxyList.Proxies[2])
+	result := store.GetAll()
+	assert.Equal(3, len(result))
+	assert.Contains(result, proxyList.Proxies[0])
+	assert.Contains(result, proxyList.Proxies[1])
+	assert.Contains(result, proxyList.Proxies[2])
+}
+
+func TestAPIProxySetStatus(t *testing.T) {
+	assert := assert.New(t)
+	store := ProxyStore
+	proxyList := getTestAPIProxyList()
+	status := APIProxyStatus{Plugins: []PluginStatus{{Name: "foo", Loaded: true}}}
+
+	store.Clear()
+	assert.False(store.SetStatus(proxyList.Proxies[0], status), "missing proxy should not be updated")
+	store.Set(proxyList.Proxies[0])
+	store.Set(proxyList.Proxies[2])
+	assert.True(store.SetStatus(proxyList.Proxies[0], status))
+	result, _ := store.Get("api/v1/accounts")
+	assert.Equal(status, result.(APIProxy).Status)
+	result, _ = store.Get("api")
+	assert.Equal(APIProxyStatus{}, result.(APIProxy).Status, "other proxies should not be updated")
+
+	other := proxyList.Proxies[0]
+	other.ObjectMeta.Name = "bar"
+	assert.False(store.SetStatus(other, APIProxyStatus{}), "a different proxy at the same path should not be updated")
+	stale := proxyList.Proxies[0]
+	stale.ObjectMeta.ResourceVersion = "1"
+	assert.False(store.SetStatus(stale, APIProxyStatus{}), "a different version of the proxy should not be updated")
+	result, _ = store.Get("api/v1/accounts")
+	assert.Equal(status, result.(APIProxy).Status)
+}
+
 func TestGetFileName(t *testing.T) {
 	assert := assert.New(t)
 	store := ProxyStore
@@ -294,6 +341,155 @@ func TestNormalize(t *testing.T) {
 	assert.Equal(t, p2.Spec.Target, "/")
 }
 
+func TestIPFilterIsAllowed(t *testing.T) {
+	assert := assert.New(t)
+
+	var filter *IPFilter
+	assert.True(filter.IsAllowed("1.2.3.4"))
+	assert.True((&IPFilter{}).IsAllowed("1.2.3.4"))
+
+	filter = &IPFilter{Allow: []string{"10.0.0.0/8", "192.168.1.1"}}
+	assert.True(filter.IsAllowed("10.1.2.3"))
+	assert.True(filter.IsAllowed("192.168.1.1"))
+	assert.False(filter.IsAllowed("192.168.1.2"))
+	assert.False(filter.IsAllowed("not an ip"))
+
+	filter.Deny = []string{"10.0.0.0/16"}
+	assert.False(filter.IsAllowed("10.0.1.2"))
+	assert.True(filter.IsAllowed("10.1.1.2"))
+
+	filter = &IPFilter{Deny: []string{"1.2.3.0/24", "2001:db8::/32"}}
+	assert.False(filter.IsAllowed("1.2.3.4"))
+	assert.False(filter.IsAllowed("2001:db8::1"))
+	assert.True(filter.IsAllowed("1.2.4.4"))
+
+	filter = &IPFilter{Allow: []string{"not a network"}}
+	assert.False(filter.IsAllowed("1.2.3.4"), "an allow list without valid entries should deny every address")
+
+	filter = &IPFilter{Deny: []string{"1.2.3.0/24", "not a network"}}
+	assert.False(filter.IsAllowed("5.6.7.8"), "a deny list with invalid entries should deny every address")
+}
+
+func TestIPFilterCompile(t *testing.T) {
+	assert := assert.New(t)
+
+	var filter *IPFilter
+	assert.Nil(filter.compile())
+
+	filter = &IPFilter{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.0/16"}}
+	assert.Nil(filter.compile())
+	assert.True(filter.compiled)
+	assert.Equal(1, len(filter.allow))
+	assert.False(filter.IsAllowed("10.0.1.2"))
+	assert.True(filter.IsAllowed("10.1.1.2"))
+
+	filter.Allow = nil
+	assert.True(filter.IsAllowed("1.2.3.4"), "an empty allow list should permit every address")
+
+	filter = &IPFilter{Deny: []string{"10.0.0.0/16", "10.0.0.300"}}
+	assert.Equal("invalid ip filter: 10.0.0.300 is not an IP address or CIDR range", filter.compile().Error())
+	assert.False(filter.compiled)
+
+	ProxyStore.Clear()
+	defer ProxyStore.Clear()
+	proxy := APIProxy{
+		ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "team"},
+		Spec: APIProxySpec{
+			Path:     "/foo",
+			IPFilter: &IPFilter{Deny: []string{"1.2.3.0/33"}},
+		},
+	}
+	assert.Equal("invalid ip filter: 1.2.3.0/33 is not an IP address or CIDR range", ProxyStore.Set(proxy).Error(), "proxies with invalid filters should be rejected")
+	assert.True(ProxyStore.IsEmpty())
+	proxy.Spec.IPFilter.Deny = []string{"1.2.3.0/24"}
+	assert.Nil(ProxyStore.Set(proxy))
+	stored, _ := ProxyStore.Get("/foo")
+	assert.True(stored.(APIProxy).Spec.IPFilter.compiled)
+	assert.Equal("invalid ip filter: foo is not an IP address or CIDR range", ProxyStore.Update(APIProxy{
+		ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "team"},
+		Spec: APIProxySpec{
+			Path:     "/foo",
+			IPFilter: &IPFilter{Allow: []string{"foo"}},
+		},
+	}).Error())
+	stored, _ = ProxyStore.Get("/foo")
+	assert.Equal([]string{"1.2.3.0/24"}, stored.(APIProxy).Spec.IPFilter.Deny, "the previous proxy should be kept")
+
+	BindingStore.Clear()
+	defer BindingStore.Clear()
+	binding := APIKeyBinding{
+		ObjectMeta: api.ObjectMeta{Name: "binding", Namespace: "team"},
+		Spec: APIKeyBindingSpec{
+			APIProxyName: "proxy",
+			Keys:         []Key{{Name: "key", IPFilter: &IPFilter{Allow: []string{"bar"}}}},
+		},
+	}
+	assert.Equal("key key has an invalid ip filter: bar is not an IP address or CIDR range", BindingStore.Set(binding).Error())
+	assert.True(BindingStore.IsEmpty())
+
+	binding.Spec.Keys = nil
+	binding.Spec.Principals = []Principal{{Key: Key{Name: "principal", IPFilter: &IPFilter{Deny: []string{"baz"}}}, CommonName: "client"}}
+	assert.Equal("principal principal has an invalid ip filter: baz is not an IP address or CIDR range", BindingStore.Set(binding).Error())
+	assert.True(BindingStore.IsEmpty())
+
+	binding.Spec.Principals[0].IPFilter.Deny = []string{"1.2.3.0/24"}
+	assert.Nil(BindingStore.Set(binding))
+	assert.True(binding.Spec.Principals[0].IPFilter.compiled)
+}
+
+func TestAllowsBindingNamespace(t *testing.T) {
+	proxy := APIProxy{ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "team"}}
+	assert.True(t, proxy.AllowsBindingNamespace("team"))
+	assert.False(t, proxy.AllowsBindingNamespace("platform"))
+
+	proxy.Spec.AllowedBindingNamespaces = []string{"platform"}
+	assert.True(t, proxy.AllowsBindingNamespace("platform"))
+	assert.False(t, proxy.AllowsBindingNamespace("other"))
+
+	proxy.Spec.AllowedBindingNamespaces = []string{"*"}
+	assert.True(t, proxy.AllowsBindingNamespace("other"))
+}
+
+func TestGetFailedPlugin(t *testing.T) {
+	assert.Nil(t, APIProxyStatus{}.GetFailedPlugin())
+
+	status := APIProxyStatus{
+		Plugins: []PluginStatus{
+			{Name: "one", Loaded: true},
+			{Name: "two", Version: "1.0.0", Error: "could not open plugin two"},
+		},
+	}
+	failed := status.GetFailedPlugin()
+	assert.NotNil(t, failed)
+	assert.Equal(t, "two", failed.Name)
+	assert.Equal(t, "could not open plugin two", failed.Error)
+
+	status.Plugins[1] = PluginStatus{Name: "two", Loaded: true, Error: "invalid config: config.header is required"}
+	assert.Equal(t, "two", status.GetFailedPlugin().Name)
+}
+
+func TestJWTRuleMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(JWTRule{}.Matches("/foo", "GET"))
+	assert.True(JWTRule{Path: "/foo"}.Matches("/foo/bar", "POST"))
+	assert.False(JWTRule{Path: "/foo"}.Matches("/bar/foo", "POST"))
+	assert.True(JWTRule{Path: "/foo/[0-9]+"}.Matches("/foo/123", "GET"))
+	assert.False(JWTRule{Path: "/foo/[0-9]+"}.Matches("/foo/abc", "GET"))
+	assert.True(JWTRule{Path: "/foo", Verbs: []string{"get", "POST"}}.Matches("/foo", "GET"))
+	assert.False(JWTRule{Path: "/foo", Verbs: []string{"GET"}}.Matches("/foo", "DELETE"))
+	assert.False(JWTRule{Path: "/foo["}.Matches("/foo", "GET"))
+}
+
+// getStoredTestAPIProxyList returns the test proxies as they are stored
+func getStoredTestAPIProxyList() *APIProxyList {
+	list := getTestAPIProxyList()
+	for i := range list.Proxies {
+		resolve(&list.Proxies[i])
+	}
+	return list
+}
+
 func getTestAPIProxyList() *APIProxyList {
 
 	return &APIProxyList{
@@ -448,3 +644,200 @@ func getTestAPIProxyList() *APIProxyList {
 	}
 
 }
+
+func TestGetPlugins(t *testing.T) {
+	defer viper.Set(config.FlagPluginsDefaultChain.GetLong(), nil)
+	defer viper.Set(config.FlagPluginsNamespaceDefaultChains.GetLong(), nil)
+
+	proxy := APIProxy{
+		ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "team-a"},
+		Spec: APIProxySpec{
+			Plugins: []Plugin{{Name: "apikey"}, {Name: "tracing", Version: "2.0.0", Config: json.RawMessage(`{"sample":1}`)}},
+		},
+	}
+	assert.Equal(t, proxy.Spec.Plugins, proxy.GetPlugins(), "proxies should only use their own plugins without defaults")
+
+	viper.Set(config.FlagPluginsDefaultChain.GetLong(), []string{"audit", " tracing:1.0.0", ""})
+	viper.Set(config.FlagPluginsNamespaceDefaultChains.GetLong(), map[string]string{
+		"team-a": "billing:1.2.0,audit",
+		"team-b": "other",
+	})
+	assert.Equal(t, []Plugin{
+		{Name: "audit"},
+		{Name: "tracing", Version: "2.0.0", Config: json.RawMessage(`{"sample":1}`)},
+		{Name: "billing", Version: "1.2.0"},
+		{Name: "apikey"},
+	}, proxy.GetPlugins())
+
+	proxy.Spec.ExcludeDefaultPlugins = []string{"audit", "tracing"}
+	assert.Equal(t, []Plugin{
+		{Name: "billing", Version: "1.2.0"},
+		{Name: "apikey"},
+		{Name: "tracing", Version: "2.0.0", Config: json.RawMessage(`{"sample":1}`)},
+	}, proxy.GetPlugins(), "excluded defaults should still be used if the proxy lists them")
+
+	proxy.Spec.ExcludeDefaultPlugins = []string{"*"}
+	assert.Equal(t, proxy.Spec.Plugins, proxy.GetPlugins())
+
+	proxy.ObjectMeta.Namespace = "team-c"
+	proxy.Spec.ExcludeDefaultPlugins = nil
+	proxy.Spec.Plugins = nil
+	assert.Equal(t, []Plugin{{Name: "audit"}, {Name: "tracing", Version: "1.0.0"}}, proxy.GetPlugins())
+}
+
+func TestGetPluginsResolvedOnStore(t *testing.T) {
+	defer ProxyStore.Clear()
+	defer viper.Set(config.FlagPluginsDefaultChain.GetLong(), nil)
+
+	viper.Set(config.FlagPluginsDefaultChain.GetLong(), []string{"audit"})
+	proxy := APIProxy{
+		ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "team-a"},
+		Spec: APIProxySpec{
+			Path:    "/foo",
+			Plugins: []Plugin{{Name: "apikey"}},
+		},
+	}
+	ProxyStore.Clear()
+	assert.Nil(t, ProxyStore.Set(proxy))
+
+	viper.Set(config.FlagPluginsDefaultChain.GetLong(), []string{"tracing"})
+	result, _ := ProxyStore.Get("/foo")
+	assert.Equal(t, []Plugin{{Name: "audit"}, {Name: "apikey"}}, result.(APIProxy).GetPlugins(), "the chain should be resolved when the proxy is stored")
+
+	assert.Nil(t, ProxyStore.Update(proxy))
+	result, _ = ProxyStore.Get("/foo")
+	assert.Equal(t, []Plugin{{Name: "tracing"}, {Name: "apikey"}}, result.(APIProxy).GetPlugins())
+
+	viper.Set(config.FlagPluginsDefaultChain.GetLong(), nil)
+	proxy.Spec.Plugins = nil
+	assert.Nil(t, ProxyStore.Update(proxy))
+	result, _ = ProxyStore.Get("/foo")
+	assert.Equal(t, []Plugin{}, result.(APIProxy).GetPlugins(), "an empty chain should also be resolved")
+}
+
+func TestParseDefaultChain(t *testing.T) {
+	plugins, err := parseDefaultChain(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []DefaultPlugin{}, plugins)
+
+	plugins, err = parseDefaultChain([]string{"foo", "", " bar:1.0.0 "})
+	assert.Nil(t, err)
+	assert.Equal(t, []DefaultPlugin{{Plugin: Plugin{Name: "foo"}}, {Plugin: Plugin{Name: "bar", Version: "1.0.0"}}}, plugins)
+
+	plugins, err = parseDefaultChain("foo, bar:1.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, []DefaultPlugin{{Plugin: Plugin{Name: "foo"}}, {Plugin: Plugin{Name: "bar", Version: "1.0.0"}}}, plugins)
+
+	plugins, err = parseDefaultChain([]interface{}{
+		"foo",
+		map[string]interface{}{
+			"name":      "audit",
+			"version":   "1.0.0",
+			"config":    map[string]interface{}{"sample": 1},
+			"timeout":   "100ms",
+			"onError":   "skip",
+			"external":  map[interface{}]interface{}{"address": "localhost:9000"},
+			"mandatory": true,
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []DefaultPlugin{
+		{Plugin: Plugin{Name: "foo"}},
+		{
+			Plugin: Plugin{
+				Name:     "audit",
+				Version:  "1.0.0",
+				Config:   json.RawMessage(`{"sample":1}`),
+				Timeout:  "100ms",
+				OnError:  "skip",
+				External: &ExternalPlugin{Address: "localhost:9000"},
+			},
+			Mandatory: true,
+		},
+	}, plugins)
+
+	_, err = parseDefaultChain([]interface{}{map[string]interface{}{"version": "1.0.0"}})
+	assert.Equal(t, "default plugin name must not be empty", err.Error())
+	_, err = parseDefaultChain([]interface{}{map[string]interface{}{"name": "audit", "mandatory": "yes"}})
+	assert.NotNil(t, err)
+	_, err = parseDefaultChain(1)
+	assert.Equal(t, "default chain must be a list of plugins", err.Error())
+}
+
+func TestGetPluginsMandatory(t *testing.T) {
+	defer viper.Set(config.FlagPluginsDefaultChain.GetLong(), nil)
+	defer viper.Set(config.FlagPluginsNamespaceDefaultChains.GetLong(), nil)
+
+	viper.Set(config.FlagPluginsDefaultChain.GetLong(), []interface{}{
+		map[string]interface{}{"name": "audit", "version": "1.0.0", "config": map[string]interface{}{"level": "full"}, "mandatory": true},
+		"tracing",
+	})
+	viper.Set(config.FlagPluginsNamespaceDefaultChains.GetLong(), map[string]interface{}{
+		"team-a": []interface{}{
+			"audit:0.1.0",
+			map[string]interface{}{"name": "spend", "mandatory": true},
+		},
+	})
+	proxy := APIProxy{
+		ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "team-a"},
+		Spec: APIProxySpec{
+			Plugins:               []Plugin{{Name: "audit", Version: "2.0.0"}, {Name: "spend", OnError: "skip"}, {Name: "apikey"}},
+			ExcludeDefaultPlugins: []string{"*"},
+		},
+	}
+	assert.Equal(t, []Plugin{
+		{Name: "audit", Version: "1.0.0", Config: json.RawMessage(`{"level":"full"}`)},
+		{Name: "spend"},
+		{Name: "apikey"},
+	}, proxy.GetPlugins(), "mandatory defaults should not be excluded or overridden")
+
+	proxy.Spec.ExcludeDefaultPlugins = []string{"audit", "spend"}
+	proxy.Spec.Plugins = nil
+	assert.Equal(t, []Plugin{
+		{Name: "audit", Version: "1.0.0", Config: json.RawMessage(`{"level":"full"}`)},
+		{Name: "tracing"},
+		{Name: "spend"},
+	}, proxy.GetPlugins())
+
+	viper.Set(config.FlagPluginsDefaultChain.GetLong(), []interface{}{map[string]interface{}{"version": "1.0.0"}})
+	assert.Equal(t, "invalid default chain: default plugin name must not be empty", ValidateDefaultPlugins().Error())
+	viper.Set(config.FlagPluginsDefaultChain.GetLong(), nil)
+	assert.Nil(t, ValidateDefaultPlugins())
+	viper.Set(config.FlagPluginsNamespaceDefaultChains.GetLong(), map[string]interface{}{"team-b": 1})
+	assert.Equal(t, "invalid default chain of namespace team-b: default chain must be a list of plugins", ValidateDefaultPlugins().Error())
+}
+
+func TestGetPluginsFromConfigFile(t *testing.T) {
+	defer viper.Reset()
+	viper.SetConfigType("toml")
+	assert.Nil(t, viper.ReadConfig(bytes.NewBuffer([]byte(`
+    [[plugins.default_chain]]
+    name = "audit"
+    version = "1.0.0"
+    mandatory = true
+    [plugins.default_chain.config]
+    level = "full"
+    [plugins.default_chain.external]
+    address = "localhost:9000"
+
+    [plugins.namespace_default_chains]
+    team-a = "spend,fraud:2.1.0"
+  `))))
+	assert.Nil(t, ValidateDefaultPlugins())
+
+	proxy := APIProxy{
+		ObjectMeta: api.ObjectMeta{Name: "proxy", Namespace: "team-a"},
+		Spec:       APIProxySpec{ExcludeDefaultPlugins: []string{"*"}},
+	}
+	assert.Equal(t, []Plugin{{
+		Name:     "audit",
+		Version:  "1.0.0",
+		Config:   json.RawMessage(`{"level":"full"}`),
+		External: &ExternalPlugin{Address: "localhost:9000"},
+	}}, proxy.GetPlugins())
+
+	proxy.Spec.ExcludeDefaultPlugins = nil
+	plugins := proxy.GetPlugins()
+	assert.Equal(t, 3, len(plugins))
+	assert.Equal(t, Plugin{Name: "fraud", Version: "2.1.0"}, plugins[2])
+}
@@ -20,11 +20,197 @@
 
 package spec
 
-import "k8s.io/kubernetes/pkg/api"
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
 
-// KanaliEndpoints represents the endpoints of all running instances of Kanali
-var KanaliEndpoints *api.Endpoints
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// EndpointsFactory is factory that implements a concurrency safe store for
+// the Kubernetes endpoints that back running instances of Kanali
+type EndpointsFactory struct {
+	mutex        sync.RWMutex
+	endpointsMap map[string]map[string]api.Endpoints
+}
+
+// KanaliEndpoints holds all Kubernetes endpoints that represent running
+// instances of Kanali. It should not be mutated directly!
+var KanaliEndpoints *EndpointsFactory
 
 func init() {
-	KanaliEndpoints = &api.Endpoints{}
+	KanaliEndpoints = &EndpointsFactory{sync.RWMutex{}, map[string]map[string]api.Endpoints{}}
+}
+
+// Clear will remove all endpoints from the store
+func (s *EndpointsFactory) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for k := range s.endpointsMap {
+		delete(s.endpointsMap, k)
+	}
+}
+
+// Update will update an endpoints object
+func (s *EndpointsFactory) Update(obj interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	endpoints, ok := obj.(api.Endpoints)
+	if !ok {
+		return errors.New("grrr - you're only allowed add endpoints to the endpoints store.... duh")
+	}
+	return s.set(endpoints)
+}
+
+// Set takes an endpoints object and either adds it to the store
+// or updates it. Endpoints that do not represent Kanali are ignored.
+func (s *EndpointsFactory) Set(obj interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	endpoints, ok := obj.(api.Endpoints)
+	if !ok {
+		return errors.New("grrr - you're only allowed add endpoints to the endpoints store.... duh")
+	}
+	return s.set(endpoints)
+}
+
+func (s *EndpointsFactory) set(endpoints api.Endpoints) error {
+	isKanali, err := IsKanaliEndpoints(endpoints)
+	if err != nil {
+		return err
+	}
+	if !isKanali {
+		// an update may have moved these endpoints out of our selector
+		s.delete(endpoints)
+		return nil
+	}
+	logrus.Debugf("Adding new Endpoints named %s in namespace %s", endpoints.ObjectMeta.Name, endpoints.ObjectMeta.Namespace)
+	if _, ok := s.endpointsMap[endpoints.ObjectMeta.Namespace]; !ok {
+		s.endpointsMap[endpoints.ObjectMeta.Namespace] = map[string]api.Endpoints{}
+	}
+	s.endpointsMap[endpoints.ObjectMeta.Namespace][endpoints.ObjectMeta.Name] = endpoints
+	return nil
+}
+
+// Get retrieves a particual endpoints object in the store. If not found, nil is returned.
+func (s *EndpointsFactory) Get(params ...interface{}) (interface{}, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(params) != 2 {
+		return nil, errors.New("should should take 2 params, name and namespace")
+	}
+	name, ok := params[0].(string)
+	if !ok {
+		return nil, errors.New("endpoints name must be of type string")
+	}
+	namespace, ok := params[1].(string)
+	if !ok {
+		return nil, errors.New("endpoints namespace must be of type string")
+	}
+	endpoints, ok := s.endpointsMap[namespace][name]
+	if !ok {
+		return nil, nil
+	}
+	return endpoints, nil
+}
+
+// Delete will remove a particular endpoints object from the store
+func (s *EndpointsFactory) Delete(obj interface{}) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if obj == nil {
+		return nil, nil
+	}
+	endpoints, ok := obj.(api.Endpoints)
+	if !ok {
+		return nil, errors.New("there's no way this endpoints object could've gotten in here")
+	}
+	return s.delete(endpoints), nil
+}
+
+func (s *EndpointsFactory) delete(endpoints api.Endpoints) interface{} {
+	old, ok := s.endpointsMap[endpoints.ObjectMeta.Namespace][endpoints.ObjectMeta.Name]
+	if !ok {
+		return nil
+	}
+	delete(s.endpointsMap[endpoints.ObjectMeta.Namespace], endpoints.ObjectMeta.Name)
+	if len(s.endpointsMap[endpoints.ObjectMeta.Namespace]) == 0 {
+		delete(s.endpointsMap, endpoints.ObjectMeta.Namespace)
+	}
+	return old
+}
+
+// IsEmpty reports whether the endpoints store is empty
+func (s *EndpointsFactory) IsEmpty() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.endpointsMap) == 0
+}
+
+// Addresses returns the deduplicated host:port address of every ready
+// Kanali instance across all subsets of all stored endpoints. If a subset
+// exposes a UDP port, that port is used. Otherwise the configured peer
+// UDP port is assumed.
+func (s *EndpointsFactory) Addresses() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	seen := map[string]bool{}
+	addrs := []string{}
+
+	for _, byName := range s.endpointsMap {
+		for _, endpoints := range byName {
+			for _, subset := range endpoints.Subsets {
+				port := getPeerPort(subset.Ports)
+				for _, addr := range subset.Addresses {
+					hostPort := net.JoinHostPort(addr.IP, strconv.Itoa(port))
+					if seen[hostPort] {
+						continue
+					}
+					seen[hostPort] = true
+					addrs = append(addrs, hostPort)
+				}
+			}
+		}
+	}
+
+	sort.Strings(addrs)
+	return addrs
+}
+
+// IsKanaliEndpoints reports whether an endpoints object represents running
+// instances of Kanali. If a label selector is configured, it takes precedence
+// over the configured endpoints name.
+func IsKanaliEndpoints(endpoints api.Endpoints) (bool, error) {
+	if namespace := viper.GetString(config.FlagServerPeerEndpointsNamespace.GetLong()); namespace != "" && namespace != endpoints.ObjectMeta.Namespace {
+		return false, nil
+	}
+	if selector := viper.GetString(config.FlagServerPeerEndpointsSelector.GetLong()); selector != "" {
+		s, err := labels.Parse(selector)
+		if err != nil {
+			return false, err
+		}
+		return s.Matches(labels.Set(endpoints.ObjectMeta.Labels)), nil
+	}
+	return endpoints.ObjectMeta.Name == viper.GetString(config.FlagServerPeerEndpointsName.GetLong()), nil
+}
+
+func getPeerPort(ports []api.EndpointPort) int {
+	name := viper.GetString(config.FlagServerPeerEndpointsPortName.GetLong())
+	for _, port := range ports {
+		if port.Protocol != api.ProtocolUDP {
+			continue
+		}
+		if name == "" || port.Name == name {
+			return int(port.Port)
+		}
+	}
+	return viper.GetInt(config.FlagServerPeerUDPPort.GetLong())
 }
@@ -0,0 +1,172 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package spec
+
+import (
+	"testing"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestEndpointsGetEndpointsStore(t *testing.T) {
+	assert := assert.New(t)
+	store := KanaliEndpoints
+
+	store.Clear()
+	assert.Equal(0, len(store.endpointsMap), "store should be empty")
+
+	v := EndpointsFactory{}
+	var i interface{} = &v
+	_, ok := i.(Store)
+	assert.True(ok, "EndpointsFactory does not implement the Store interface")
+}
+
+func TestEndpointsSet(t *testing.T) {
+	assert := assert.New(t)
+	store := KanaliEndpoints
+	endpointsList := getTestEndpointsList()
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+	defer viper.Reset()
+
+	store.Clear()
+	store.Set(endpointsList[0])
+	store.Set(endpointsList[1])
+	err := store.Set(APIProxy{})
+	assert.Equal(err.Error(), "grrr - you're only allowed add endpoints to the endpoints store.... duh", "error not what expected")
+	assert.Equal(1, len(store.endpointsMap), "there should be one namespace in the endpoints store")
+	assert.Equal(endpointsList[0], store.endpointsMap["foo"]["kanali"], "endpoints should exist")
+	_, ok := store.endpointsMap["foo"]["other"]
+	assert.False(ok, "non kanali endpoints should be ignored")
+
+	viper.Set(config.FlagServerPeerEndpointsSelector.GetLong(), "app=other")
+	store.Update(endpointsList[0])
+	store.Update(endpointsList[1])
+	assert.Equal(endpointsList[1], store.endpointsMap["foo"]["other"], "endpoints should exist")
+	_, ok = store.endpointsMap["foo"]["kanali"]
+	assert.False(ok, "endpoints no longer matching the selector should be removed")
+
+	viper.Set(config.FlagServerPeerEndpointsSelector.GetLong(), "app in (")
+	assert.NotNil(store.Set(endpointsList[0]), "invalid selector should return an error")
+}
+
+func TestEndpointsGet(t *testing.T) {
+	assert := assert.New(t)
+	store := KanaliEndpoints
+	endpointsList := getTestEndpointsList()
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+	defer viper.Reset()
+
+	store.Clear()
+	store.Set(endpointsList[0])
+	result, _ := store.Get("kanali", "foo")
+	assert.Equal(endpointsList[0], result, "endpoints should exist")
+	result, _ = store.Get("kanali", "bar")
+	assert.Nil(result, "endpoints should not exist")
+	_, err := store.Get("kanali")
+	assert.Equal(err.Error(), "should should take 2 params, name and namespace", "error not what expected")
+	_, err = store.Get(5, "")
+	assert.Equal(err.Error(), "endpoints name must be of type string", "error not what expected")
+	_, err = store.Get("", 5)
+	assert.Equal(err.Error(), "endpoints namespace must be of type string", "error not what expected")
+}
+
+func TestEndpointsDelete(t *testing.T) {
+	assert := assert.New(t)
+	store := KanaliEndpoints
+	endpointsList := getTestEndpointsList()
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+	defer viper.Reset()
+
+	store.Clear()
+	store.Set(endpointsList[0])
+	assert.False(store.IsEmpty())
+	result, _ := store.Delete(endpointsList[1])
+	assert.Nil(result, "endpoints should not exist")
+	result, _ = store.Delete(endpointsList[0])
+	assert.Equal(endpointsList[0], result, "endpoints should have been deleted")
+	assert.True(store.IsEmpty())
+	result, _ = store.Delete(nil)
+	assert.Nil(result)
+	_, err := store.Delete(APIProxy{})
+	assert.Equal(err.Error(), "there's no way this endpoints object could've gotten in here", "error not what expected")
+}
+
+func TestEndpointsAddresses(t *testing.T) {
+	assert := assert.New(t)
+	store := KanaliEndpoints
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+	viper.SetDefault(config.FlagServerPeerUDPPort.GetLong(), 10001)
+	defer viper.Reset()
+
+	store.Clear()
+	assert.Equal([]string{}, store.Addresses(), "endpoints without subsets should have no addresses")
+
+	store.Set(api.Endpoints{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "kanali",
+			Namespace: "foo",
+		},
+		Subsets: []api.EndpointSubset{
+			{
+				Addresses: []api.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+				Ports:     []api.EndpointPort{{Name: "https", Port: 443, Protocol: api.ProtocolTCP}},
+			},
+			{
+				Addresses: []api.EndpointAddress{{IP: "10.0.0.3"}, {IP: "10.0.0.1"}},
+				Ports: []api.EndpointPort{
+					{Name: "https", Port: 443, Protocol: api.ProtocolTCP},
+					{Name: "peer", Port: 20001, Protocol: api.ProtocolUDP},
+				},
+			},
+			{
+				Addresses: []api.EndpointAddress{{IP: "10.0.0.2"}},
+			},
+		},
+	})
+
+	assert.Equal([]string{"10.0.0.1:10001", "10.0.0.1:20001", "10.0.0.2:10001", "10.0.0.3:20001"}, store.Addresses())
+}
+
+func getTestEndpointsList() []api.Endpoints {
+	return []api.Endpoints{
+		{
+			ObjectMeta: api.ObjectMeta{
+				Name:      "kanali",
+				Namespace: "foo",
+				Labels: map[string]string{
+					"app": "kanali",
+				},
+			},
+		},
+		{
+			ObjectMeta: api.ObjectMeta{
+				Name:      "other",
+				Namespace: "foo",
+				Labels: map[string]string{
+					"app": "other",
+				},
+			},
+		},
+	}
+}
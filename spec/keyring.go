@@ -0,0 +1,282 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package spec
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// apiKeyEncryptionLabel is the label that API keys are encrypted with
+var apiKeyEncryptionLabel = []byte("kanali")
+
+// DecryptionKey is a private key that APIKeys can be decrypted with.
+// The key is either an *rsa.PrivateKey or an *ecdsa.PrivateKey.
+type DecryptionKey struct {
+	ID  string
+	Key crypto.PrivateKey
+}
+
+// KeyringFactory is factory that implements a concurrency safe keyring of decryption keys
+type KeyringFactory struct {
+	mutex sync.RWMutex
+	keys  []DecryptionKey
+}
+
+// APIKeyDecryptionKeys holds every private key that Kanali will use to
+// decrypt the data in an APIKey spec. It should not be mutated directly!
+var APIKeyDecryptionKeys *KeyringFactory
+
+func init() {
+	APIKeyDecryptionKeys = &KeyringFactory{sync.RWMutex{}, []DecryptionKey{}}
+}
+
+// Set replaces every key in the keyring
+func (k *KeyringFactory) Set(keys ...DecryptionKey) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keys = append([]DecryptionKey{}, keys...)
+}
+
+// Clear will remove all keys from the keyring
+func (k *KeyringFactory) Clear() {
+	k.Set()
+}
+
+// IsEmpty reports whether the keyring is empty
+func (k *KeyringFactory) IsEmpty() bool {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return len(k.keys) == 0
+}
+
+// IDs returns the ID of every key in the keyring
+func (k *KeyringFactory) IDs() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	ids := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		ids[i] = key.ID
+	}
+	return ids
+}
+
+// Decrypt decrypts cipher text using the key with the given ID. If no key
+// ID is given, or the key with that ID fails, every other key is tried.
+func (k *KeyringFactory) Decrypt(keyID string, cipherText []byte) ([]byte, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if len(k.keys) < 1 {
+		return nil, errors.New("no decryption keys loaded")
+	}
+
+	candidates := make([]DecryptionKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		if keyID != "" && key.ID == keyID {
+			candidates = append([]DecryptionKey{key}, candidates...)
+		} else {
+			candidates = append(candidates, key)
+		}
+	}
+
+	for _, key := range candidates {
+		if data, err := decryptWithKey(key.Key, cipherText); err == nil {
+			return data, nil
+		}
+	}
+
+	return nil, errors.New("no decryption key could decrypt the data")
+}
+
+func decryptWithKey(key crypto.PrivateKey, cipherText []byte) ([]byte, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, key, cipherText, apiKeyEncryptionLabel)
+	case *ecdsa.PrivateKey:
+		return decryptECIES(key, cipherText)
+	default:
+		return nil, fmt.Errorf("unsupported decryption key type %T", key)
+	}
+}
+
+// EncryptAPIKey encrypts API key data with an RSA or EC public key so
+// that it can be decrypted by the matching key in the keyring
+func EncryptAPIKey(key crypto.PublicKey, data []byte) ([]byte, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, key, data, apiKeyEncryptionLabel)
+	case *ecdsa.PublicKey:
+		return encryptECIES(key, data)
+	default:
+		return nil, fmt.Errorf("unsupported encryption key type %T", key)
+	}
+}
+
+// encryptECIES encrypts data using an ephemeral ECDH key exchange. The cipher
+// text is the ephemeral public key followed by the AES-256-GCM nonce and the
+// sealed data. The AES key is the SHA-256 hash of the shared secret.
+func encryptECIES(pub *ecdsa.PublicKey, data []byte) ([]byte, error) {
+	ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := getECIESCipher(pub.Curve, pub.X.Bytes(), pub.Y.Bytes(), ephemeral.D.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	cipherText := elliptic.Marshal(pub.Curve, ephemeral.X, ephemeral.Y)
+	cipherText = append(cipherText, nonce...)
+	return aead.Seal(cipherText, nonce, data, apiKeyEncryptionLabel), nil
+}
+
+func decryptECIES(priv *ecdsa.PrivateKey, cipherText []byte) ([]byte, error) {
+	pointSize := 1 + 2*((priv.Curve.Params().BitSize+7)/8)
+	if len(cipherText) < pointSize {
+		return nil, errors.New("cipher text too short")
+	}
+	x, y := elliptic.Unmarshal(priv.Curve, cipherText[:pointSize])
+	if x == nil {
+		return nil, errors.New("invalid ephemeral public key")
+	}
+	aead, err := getECIESCipher(priv.Curve, x.Bytes(), y.Bytes(), priv.D.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	rest := cipherText[pointSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("cipher text too short")
+	}
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], apiKeyEncryptionLabel)
+}
+
+func getECIESCipher(curve elliptic.Curve, x, y, d []byte) (cipher.AEAD, error) {
+	sharedX, _ := curve.ScalarMult(new(big.Int).SetBytes(x), new(big.Int).SetBytes(y), d)
+	secret := make([]byte, (curve.Params().BitSize+7)/8)
+	sharedBytes := sharedX.Bytes()
+	copy(secret[len(secret)-len(sharedBytes):], sharedBytes)
+	sum := sha256.Sum256(secret)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ParseDecryptionKey parses a PEM encoded PKCS#1 RSA, SEC 1 EC
+// or PKCS#8 RSA or EC private key into a decryption key
+func ParseDecryptionKey(id string, data []byte) (DecryptionKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return DecryptionKey{}, errors.New("no PEM data found")
+	}
+	var key crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return DecryptionKey{}, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	if err != nil {
+		return DecryptionKey{}, err
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return DecryptionKey{ID: id, Key: key}, nil
+	default:
+		return DecryptionKey{}, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// LoadDecryptionKeyFile loads a single decryption key from a file.
+// The key ID is the name of the file without its extension.
+func LoadDecryptionKeyFile(path string) (DecryptionKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return DecryptionKey{}, err
+	}
+	base := filepath.Base(path)
+	key, err := ParseDecryptionKey(strings.TrimSuffix(base, filepath.Ext(base)), data)
+	if err != nil {
+		return DecryptionKey{}, fmt.Errorf("could not parse %s: %s", path, err.Error())
+	}
+	return key, nil
+}
+
+// LoadDecryptionKeyDir loads a decryption key from every file in a directory.
+// Hidden files, such as those Kubernetes creates when mounting a Secret, are
+// skipped.
+func LoadDecryptionKeyDir(dir string) ([]DecryptionKey, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, file.Name()))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		names = append(names, file.Name())
+	}
+	sort.Strings(names)
+	keys := make([]DecryptionKey, 0, len(names))
+	for _, name := range names {
+		key, err := LoadDecryptionKeyFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) < 1 {
+		return nil, fmt.Errorf("no decryption keys found in %s", dir)
+	}
+	return keys, nil
+}
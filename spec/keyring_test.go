@@ -0,0 +1,125 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package spec
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyringDecrypt(t *testing.T) {
+	assert := assert.New(t)
+	defer APIKeyDecryptionKeys.Clear()
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	APIKeyDecryptionKeys.Clear()
+	_, err := APIKeyDecryptionKeys.Decrypt("", []byte("foo"))
+	assert.Equal("no decryption keys loaded", err.Error())
+
+	APIKeyDecryptionKeys.Set(DecryptionKey{ID: "old", Key: rsaKey}, DecryptionKey{ID: "new", Key: ecKey})
+	assert.Equal([]string{"old", "new"}, APIKeyDecryptionKeys.IDs())
+
+	for _, pub := range []interface{}{&rsaKey.PublicKey, &ecKey.PublicKey} {
+		cipherText, err := EncryptAPIKey(pub, []byte("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9"))
+		assert.Nil(err)
+		for _, id := range []string{"", "old", "new", "missing"} {
+			data, err := APIKeyDecryptionKeys.Decrypt(id, cipherText)
+			assert.Nil(err, "trial decryption should fall back to every key")
+			assert.Equal("i3CZlcRnDhJZeZfkDw9BgeEtZuFQKiw9", string(data))
+		}
+	}
+
+	_, err = APIKeyDecryptionKeys.Decrypt("new", []byte("foo"))
+	assert.Equal("no decryption key could decrypt the data", err.Error())
+
+	_, err = EncryptAPIKey("foo", []byte("bar"))
+	assert.NotNil(err)
+}
+
+func TestLoadDecryptionKeyDir(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "kanali")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	_, err = LoadDecryptionKeyDir(dir)
+	assert.NotNil(err, "an empty directory should be an error")
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecBytes, _ := x509.MarshalECPrivateKey(ecKey)
+	rsaPKCS8Bytes, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	ecPKCS8Bytes, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+
+	writePEM := func(name, blockType string, data []byte) {
+		assert.Nil(ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600))
+	}
+
+	writePEM("a.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	writePEM("b.key", "EC PRIVATE KEY", ecBytes)
+	writePEM("c", "PRIVATE KEY", rsaPKCS8Bytes)
+	writePEM("d.pem", "PRIVATE KEY", ecPKCS8Bytes)
+	writePEM(".hidden.pem", "FOO", []byte("bar"))
+	assert.Nil(os.Mkdir(filepath.Join(dir, "..data"), 0700))
+
+	keys, err := LoadDecryptionKeyDir(dir)
+	assert.Nil(err)
+	assert.Equal(4, len(keys))
+	assert.Equal("a", keys[0].ID)
+	assert.Equal(rsaKey, keys[0].Key)
+	assert.Equal("b", keys[1].ID)
+	assert.Equal(ecKey, keys[1].Key)
+	assert.Equal("c", keys[2].ID)
+	assert.Equal(rsaKey, keys[2].Key)
+	assert.Equal("d", keys[3].ID)
+
+	writePEM("e.pem", "CERTIFICATE", []byte("foo"))
+	_, err = LoadDecryptionKeyDir(dir)
+	assert.NotNil(err, "invalid keys should not be silently skipped")
+}
+
+func TestParseDecryptionKey(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ParseDecryptionKey("foo", []byte("foo"))
+	assert.Equal("no PEM data found", err.Error())
+
+	_, err = ParseDecryptionKey("foo", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("foo")}))
+	assert.NotNil(err)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := ParseDecryptionKey("foo", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	assert.Nil(err)
+	assert.Equal(DecryptionKey{ID: "foo", Key: rsaKey}, key)
+}
@@ -23,19 +23,36 @@ package spec
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// bucketSeparator separates the parts of the name of a traffic bucket that is
+// tracked alongside ApiKey traffic. Kubernetes names can not contain an
+// underscore so such a bucket name can never collide with an ApiKey name.
+const bucketSeparator = "_"
+
+// BucketName joins parts into the name of a traffic bucket
+// that is tracked alongside ApiKey traffic
+func BucketName(parts ...string) string {
+	return strings.Join(parts, bucketSeparator)
+}
+
 type trafficByAPIKey map[string][]time.Time
 type trafficByAPIProxy map[string]trafficByAPIKey
 type trafficByNamespace map[string]trafficByAPIProxy
 
+// prunedCounts holds the number of traffic points that have been pruned
+// indexed by namespace, proxy name and key name
+type prunedCounts map[string]map[string]map[string]int
+
 // TrafficFactory is factory that implements a concurrency safe store for Kanali traffic
 type TrafficFactory struct {
 	mutex      sync.RWMutex
 	trafficMap trafficByNamespace
+	pruned     prunedCounts
 }
 
 // TrafficStore holds all API traffic that Kanali has discovered
@@ -43,7 +60,7 @@ type TrafficFactory struct {
 var TrafficStore *TrafficFactory
 
 func init() {
-	TrafficStore = &TrafficFactory{sync.RWMutex{}, make(trafficByNamespace)}
+	TrafficStore = &TrafficFactory{sync.RWMutex{}, make(trafficByNamespace), make(prunedCounts)}
 }
 
 // Clear will remove all entries from the traffic store
@@ -53,6 +70,9 @@ func (s *TrafficFactory) Clear() {
 	for k := range s.trafficMap {
 		delete(s.trafficMap, k)
 	}
+	for k := range s.pruned {
+		delete(s.pruned, k)
+	}
 }
 
 // Set takes a traffic point and either adds it to the store
@@ -80,7 +100,7 @@ func (s *TrafficFactory) doSet(obj interface{}, currTime time.Time) error {
 	if _, ok := s.trafficMap[nSpace][pName][keyName]; !ok {
 		s.trafficMap[nSpace][pName][keyName] = make([]time.Time, 0)
 	}
-	s.trafficMap[nSpace][pName][keyName] = append(s.trafficMap[nSpace][pName][keyName], time.Now())
+	s.trafficMap[nSpace][pName][keyName] = append(s.trafficMap[nSpace][pName][keyName], currTime)
 	return nil
 }
 
@@ -99,14 +119,7 @@ func (s *TrafficFactory) IsQuotaViolated(binding APIKeyBinding, keyName string)
 		if key.Name != keyName {
 			continue
 		}
-		if key.Quota == 0 {
-			return false
-		}
-		result, err := s.contains(binding, keyName)
-		if err != nil || !result {
-			return false
-		}
-		return len(s.trafficMap[binding.ObjectMeta.Namespace][binding.Spec.APIProxyName][keyName]) >= key.Quota
+		return s.isQuotaExceeded(binding.ObjectMeta.Namespace, binding.GetTrafficProxyName(), keyName, key.Quota)
 	}
 	return true
 }
@@ -119,19 +132,143 @@ func (s *TrafficFactory) IsRateLimitViolated(binding APIKeyBinding, keyName stri
 		if key.Name != keyName {
 			continue
 		}
-		if key.Rate == nil {
-			return false
+		return s.isRateExceeded(binding.ObjectMeta.Namespace, binding.GetTrafficProxyName(), keyName, key.Rate, currTime)
+	}
+	return true
+}
+
+// IsLimitViolated will see whether any quota or rate limit that applies to a
+// request for the target path and HTTP method has been reached. This includes
+// the key wide limit along with any subpath and HTTP method limits. The key
+// may be either an apikey or a principal of the binding.
+func (s *TrafficFactory) IsLimitViolated(binding APIKeyBinding, keyName, targetPath, method string, currTime time.Time) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	key := binding.getKey(keyName)
+	if key == nil {
+		return true
+	}
+	for _, limit := range key.GetLimits(targetPath, method) {
+		if s.isQuotaExceeded(binding.ObjectMeta.Namespace, binding.GetTrafficProxyName(), limit.Bucket, limit.Quota) {
+			return true
+		}
+		if s.isRateExceeded(binding.ObjectMeta.Namespace, binding.GetTrafficProxyName(), limit.Bucket, limit.Rate, currTime) {
+			return true
 		}
-		if key.Rate.Amount == 0 {
-			return false
+	}
+	return false
+}
+
+// IsQuotaExceeded reports whether the traffic for a namespace, proxy and key
+// combination has reached a quota. A quota of zero is never exceeded.
+func (s *TrafficFactory) IsQuotaExceeded(namespace, proxyName, keyName string, quota int) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.isQuotaExceeded(namespace, proxyName, keyName, quota)
+}
+
+func (s *TrafficFactory) isQuotaExceeded(namespace, proxyName, keyName string, quota int) bool {
+	if quota == 0 {
+		return false
+	}
+	return len(s.trafficMap[namespace][proxyName][keyName])+s.pruned[namespace][proxyName][keyName] >= quota
+}
+
+// IsRateExceeded reports whether the traffic for a namespace, proxy and key
+// combination has reached a rate limit. A nil or zero rate is never exceeded.
+func (s *TrafficFactory) IsRateExceeded(namespace, proxyName, keyName string, rate *Rate, currTime time.Time) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.isRateExceeded(namespace, proxyName, keyName, rate, currTime)
+}
+
+func (s *TrafficFactory) isRateExceeded(namespace, proxyName, keyName string, rate *Rate, currTime time.Time) bool {
+	if rate == nil || rate.Amount == 0 {
+		return false
+	}
+	points := s.trafficMap[namespace][proxyName][keyName]
+	return getTrafficVolume(points, rate.Unit, currTime, 0, len(points)) >= rate.Amount
+}
+
+// Count returns the number of traffic points for a namespace, proxy and key
+// combination that were recorded after since.
+func (s *TrafficFactory) Count(namespace, proxyName, keyName string, since time.Time) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	points := s.trafficMap[namespace][proxyName][keyName]
+	return len(points) - sort.Search(len(points), func(i int) bool {
+		return points[i].After(since)
+	})
+}
+
+// Prune removes traffic points recorded before the given time. Quotas
+// count every request ever made so the number of pruned points is kept.
+func (s *TrafficFactory) Prune(before time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for nSpace, proxies := range s.trafficMap {
+		for pName, keys := range proxies {
+			for keyName, points := range keys {
+				i := sort.Search(len(points), func(i int) bool {
+					return !points[i].Before(before)
+				})
+				if i < 1 {
+					continue
+				}
+				s.addPruned(nSpace, pName, keyName, i)
+				if i == len(points) {
+					delete(keys, keyName)
+					continue
+				}
+				keys[keyName] = append([]time.Time(nil), points[i:]...)
+			}
+			if len(keys) < 1 {
+				delete(proxies, pName)
+			}
 		}
-		result, err := s.contains(binding, keyName)
-		if err != nil || !result {
-			return false
+		if len(proxies) < 1 {
+			delete(s.trafficMap, nSpace)
 		}
-		return getTrafficVolume(s.trafficMap[binding.ObjectMeta.Namespace][binding.Spec.APIProxyName][keyName], key.Rate.Unit, currTime, 0, len(s.trafficMap[binding.ObjectMeta.Namespace][binding.Spec.APIProxyName][keyName])) >= key.Rate.Amount
 	}
-	return true
+}
+
+// Trim removes the traffic points of a namespace that were recorded before
+// the time returned for their proxy and key name. Unlike Prune, the number
+// of removed points is not kept, so it must only be used for traffic that
+// is counted within a trailing window.
+func (s *TrafficFactory) Trim(namespace string, before func(proxyName, keyName string) time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	proxies := s.trafficMap[namespace]
+	for pName, keys := range proxies {
+		for keyName, points := range keys {
+			t := before(pName, keyName)
+			i := sort.Search(len(points), func(i int) bool {
+				return !points[i].Before(t)
+			})
+			if i == len(points) {
+				delete(keys, keyName)
+			} else if i > 0 {
+				keys[keyName] = append([]time.Time(nil), points[i:]...)
+			}
+		}
+		if len(keys) < 1 {
+			delete(proxies, pName)
+		}
+	}
+	if len(proxies) < 1 {
+		delete(s.trafficMap, namespace)
+	}
+}
+
+func (s *TrafficFactory) addPruned(namespace, proxyName, keyName string, count int) {
+	if _, ok := s.pruned[namespace]; !ok {
+		s.pruned[namespace] = make(map[string]map[string]int)
+	}
+	if _, ok := s.pruned[namespace][proxyName]; !ok {
+		s.pruned[namespace][proxyName] = make(map[string]int)
+	}
+	s.pruned[namespace][proxyName][keyName] += count
 }
 
 // Contains reports whether the traffic store has any traffic for a given proxy/name combination
@@ -152,10 +289,10 @@ func (s *TrafficFactory) contains(params ...interface{}) (bool, error) {
 	if _, ok := s.trafficMap[binding.ObjectMeta.Namespace]; !ok {
 		return false, nil
 	}
-	if _, ok := s.trafficMap[binding.ObjectMeta.Namespace][binding.Spec.APIProxyName]; !ok {
+	if _, ok := s.trafficMap[binding.ObjectMeta.Namespace][binding.GetTrafficProxyName()]; !ok {
 		return false, nil
 	}
-	if _, ok := s.trafficMap[binding.ObjectMeta.Namespace][binding.Spec.APIProxyName][keyName]; !ok {
+	if _, ok := s.trafficMap[binding.ObjectMeta.Namespace][binding.GetTrafficProxyName()][keyName]; !ok {
 		return false, nil
 	}
 	return true, nil
@@ -177,6 +314,145 @@ func (s *TrafficFactory) Get(params ...interface{}) (interface{}, error) {
 	return nil, nil
 }
 
+// TrafficSnapshot is a point in time summary of the traffic store. Traffic
+// points are counted per second, indexed by namespace, proxy name, key name
+// and Unix time, so that the size of a snapshot is bounded by the traffic
+// retention period rather than by the volume of traffic. The number of pruned
+// traffic points is indexed by namespace, proxy name and key name.
+type TrafficSnapshot struct {
+	Counts map[string]map[string]map[string]map[int64]int `json:"counts"`
+	Pruned map[string]map[string]map[string]int           `json:"pruned,omitempty"`
+}
+
+// NewTrafficSnapshot returns an empty traffic snapshot
+func NewTrafficSnapshot() TrafficSnapshot {
+	return TrafficSnapshot{
+		Counts: make(map[string]map[string]map[string]map[int64]int),
+		Pruned: make(map[string]map[string]map[string]int),
+	}
+}
+
+// Snapshot returns a summary of every traffic point in the store
+func (s *TrafficFactory) Snapshot() TrafficSnapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	snapshot := NewTrafficSnapshot()
+	for nSpace, proxies := range s.trafficMap {
+		for pName, keys := range proxies {
+			for keyName, points := range keys {
+				for _, point := range points {
+					snapshot.addCount(nSpace, pName, keyName, point.Unix(), 1)
+				}
+			}
+		}
+	}
+	for nSpace, proxies := range s.pruned {
+		for pName, keys := range proxies {
+			for keyName, count := range keys {
+				snapshot.addPruned(nSpace, pName, keyName, count)
+			}
+		}
+	}
+	return snapshot
+}
+
+// Merge adds the traffic of another snapshot to this snapshot. Every Kanali
+// instance receives the traffic of its peers, so where both snapshots hold a
+// count for the same second or pruned traffic for the same key, the larger
+// count is kept rather than their sum. This snapshot must have been
+// returned by NewTrafficSnapshot or Snapshot.
+func (s TrafficSnapshot) Merge(other TrafficSnapshot) {
+	for nSpace, proxies := range other.Counts {
+		for pName, keys := range proxies {
+			for keyName, counts := range keys {
+				for sec, count := range counts {
+					if count > s.Counts[nSpace][pName][keyName][sec] {
+						s.addCount(nSpace, pName, keyName, sec, count-s.Counts[nSpace][pName][keyName][sec])
+					}
+				}
+			}
+		}
+	}
+	for nSpace, proxies := range other.Pruned {
+		for pName, keys := range proxies {
+			for keyName, count := range keys {
+				if count > s.Pruned[nSpace][pName][keyName] {
+					s.addPruned(nSpace, pName, keyName, count-s.Pruned[nSpace][pName][keyName])
+				}
+			}
+		}
+	}
+}
+
+func (s TrafficSnapshot) addCount(namespace, proxyName, keyName string, sec int64, count int) {
+	if _, ok := s.Counts[namespace]; !ok {
+		s.Counts[namespace] = make(map[string]map[string]map[int64]int)
+	}
+	if _, ok := s.Counts[namespace][proxyName]; !ok {
+		s.Counts[namespace][proxyName] = make(map[string]map[int64]int)
+	}
+	if _, ok := s.Counts[namespace][proxyName][keyName]; !ok {
+		s.Counts[namespace][proxyName][keyName] = make(map[int64]int)
+	}
+	s.Counts[namespace][proxyName][keyName][sec] += count
+}
+
+func (s TrafficSnapshot) addPruned(namespace, proxyName, keyName string, count int) {
+	if _, ok := s.Pruned[namespace]; !ok {
+		s.Pruned[namespace] = make(map[string]map[string]int)
+	}
+	if _, ok := s.Pruned[namespace][proxyName]; !ok {
+		s.Pruned[namespace][proxyName] = make(map[string]int)
+	}
+	s.Pruned[namespace][proxyName][keyName] += count
+}
+
+// Restore merges the traffic of a snapshot into the store. Traffic is
+// restored at the start of the second it was counted in. Seconds that
+// end after since are ignored as their traffic will have already been
+// received from peers.
+func (s *TrafficFactory) Restore(snapshot TrafficSnapshot, since time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for nSpace, proxies := range snapshot.Pruned {
+		for pName, keys := range proxies {
+			for keyName, count := range keys {
+				s.addPruned(nSpace, pName, keyName, count)
+			}
+		}
+	}
+	for nSpace, proxies := range snapshot.Counts {
+		for pName, keys := range proxies {
+			for keyName, counts := range keys {
+				restored := []time.Time{}
+				for sec, count := range counts {
+					point := time.Unix(sec, 0)
+					if point.Add(time.Second).After(since) {
+						continue
+					}
+					for i := 0; i < count; i++ {
+						restored = append(restored, point)
+					}
+				}
+				if len(restored) < 1 {
+					continue
+				}
+				if _, ok := s.trafficMap[nSpace]; !ok {
+					s.trafficMap[nSpace] = make(trafficByAPIProxy)
+				}
+				if _, ok := s.trafficMap[nSpace][pName]; !ok {
+					s.trafficMap[nSpace][pName] = make(trafficByAPIKey)
+				}
+				merged := append(restored, s.trafficMap[nSpace][pName][keyName]...)
+				sort.Slice(merged, func(i, j int) bool {
+					return merged[i].Before(merged[j])
+				})
+				s.trafficMap[nSpace][pName][keyName] = merged
+			}
+		}
+	}
+}
+
 func getTrafficVolume(arr []time.Time, unit string, currTime time.Time, low, high int) int {
 	if arr == nil {
 		return 0
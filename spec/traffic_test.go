@@ -168,6 +168,7 @@ func TestTrafficStoreDoSet(t *testing.T) {
 	assert.Equal(t, 1, len(TrafficStore.trafficMap))
 	assert.Equal(t, 3, len(TrafficStore.trafficMap["namespace-one"]))
 	assert.Equal(t, 2, len(TrafficStore.trafficMap["namespace-one"]["proxy-three"]))
+	assert.Equal(t, []time.Time{currTime}, TrafficStore.trafficMap["namespace-one"]["proxy-one"]["key-one"], "traffic should be recorded at the given time")
 	assert.Equal(t, TrafficStore.doSet(5, currTime).Error(), "parameter not of type string")
 	assert.Equal(t, TrafficStore.doSet("bad-string", currTime).Error(), "kgram must have 3")
 }
@@ -229,6 +230,70 @@ func TestIsRateLimitViolated(t *testing.T) {
 
 }
 
+func TestIsLimitViolated(t *testing.T) {
+	TrafficStore.Clear()
+	testBinding := getTestAPIKeyBinding()
+	testBinding.Spec.Keys[0].Quota = 0
+	testBinding.Spec.Keys[0].Subpaths[0].VerbLimits = []VerbLimit{{Verb: "POST", Quota: 1}}
+	limits := testBinding.Spec.Keys[0].GetLimits("/foo", "POST")
+
+	assert.False(t, TrafficStore.IsLimitViolated(testBinding, "key-one", "/foo", "POST", time.Now()))
+	TrafficStore.Set("namespace-one,proxy-one,key-one")
+	assert.False(t, TrafficStore.IsLimitViolated(testBinding, "key-one", "/foo", "POST", time.Now()))
+	TrafficStore.Set(fmt.Sprintf("namespace-one,proxy-one,%s", limits[1].Bucket))
+	assert.True(t, TrafficStore.IsLimitViolated(testBinding, "key-one", "/foo", "POST", time.Now()))
+	assert.False(t, TrafficStore.IsLimitViolated(testBinding, "key-one", "/foo", "GET", time.Now()))
+	assert.True(t, TrafficStore.IsLimitViolated(testBinding, "key-frank", "/foo", "GET", time.Now()))
+
+	testBinding.Spec.Principals = []Principal{{Key: Key{Name: "principal-one", Quota: 1}, CommonName: "frank"}}
+	assert.False(t, TrafficStore.IsLimitViolated(testBinding, "principal-one", "/foo", "GET", time.Now()))
+	TrafficStore.Set("namespace-one,proxy-one,principal-one")
+	assert.True(t, TrafficStore.IsLimitViolated(testBinding, "principal-one", "/foo", "GET", time.Now()))
+
+	testBinding.Spec.APIProxyNamespace = "namespace-two"
+	assert.False(t, TrafficStore.IsLimitViolated(testBinding, "principal-one", "/foo", "GET", time.Now()), "cross namespace traffic should be tracked separately")
+	TrafficStore.Set("namespace-one,namespace-two/proxy-one,principal-one")
+	assert.True(t, TrafficStore.IsLimitViolated(testBinding, "principal-one", "/foo", "GET", time.Now()))
+}
+
+func TestIsQuotaExceeded(t *testing.T) {
+	TrafficStore.Clear()
+	assert.False(t, TrafficStore.IsQuotaExceeded("namespace-one", "proxy-one", "key-one", 1))
+	TrafficStore.Set("namespace-one,proxy-one,key-one")
+	assert.True(t, TrafficStore.IsQuotaExceeded("namespace-one", "proxy-one", "key-one", 1))
+	assert.False(t, TrafficStore.IsQuotaExceeded("namespace-one", "proxy-one", "key-one", 2))
+	assert.False(t, TrafficStore.IsQuotaExceeded("namespace-one", "proxy-one", "key-one", 0))
+	assert.False(t, TrafficStore.IsQuotaExceeded("namespace-one", "proxy-two", "key-one", 1))
+}
+
+func TestIsRateExceeded(t *testing.T) {
+	TrafficStore.Clear()
+	assert.False(t, TrafficStore.IsRateExceeded("namespace-one", "proxy-one", "key-one", &Rate{1, "minute"}, time.Now()))
+	TrafficStore.Set("namespace-one,proxy-one,key-one")
+	assert.True(t, TrafficStore.IsRateExceeded("namespace-one", "proxy-one", "key-one", &Rate{1, "minute"}, time.Now()))
+	assert.False(t, TrafficStore.IsRateExceeded("namespace-one", "proxy-one", "key-one", &Rate{2, "minute"}, time.Now()))
+	assert.False(t, TrafficStore.IsRateExceeded("namespace-one", "proxy-one", "key-one", &Rate{}, time.Now()))
+	assert.False(t, TrafficStore.IsRateExceeded("namespace-one", "proxy-one", "key-one", nil, time.Now()))
+	assert.False(t, TrafficStore.IsRateExceeded("namespace-one", "proxy-one", "key-one", &Rate{1, "minute"}, time.Now().Add(2*time.Minute)))
+}
+
+func TestTrafficStoreCount(t *testing.T) {
+	store := &TrafficFactory{trafficMap: make(trafficByNamespace), pruned: make(prunedCounts)}
+	assert.Equal(t, 0, store.Count("foo", "bar", "car", time.Now().Add(-time.Minute)))
+
+	now := time.Now()
+	store.trafficMap["foo"] = trafficByAPIProxy{
+		"bar": trafficByAPIKey{
+			"car": {now.Add(-time.Hour), now.Add(-time.Minute), now.Add(-time.Second), now},
+		},
+	}
+	assert.Equal(t, 4, store.Count("foo", "bar", "car", now.Add(-2*time.Hour)))
+	assert.Equal(t, 2, store.Count("foo", "bar", "car", now.Add(-time.Minute)))
+	assert.Equal(t, 1, store.Count("foo", "bar", "car", now.Add(-time.Second)))
+	assert.Equal(t, 0, store.Count("foo", "bar", "car", now))
+	assert.Equal(t, 0, store.Count("foo", "bar", "dar", now.Add(-2*time.Hour)))
+}
+
 func getTestAPIKeyBinding() APIKeyBinding {
 	return APIKeyBinding{
 		TypeMeta: unversioned.TypeMeta{},
@@ -267,3 +332,136 @@ func getTestAPIKeyBinding() APIKeyBinding {
 		},
 	}
 }
+
+func TestTrafficStoreSnapshotRestore(t *testing.T) {
+	assert := assert.New(t)
+	store := &TrafficFactory{trafficMap: make(trafficByNamespace), pruned: make(prunedCounts)}
+
+	since := time.Now()
+	before := since.Add(-2 * time.Minute).Truncate(time.Second)
+	after := since.Add(time.Minute)
+
+	assert.Nil(store.doSet("foo,bar,car", before))
+	assert.Nil(store.doSet("foo,bar,car", before.Add(time.Millisecond)))
+	assert.Nil(store.doSet("foo,bar,car", before.Add(time.Second)))
+	snapshot := store.Snapshot()
+	assert.Equal(map[int64]int{before.Unix(): 2, before.Unix() + 1: 1}, snapshot.Counts["foo"]["bar"]["car"], "traffic should be counted per second")
+
+	store = &TrafficFactory{trafficMap: make(trafficByNamespace), pruned: make(prunedCounts)}
+	assert.Nil(store.doSet("foo,bar,car", since))
+	store.Restore(TrafficSnapshot{
+		Counts: map[string]map[string]map[string]map[int64]int{
+			"foo": {
+				"bar": {
+					"car": {before.Unix(): 1, before.Add(time.Minute).Unix(): 1, since.Unix(): 1, after.Unix(): 1},
+					"dar": {after.Unix(): 1},
+				},
+			},
+		},
+		Pruned: map[string]map[string]map[string]int{
+			"foo": {
+				"bar": {
+					"car": 2,
+				},
+			},
+		},
+	}, since)
+
+	points := store.trafficMap["foo"]["bar"]["car"]
+	assert.Equal(3, len(points), "seconds that end after since should be skipped")
+	assert.Equal(before, points[0])
+	assert.Equal(before.Add(time.Minute), points[1])
+	assert.Equal(since, points[2], "points should remain sorted")
+	_, ok := store.trafficMap["foo"]["bar"]["dar"]
+	assert.False(ok)
+	assert.True(store.IsQuotaExceeded("foo", "bar", "car", 5), "pruned points should be restored")
+	assert.False(store.IsQuotaExceeded("foo", "bar", "car", 6))
+}
+
+func TestTrafficSnapshotMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	one := NewTrafficSnapshot()
+	one.addCount("foo", "bar", "car", 10, 2)
+	one.addCount("foo", "bar", "car", 11, 1)
+	one.addPruned("foo", "bar", "car", 5)
+
+	two := NewTrafficSnapshot()
+	two.addCount("foo", "bar", "car", 10, 1)
+	two.addCount("foo", "bar", "car", 12, 3)
+	two.addCount("foo", "bar", "dar", 10, 1)
+	two.addPruned("foo", "bar", "car", 7)
+
+	merged := NewTrafficSnapshot()
+	merged.Merge(one)
+	merged.Merge(two)
+	merged.Merge(TrafficSnapshot{})
+	assert.Equal(map[int64]int{10: 2, 11: 1, 12: 3}, merged.Counts["foo"]["bar"]["car"])
+	assert.Equal(map[int64]int{10: 1}, merged.Counts["foo"]["bar"]["dar"])
+	assert.Equal(7, merged.Pruned["foo"]["bar"]["car"])
+}
+
+func TestTrafficStorePrune(t *testing.T) {
+	assert := assert.New(t)
+	store := &TrafficFactory{trafficMap: make(trafficByNamespace), pruned: make(prunedCounts)}
+
+	now := time.Now()
+	store.trafficMap["foo"] = trafficByAPIProxy{
+		"bar": trafficByAPIKey{
+			"car": {now.Add(-2 * time.Hour), now.Add(-time.Minute), now},
+			"dar": {now.Add(-2 * time.Hour)},
+		},
+	}
+
+	store.Prune(now.Add(-time.Hour))
+	assert.Equal([]time.Time{now.Add(-time.Minute), now}, store.trafficMap["foo"]["bar"]["car"])
+	_, ok := store.trafficMap["foo"]["bar"]["dar"]
+	assert.False(ok, "empty buckets should be removed")
+	assert.True(store.IsQuotaExceeded("foo", "bar", "car", 3), "pruned points should count towards quotas")
+	assert.False(store.IsQuotaExceeded("foo", "bar", "car", 4))
+	assert.True(store.IsQuotaExceeded("foo", "bar", "dar", 1))
+	assert.True(store.IsRateExceeded("foo", "bar", "car", &Rate{2, "hour"}, now))
+
+	store.Prune(now.Add(time.Second))
+	assert.True(store.IsEmpty())
+	assert.True(store.IsQuotaExceeded("foo", "bar", "car", 3))
+	assert.Equal(0, store.Count("foo", "bar", "car", now.Add(-time.Hour)))
+}
+
+func TestTrafficStoreTrim(t *testing.T) {
+	assert := assert.New(t)
+	store := &TrafficFactory{trafficMap: make(trafficByNamespace), pruned: make(prunedCounts)}
+
+	now := time.Now()
+	store.trafficMap["foo"] = trafficByAPIProxy{
+		"bar": trafficByAPIKey{
+			"car": {now.Add(-2 * time.Hour), now.Add(-time.Minute), now},
+			"dar": {now.Add(-2 * time.Hour)},
+		},
+	}
+	store.trafficMap["other"] = trafficByAPIProxy{
+		"bar": trafficByAPIKey{
+			"car": {now.Add(-2 * time.Hour)},
+		},
+	}
+
+	store.Trim("foo", func(proxyName, keyName string) time.Time {
+		if keyName == "car" {
+			return now.Add(-time.Hour)
+		}
+		return now.Add(-3 * time.Hour)
+	})
+	assert.Equal([]time.Time{now.Add(-time.Minute), now}, store.trafficMap["foo"]["bar"]["car"])
+	assert.Equal([]time.Time{now.Add(-2 * time.Hour)}, store.trafficMap["foo"]["bar"]["dar"])
+	assert.Equal(0, len(store.pruned), "trimmed points should not be kept")
+
+	store.Trim("foo", func(proxyName, keyName string) time.Time {
+		return now.Add(time.Second)
+	})
+	_, ok := store.trafficMap["foo"]
+	assert.False(ok, "empty namespaces should be removed")
+	assert.Equal(1, store.Count("other", "bar", "car", time.Time{}), "other namespaces should not be trimmed")
+	store.Trim("missing", func(proxyName, keyName string) time.Time {
+		return now
+	})
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/signing"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/tracer"
+	"github.com/northwesternmutual/kanali/traffic"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+)
+
+var (
+	errAPIKeyMissing       = errors.New("api key not found in request")
+	errAPIKeyInvalid       = errors.New("api key is not valid")
+	errAPIKeyUnauthorized  = errors.New("api key is not authorized for this request")
+	errAPIKeyLimitExceeded = errors.New("api key limit exceeded")
+
+	errClientCertUnauthorized = errors.New("client certificate is not authorized for this request")
+)
+
+// APIKeyStep is factory that defines a step responsible for authenticating
+// and authorizing requests using the API keys bound to an APIProxy
+type APIKeyStep struct{}
+
+// GetName retruns the name of the APIKeyStep step
+func (step APIKeyStep) GetName() string {
+	return "API Key"
+}
+
+// Do executes the logic of the APIKeyStep step
+func (step APIKeyStep) Do(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metrics, w http.ResponseWriter, r *http.Request, resp *http.Response, trace opentracing.Span) error {
+
+	cert := forwardClientIdentity(r, trace)
+
+	if proxy.Spec.APIKeyAuth == nil {
+		return nil
+	}
+
+	if proxy.Spec.APIKeyAuth.Signature != nil && signing.IsSigned(r) {
+		return authorizeSignedRequest(proxy, m, r, trace)
+	}
+
+	apiKeyData := getAPIKeyData(proxy.Spec.APIKeyAuth, r)
+	if apiKeyData == "" && cert != nil && proxy.Spec.APIKeyAuth.ClientCert {
+		return authorizePrincipal(proxy, m, r, cert)
+	}
+	if apiKeyData == "" {
+		m.Add(
+			metrics.Metric{Name: "apikey_name", Value: "none", Index: true},
+			metrics.Metric{Name: "apikey_namespace", Value: "none", Index: true},
+		)
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: errAPIKeyMissing}
+	}
+
+	untypedKey, err := spec.KeyStore.Get(apiKeyData)
+	if untypedKey == nil {
+		m.Add(
+			metrics.Metric{Name: "apikey_name", Value: "unknown", Index: true},
+			metrics.Metric{Name: "apikey_namespace", Value: "unknown", Index: true},
+		)
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: errAPIKeyInvalid}
+	}
+
+	key, _ := untypedKey.(spec.APIKey)
+
+	return authorizeAPIKey(proxy, m, r, key, err, trace)
+
+}
+
+// authorizeAPIKey authorizes a request using an authenticated APIKey.
+// If the key can not currently be used, validityErr describes why.
+func authorizeAPIKey(proxy *spec.APIProxy, m *metrics.Metrics, r *http.Request, key spec.APIKey, validityErr error, trace opentracing.Span) error {
+	m.Add(
+		metrics.Metric{Name: "apikey_name", Value: key.ObjectMeta.Name, Index: true},
+		metrics.Metric{Name: "apikey_namespace", Value: key.ObjectMeta.Namespace, Index: true},
+	)
+	trace.SetTag(tracer.KanaliAPIKeyName, key.ObjectMeta.Name)
+	trace.SetTag(tracer.KanaliAPIKeyNamespace, key.ObjectMeta.Namespace)
+
+	if validityErr != nil {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: validityErr}
+	}
+
+	if key.ExpiresWithin(viper.GetDuration(config.FlagPluginsAPIKeyExpiryWarningWindow.GetLong()), time.Now()) {
+		m.Add(metrics.Metric{Name: "apikey_expiring_soon", Value: "true", Index: true})
+	}
+
+	for _, binding := range spec.BindingStore.GetForProxy(*proxy) {
+		if boundKey := binding.GetBoundKey(key); boundKey != nil {
+			return enforceBoundKey(proxy, m, binding, boundKey, r, errAPIKeyUnauthorized)
+		}
+	}
+
+	return utils.StatusError{Code: http.StatusForbidden, Err: errAPIKeyUnauthorized}
+}
+
+// authorizePrincipal authorizes a request using the binding principal
+// that a verified client certificate identifies
+func authorizePrincipal(proxy *spec.APIProxy, m *metrics.Metrics, r *http.Request, cert *x509.Certificate) error {
+	for _, binding := range spec.BindingStore.GetForProxy(*proxy) {
+		if boundKey := binding.GetPrincipal(cert); boundKey != nil {
+			m.Add(
+				metrics.Metric{Name: "apikey_name", Value: boundKey.Name, Index: true},
+				metrics.Metric{Name: "apikey_namespace", Value: binding.ObjectMeta.Namespace, Index: true},
+			)
+			return enforceBoundKey(proxy, m, binding, boundKey, r, errClientCertUnauthorized)
+		}
+	}
+
+	return utils.StatusError{Code: http.StatusForbidden, Err: errClientCertUnauthorized}
+}
+
+// enforceBoundKey applies the rules and limits of an apikey or
+// principal of a binding to a request and records its traffic
+func enforceBoundKey(proxy *spec.APIProxy, m *metrics.Metrics, binding spec.APIKeyBinding, boundKey *spec.Key, r *http.Request, errUnauthorized error) error {
+	if !boundKey.IPFilter.IsAllowed(getClientIP(r)) {
+		m.Add(metrics.Metric{Name: "ip_filter_violated", Value: "key", Index: true})
+		return utils.StatusError{Code: http.StatusForbidden, Err: errIPNotAllowed}
+	}
+
+	targetPath := utils.ComputeTargetPath(proxy.Spec.Path, proxy.Spec.Target, utils.ComputeURLPath(r.URL))
+
+	if !boundKey.GetRule(targetPath).IsAuthorized(r.Method) {
+		return utils.StatusError{Code: http.StatusForbidden, Err: errUnauthorized}
+	}
+
+	if spec.TrafficStore.IsLimitViolated(binding, boundKey.Name, targetPath, r.Method, time.Now()) {
+		return utils.StatusError{Code: http.StatusTooManyRequests, Err: errAPIKeyLimitExceeded}
+	}
+
+	traffic.EmitLimits(binding.ObjectMeta.Namespace, binding.GetTrafficProxyName(), boundKey.GetLimits(targetPath, r.Method))
+
+	return nil
+}
+
+// forwardClientIdentity removes any client identity header sent by the
+// client and, if the request has a verified client certificate, replaces
+// it with the identity of the certificate
+func forwardClientIdentity(r *http.Request, trace opentracing.Span) *x509.Certificate {
+	header := viper.GetString(config.FlagProxyClientIdentityHeader.GetLong())
+	if header != "" {
+		r.Header.Del(header)
+	}
+	cert := utils.GetClientCertificate(r)
+	if cert == nil {
+		return nil
+	}
+	identity := utils.GetClientIdentity(cert)
+	trace.SetTag(tracer.KanaliClientIdentity, identity)
+	if header != "" && identity != "" {
+		r.Header.Set(header, identity)
+	}
+	return cert
+}
+
+// getAPIKeyData extracts an API key from the configured header or, if the
+// header is not present, the configured query parameter. Unless the proxy
+// opts in, the key is removed so that it is not forwarded upstream.
+func getAPIKeyData(auth *spec.APIKeyAuth, r *http.Request) string {
+	header := auth.Header
+	if header == "" {
+		header = viper.GetString(config.FlagPluginsAPIKeyHeaderKey.GetLong())
+	}
+	if data := r.Header.Get(header); data != "" {
+		if !auth.ForwardKey {
+			r.Header.Del(header)
+		}
+		return data
+	}
+	param := auth.QueryParam
+	if param == "" {
+		param = viper.GetString(config.FlagPluginsAPIKeyQueryParam.GetLong())
+	}
+	if param == "" || r.URL == nil {
+		return ""
+	}
+	data := r.URL.Query().Get(param)
+	if data != "" && !auth.ForwardKey {
+		r.URL.RawQuery = removeQueryParam(r.URL.RawQuery, param)
+	}
+	return data
+}
+
+// removeQueryParam removes every occurrence of a parameter from a raw query
+// string. The remaining parameters are left in their original order and
+// encoding so that the upstream service receives them as the client sent them.
+func removeQueryParam(rawQuery, name string) string {
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		key := param
+		if i := strings.Index(key, "="); i >= 0 {
+			key = key[:i]
+		}
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == name {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,444 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestAPIKeyGetName(t *testing.T) {
+	assert := assert.New(t)
+	step := APIKeyStep{}
+	assert.Equal(step.GetName(), "API Key", "step name is incorrect")
+}
+
+func TestAPIKey(t *testing.T) {
+	assert := assert.New(t)
+	step := APIKeyStep{}
+	viper.SetDefault(config.FlagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Clear()
+	spec.BindingStore.Clear()
+	spec.TrafficStore.Clear()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:   "/api/v1/accounts",
+			Target: "/",
+		},
+	}
+
+	newRequest := func(method, rawURL, apiKey string) *http.Request {
+		u, _ := url.Parse(rawURL)
+		r := &http.Request{Method: method, URL: u, Header: http.Header{}}
+		if apiKey != "" {
+			r.Header.Set("apikey", apiKey)
+		}
+		return r
+	}
+
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", ""), nil, opentracing.StartSpan("test span")), "api key authentication should not be enforced")
+
+	proxy.Spec.APIKeyAuth = &spec.APIKeyAuth{}
+
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errAPIKeyMissing}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", ""), nil, opentracing.StartSpan("test span")))
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errAPIKeyInvalid}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "abc123"), nil, opentracing.StartSpan("test span")))
+
+	spec.KeyStore.Set(spec.APIKey{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "keyone",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: spec.HashAPIKey("abc123"),
+		},
+	})
+
+	m := &metrics.Metrics{}
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errAPIKeyUnauthorized}, step.Do(context.Background(), proxy, m, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "abc123"), nil, opentracing.StartSpan("test span")), "no binding should exist")
+	assert.Equal("keyone", m.Get("apikey_name").Value)
+
+	spec.BindingStore.Set(spec.APIKeyBinding{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "bindingone",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeyBindingSpec{
+			APIProxyName: "exampleAPIProxyOne",
+			Keys: []spec.Key{
+				{
+					Name:  "keyone",
+					Quota: 2,
+					DefaultRule: spec.Rule{
+						Granular: &spec.GranularProxy{
+							Verbs: []string{"GET"},
+						},
+					},
+					Subpaths: []*spec.Path{
+						{
+							Path: "/admin",
+							Rule: spec.Rule{},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "abc123"), nil, opentracing.StartSpan("test span")))
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errAPIKeyUnauthorized}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("POST", "https://foo.bar.com/api/v1/accounts", "abc123"), nil, opentracing.StartSpan("test span")), "verb should not be authorized")
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errAPIKeyUnauthorized}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts/admin", "abc123"), nil, opentracing.StartSpan("test span")), "subpath should not be authorized")
+
+	spec.KeyStore.Set(spec.APIKey{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "keytwo",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: spec.HashAPIKey("def456"),
+			Disabled:   true,
+		},
+	})
+	m = &metrics.Metrics{}
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: spec.ErrAPIKeyDisabled}, step.Do(context.Background(), proxy, m, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "def456"), nil, opentracing.StartSpan("test span")))
+	assert.Equal("keytwo", m.Get("apikey_name").Value)
+
+	proxy.Spec.APIKeyAuth.QueryParam = "key"
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts?key=abc123", ""), nil, opentracing.StartSpan("test span")))
+	assert.Equal(utils.StatusError{Code: http.StatusTooManyRequests, Err: errAPIKeyLimitExceeded}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "abc123"), nil, opentracing.StartSpan("test span")))
+}
+
+func TestGetAPIKeyData(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(config.FlagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	defer viper.Set(config.FlagPluginsAPIKeyQueryParam.GetLong(), "")
+
+	newRequest := func() *http.Request {
+		u, _ := url.Parse("https://foo.bar.com/api/v1/accounts?apikey=two&key=three")
+		return &http.Request{URL: u, Header: http.Header{"Apikey": []string{"one"}, "X-Api-Key": []string{"four"}}}
+	}
+
+	r := newRequest()
+	assert.Equal("one", getAPIKeyData(&spec.APIKeyAuth{}, r))
+	assert.Equal("", r.Header.Get("apikey"), "headers holding an apikey should be removed")
+	assert.Equal("four", r.Header.Get("X-Api-Key"))
+	assert.Equal("apikey=two&key=three", r.URL.RawQuery)
+
+	r = newRequest()
+	assert.Equal("four", getAPIKeyData(&spec.APIKeyAuth{Header: "X-Api-Key", ForwardKey: true}, r))
+	assert.Equal("four", r.Header.Get("X-Api-Key"), "the apikey should be forwarded if the proxy opts in")
+	assert.Equal("", getAPIKeyData(&spec.APIKeyAuth{Header: "foo"}, r))
+
+	r = newRequest()
+	assert.Equal("three", getAPIKeyData(&spec.APIKeyAuth{Header: "foo", QueryParam: "key"}, r))
+	assert.Equal("apikey=two", r.URL.RawQuery)
+
+	viper.Set(config.FlagPluginsAPIKeyQueryParam.GetLong(), "apikey")
+	r = newRequest()
+	assert.Equal("two", getAPIKeyData(&spec.APIKeyAuth{Header: "foo"}, r))
+	assert.Equal("key=three", r.URL.RawQuery, "query parameters holding an apikey should be removed")
+
+	r = newRequest()
+	assert.Equal("two", getAPIKeyData(&spec.APIKeyAuth{Header: "foo", ForwardKey: true}, r))
+	assert.Equal("apikey=two&key=three", r.URL.RawQuery)
+}
+
+func TestRemoveQueryParam(t *testing.T) {
+	assert.Equal(t, "b=2&z=%2f+x&c", removeQueryParam("b=2&apikey=1&z=%2f+x&c&api%6Bey=3&apikey", "apikey"), "other parameters should keep their order and encoding")
+	assert.Equal(t, "", removeQueryParam("apikey=1", "apikey"))
+	assert.Equal(t, "", removeQueryParam("", "apikey"))
+}
+
+func TestAPIKeyQueryParamNotForwarded(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Reset()
+	spec.ServiceStore.Clear()
+	defer spec.ServiceStore.Clear()
+
+	viper.Set(config.FlagPluginsAPIKeyQueryParam.GetLong(), "apikey")
+	spec.ServiceStore.Set(spec.Service{
+		Name:      "bar",
+		Namespace: "foo",
+		ClusterIP: "1.2.3.4",
+		Port:      8080,
+	})
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:   "/api/v1/accounts",
+			Target: "/",
+			Service: spec.Service{
+				Name:      "bar",
+				Namespace: "foo",
+				Port:      8080,
+			},
+		},
+	}
+
+	r, _ := http.NewRequest("GET", "http://foo.bar.com/api/v1/accounts?page=2&apikey=secret", nil)
+	assert.Equal("secret", getAPIKeyData(&spec.APIKeyAuth{}, r))
+
+	upstream, err := createTargetRequest(proxy, r)
+	assert.Nil(err)
+	assert.Equal("", upstream.URL.Query().Get("apikey"))
+	assert.Equal("page=2", upstream.URL.RawQuery)
+}
+
+func TestAPIKeyClientCert(t *testing.T) {
+	assert := assert.New(t)
+	step := APIKeyStep{}
+	viper.SetDefault(config.FlagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Clear()
+	spec.BindingStore.Clear()
+	spec.TrafficStore.Clear()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:       "/api/v1/accounts",
+			Target:     "/",
+			APIKeyAuth: &spec.APIKeyAuth{},
+		},
+	}
+
+	newRequest := func(method, rawURL, commonName string) *http.Request {
+		u, _ := url.Parse(rawURL)
+		return &http.Request{Method: method, URL: u, Header: http.Header{}, TLS: &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}},
+		}}
+	}
+
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errAPIKeyMissing}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "frank"), nil, opentracing.StartSpan("test span")), "client certificates should not be accepted")
+
+	proxy.Spec.APIKeyAuth.ClientCert = true
+
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errClientCertUnauthorized}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "frank"), nil, opentracing.StartSpan("test span")), "no binding should exist")
+
+	spec.BindingStore.Set(spec.APIKeyBinding{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "bindingone",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeyBindingSpec{
+			APIProxyName: "exampleAPIProxyOne",
+			Principals: []spec.Principal{
+				{
+					Key: spec.Key{
+						Name:  "frank",
+						Quota: 2,
+						DefaultRule: spec.Rule{
+							Granular: &spec.GranularProxy{
+								Verbs: []string{"GET"},
+							},
+						},
+					},
+					CommonName: "frank",
+				},
+			},
+		},
+	})
+
+	m := &metrics.Metrics{}
+	assert.Nil(step.Do(context.Background(), proxy, m, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "frank"), nil, opentracing.StartSpan("test span")))
+	assert.Equal("frank", m.Get("apikey_name").Value)
+	assert.Equal("foo", m.Get("apikey_namespace").Value)
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errClientCertUnauthorized}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("POST", "https://foo.bar.com/api/v1/accounts", "frank"), nil, opentracing.StartSpan("test span")), "verb should not be authorized")
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errClientCertUnauthorized}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "bob"), nil, opentracing.StartSpan("test span")), "certificate should not identify a principal")
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "frank"), nil, opentracing.StartSpan("test span")))
+	assert.Equal(utils.StatusError{Code: http.StatusTooManyRequests, Err: errAPIKeyLimitExceeded}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("GET", "https://foo.bar.com/api/v1/accounts", "frank"), nil, opentracing.StartSpan("test span")))
+}
+
+func TestForwardClientIdentity(t *testing.T) {
+	assert := assert.New(t)
+	viper.Set(config.FlagProxyClientIdentityHeader.GetLong(), "X-Client-Identity")
+	defer viper.Set(config.FlagProxyClientIdentityHeader.GetLong(), "")
+
+	r := &http.Request{Header: http.Header{"X-Client-Identity": []string{"spoofed"}}}
+	assert.Nil(forwardClientIdentity(r, opentracing.StartSpan("test span")))
+	assert.Equal("", r.Header.Get("X-Client-Identity"))
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "frank"}}
+	r = &http.Request{Header: http.Header{"X-Client-Identity": []string{"spoofed"}}, TLS: &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert}},
+	}}
+	assert.Equal(cert, forwardClientIdentity(r, opentracing.StartSpan("test span")))
+	assert.Equal("frank", r.Header.Get("X-Client-Identity"))
+}
+
+func TestAPIKeyIPFilter(t *testing.T) {
+	assert := assert.New(t)
+	step := APIKeyStep{}
+	viper.SetDefault(config.FlagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Clear()
+	spec.BindingStore.Clear()
+	spec.TrafficStore.Clear()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:       "/api/v1/accounts",
+			Target:     "/",
+			APIKeyAuth: &spec.APIKeyAuth{},
+		},
+	}
+
+	spec.KeyStore.Set(spec.APIKey{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "keyone",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: spec.HashAPIKey("abc123"),
+		},
+	})
+	spec.BindingStore.Set(spec.APIKeyBinding{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "bindingone",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeyBindingSpec{
+			APIProxyName: "exampleAPIProxyOne",
+			Keys: []spec.Key{
+				{
+					Name:        "keyone",
+					DefaultRule: spec.Rule{Global: true},
+					IPFilter:    &spec.IPFilter{Allow: []string{"203.0.113.0/24"}},
+				},
+			},
+		},
+	})
+
+	newRequest := func(remoteAddr string) *http.Request {
+		u, _ := url.Parse("https://foo.bar.com/api/v1/accounts")
+		return &http.Request{Method: "GET", URL: u, RemoteAddr: remoteAddr, Header: http.Header{"Apikey": []string{"abc123"}}}
+	}
+
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("203.0.113.10:1234"), nil, opentracing.StartSpan("test span")))
+
+	m := &metrics.Metrics{}
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errIPNotAllowed}, step.Do(context.Background(), proxy, m, nil, newRequest("198.51.100.10:1234"), nil, opentracing.StartSpan("test span")))
+	assert.Equal("key", m.Get("ip_filter_violated").Value)
+}
+
+func TestAPIKeyCrossNamespaceBinding(t *testing.T) {
+	assert := assert.New(t)
+	step := APIKeyStep{}
+	viper.SetDefault(config.FlagPluginsAPIKeyHeaderKey.GetLong(), "apikey")
+	spec.KeyStore.Clear()
+	spec.BindingStore.Clear()
+	spec.TrafficStore.Clear()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "team",
+		},
+		Spec: spec.APIProxySpec{
+			Path:       "/api/v1/accounts",
+			Target:     "/",
+			APIKeyAuth: &spec.APIKeyAuth{},
+		},
+	}
+
+	spec.KeyStore.Set(spec.APIKey{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "keyone",
+			Namespace: "platform",
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: spec.HashAPIKey("abc123"),
+		},
+	})
+	spec.KeyStore.Set(spec.APIKey{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "keytwo",
+			Namespace: "other",
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: spec.HashAPIKey("def456"),
+		},
+	})
+	spec.BindingStore.Set(spec.APIKeyBinding{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "bindingone",
+			Namespace: "platform",
+		},
+		Spec: spec.APIKeyBindingSpec{
+			APIProxyName:      "exampleAPIProxyOne",
+			APIProxyNamespace: "team",
+			Keys: []spec.Key{
+				{Name: "keyone", DefaultRule: spec.Rule{Global: true}},
+				{Name: "keytwo", DefaultRule: spec.Rule{Global: true}},
+			},
+		},
+	})
+
+	newRequest := func(apiKey string) *http.Request {
+		u, _ := url.Parse("https://foo.bar.com/api/v1/accounts")
+		return &http.Request{Method: "GET", URL: u, Header: http.Header{"Apikey": []string{apiKey}}}
+	}
+
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errAPIKeyUnauthorized}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("abc123"), nil, opentracing.StartSpan("test span")), "the proxy should not allow the binding namespace")
+
+	proxy.Spec.AllowedBindingNamespaces = []string{"platform"}
+
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("abc123"), nil, opentracing.StartSpan("test span")))
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errAPIKeyUnauthorized}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("def456"), nil, opentracing.StartSpan("test span")), "keys in other namespaces should not be bound")
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/limiter"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+)
+
+var (
+	adaptiveLimiter     *limiter.AdaptiveLimiter
+	adaptiveLimiterOnce sync.Once
+)
+
+// getAdaptiveLimiter returns the global adaptive concurrency
+// limiter or nil if adaptive concurrency is not enabled
+func getAdaptiveLimiter() *limiter.AdaptiveLimiter {
+	if !viper.GetBool(config.FlagProxyAdaptiveConcurrency.GetLong()) {
+		return nil
+	}
+	adaptiveLimiterOnce.Do(func() {
+		adaptiveLimiter = limiter.NewAdaptive(
+			viper.GetInt(config.FlagProxyAdaptiveConcurrencyMinLimit.GetLong()),
+			viper.GetInt(config.FlagProxyAdaptiveConcurrencyMaxLimit.GetLong()),
+			viper.GetInt(config.FlagProxyAdaptiveConcurrencyMaxQueue.GetLong()),
+			viper.GetDuration(config.FlagProxyAdaptiveConcurrencyTargetLatency.GetLong()),
+		)
+	})
+	return adaptiveLimiter
+}
+
+// acquireConcurrency admits a request to the upstream service of an APIProxy
+// subject to both the APIProxy's concurrency limit and the global adaptive
+// concurrency limit. The returned function must be called with the observed
+// upstream latency once the upstream response has been consumed.
+func acquireConcurrency(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metrics) (func(time.Duration), error) {
+
+	t0 := time.Now()
+	releases := []func(){}
+
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+
+	if c := proxy.Spec.Concurrency; c != nil && c.MaxInFlight > 0 {
+		var timeout time.Duration
+		if c.QueueTimeout != "" {
+			d, err := time.ParseDuration(c.QueueTimeout)
+			if err != nil {
+				return nil, utils.StatusError{Code: http.StatusInternalServerError, Err: fmt.Errorf("invalid concurrency queue timeout %s", c.QueueTimeout)}
+			}
+			timeout = d
+		}
+		l := limiter.Proxies.Get(limiter.Name(proxy.ObjectMeta.Namespace, proxy.ObjectMeta.Name), c.MaxInFlight, c.MaxQueue)
+		release, err := l.Acquire(ctx, timeout)
+		if err != nil {
+			m.Add(metrics.Metric{Name: "concurrency_rejected", Value: "proxy", Index: true})
+			return nil, utils.StatusError{Code: http.StatusServiceUnavailable, Err: err}
+		}
+		releases = append(releases, release)
+		m.Add(metrics.Metric{Name: "concurrency_in_flight", Value: l.InFlight(), Index: false})
+	}
+
+	global := getAdaptiveLimiter()
+	if global != nil {
+		release, err := global.Acquire(ctx, viper.GetDuration(config.FlagProxyAdaptiveConcurrencyQueueTimeout.GetLong()))
+		if err != nil {
+			releaseAll()
+			m.Add(metrics.Metric{Name: "concurrency_rejected", Value: "adaptive", Index: true})
+			return nil, utils.StatusError{Code: http.StatusServiceUnavailable, Err: err}
+		}
+		releases = append(releases, release)
+		m.Add(metrics.Metric{Name: "adaptive_concurrency_limit", Value: global.Limit(), Index: false})
+	}
+
+	m.Add(metrics.Metric{Name: "concurrency_wait_time", Value: int(time.Now().Sub(t0) / time.Millisecond), Index: false})
+
+	return func(latency time.Duration) {
+		releaseAll()
+		if global != nil {
+			global.Observe(latency)
+		}
+	}, nil
+
+}
+
+// releaseBody holds a concurrency slot until the body of an upstream
+// response has been read to the end or closed. The latency observed by the
+// adaptive limiter is measured when the response headers are received so
+// that slow clients are not mistaken for a slow upstream service.
+func releaseBody(body io.ReadCloser, release func(time.Duration), latency time.Duration) io.ReadCloser {
+	if body == nil {
+		release(latency)
+		return nil
+	}
+	return &releasingBody{ReadCloser: body, release: func() { release(latency) }}
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
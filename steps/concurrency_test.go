@@ -0,0 +1,141 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/limiter"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestAcquireConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	limiter.Proxies.Clear()
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+	}
+
+	m := &metrics.Metrics{}
+	release, err := acquireConcurrency(context.Background(), proxy, m)
+	assert.Nil(err, "no concurrency limit should be enforced")
+	assert.Nil(m.Get("concurrency_in_flight"))
+	release(time.Millisecond)
+
+	proxy.Spec.Concurrency = &spec.Concurrency{
+		MaxInFlight:  1,
+		QueueTimeout: "10ms",
+	}
+
+	m = &metrics.Metrics{}
+	release, err = acquireConcurrency(context.Background(), proxy, m)
+	assert.Nil(err)
+	assert.Equal(1, m.Get("concurrency_in_flight").Value)
+	assert.NotNil(m.Get("concurrency_wait_time"))
+
+	m = &metrics.Metrics{}
+	_, err = acquireConcurrency(context.Background(), proxy, m)
+	assert.Equal(utils.StatusError{Code: http.StatusServiceUnavailable, Err: limiter.ErrQueueFull}, err)
+	assert.Equal("proxy", m.Get("concurrency_rejected").Value)
+
+	proxy.Spec.Concurrency.MaxQueue = 1
+	_, err = acquireConcurrency(context.Background(), proxy, &metrics.Metrics{})
+	assert.Equal(utils.StatusError{Code: http.StatusServiceUnavailable, Err: limiter.ErrQueueTimeout}, err)
+
+	release(time.Millisecond)
+	assert.Equal(0, limiter.Proxies.Get(limiter.Name("foo", "exampleAPIProxyOne"), 1, 1).InFlight())
+
+	proxy.Spec.Concurrency.QueueTimeout = "foo"
+	_, err = acquireConcurrency(context.Background(), proxy, &metrics.Metrics{})
+	assert.Equal(utils.StatusError{Code: http.StatusInternalServerError, Err: errors.New("invalid concurrency queue timeout foo")}, err)
+}
+
+func TestAcquireAdaptiveConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Set(config.FlagProxyAdaptiveConcurrency.GetLong(), false)
+	limiter.Proxies.Clear()
+
+	viper.Set(config.FlagProxyAdaptiveConcurrency.GetLong(), true)
+	viper.Set(config.FlagProxyAdaptiveConcurrencyMinLimit.GetLong(), 1)
+	viper.Set(config.FlagProxyAdaptiveConcurrencyMaxLimit.GetLong(), 2)
+	viper.Set(config.FlagProxyAdaptiveConcurrencyMaxQueue.GetLong(), 0)
+	viper.Set(config.FlagProxyAdaptiveConcurrencyTargetLatency.GetLong(), "0h0m1s")
+
+	proxy := &spec.APIProxy{}
+
+	m := &metrics.Metrics{}
+	release, err := acquireConcurrency(context.Background(), proxy, m)
+	assert.Nil(err)
+	assert.Equal(2, m.Get("adaptive_concurrency_limit").Value)
+	release(2 * time.Second)
+	assert.Equal(1, getAdaptiveLimiter().Limit())
+
+	release, err = acquireConcurrency(context.Background(), proxy, &metrics.Metrics{})
+	assert.Nil(err)
+
+	m = &metrics.Metrics{}
+	_, err = acquireConcurrency(context.Background(), proxy, m)
+	assert.Equal(utils.StatusError{Code: http.StatusServiceUnavailable, Err: limiter.ErrQueueFull}, err)
+	assert.Equal("adaptive", m.Get("concurrency_rejected").Value)
+	release(time.Millisecond)
+}
+
+func TestReleaseBody(t *testing.T) {
+	assert := assert.New(t)
+
+	var released []time.Duration
+	release := func(latency time.Duration) {
+		released = append(released, latency)
+	}
+
+	body := releaseBody(ioutil.NopCloser(bytes.NewReader([]byte("foo"))), release, time.Second)
+	assert.Equal(0, len(released), "slot should be held until the body is consumed")
+	data, _ := ioutil.ReadAll(body)
+	assert.Equal("foo", string(data))
+	assert.Equal([]time.Duration{time.Second}, released)
+	body.Close()
+	assert.Equal(1, len(released), "slot should only be released once")
+
+	released = nil
+	body = releaseBody(ioutil.NopCloser(bytes.NewReader([]byte("foo"))), release, time.Second)
+	body.Close()
+	assert.Equal([]time.Duration{time.Second}, released, "closing an unread body should release the slot")
+
+	released = nil
+	assert.Nil(releaseBody(nil, release, time.Second))
+	assert.Equal([]time.Duration{time.Second}, released)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+)
+
+var errIPNotAllowed = errors.New("client ip address is not allowed")
+
+// IPFilterStep is factory that defines a step responsible for enforcing
+// the client IP addresses that an APIProxy allows or denies
+type IPFilterStep struct{}
+
+// GetName retruns the name of the IPFilterStep step
+func (step IPFilterStep) GetName() string {
+	return "IP Filter"
+}
+
+// Do executes the logic of the IPFilterStep step
+func (step IPFilterStep) Do(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metrics, w http.ResponseWriter, r *http.Request, resp *http.Response, trace opentracing.Span) error {
+
+	if proxy.Spec.IPFilter == nil {
+		return nil
+	}
+
+	if !proxy.Spec.IPFilter.IsAllowed(getClientIP(r)) {
+		m.Add(metrics.Metric{Name: "ip_filter_violated", Value: "proxy", Index: true})
+		return utils.StatusError{Code: http.StatusForbidden, Err: errIPNotAllowed}
+	}
+
+	return nil
+
+}
+
+// getClientIP returns the IP address of the client that originated a
+// request, honoring the X-Forwarded-For header of trusted proxies
+func getClientIP(r *http.Request) string {
+	return utils.ComputeClientIP(r, viper.GetStringSlice(config.FlagServerTrustedProxies.GetLong()))
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPFilterGetName(t *testing.T) {
+	assert := assert.New(t)
+	step := IPFilterStep{}
+	assert.Equal(step.GetName(), "IP Filter", "step name is incorrect")
+}
+
+func TestIPFilter(t *testing.T) {
+	assert := assert.New(t)
+	step := IPFilterStep{}
+	viper.Set(config.FlagServerTrustedProxies.GetLong(), []string{"10.0.0.1"})
+	defer viper.Set(config.FlagServerTrustedProxies.GetLong(), []string{})
+
+	proxy := &spec.APIProxy{}
+
+	newRequest := func(remoteAddr, forwardedFor string) *http.Request {
+		r := &http.Request{RemoteAddr: remoteAddr, Header: http.Header{}}
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return r
+	}
+
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("1.2.3.4:1234", ""), nil, opentracing.StartSpan("test span")))
+
+	proxy.Spec.IPFilter = &spec.IPFilter{Allow: []string{"192.168.0.0/16"}, Deny: []string{"192.168.1.0/24"}}
+
+	m := &metrics.Metrics{}
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errIPNotAllowed}, step.Do(context.Background(), proxy, m, nil, newRequest("1.2.3.4:1234", ""), nil, opentracing.StartSpan("test span")))
+	assert.Equal("proxy", m.Get("ip_filter_violated").Value)
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("192.168.2.1:1234", ""), nil, opentracing.StartSpan("test span")))
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errIPNotAllowed}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("192.168.1.1:1234", ""), nil, opentracing.StartSpan("test span")))
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("10.0.0.1:1234", "192.168.2.1"), nil, opentracing.StartSpan("test span")), "the address forwarded by a trusted proxy should be used")
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errIPNotAllowed}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, newRequest("1.2.3.4:1234", "192.168.2.1"), nil, opentracing.StartSpan("test span")), "the address forwarded by an untrusted proxy should not be used")
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/jwt"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/tracer"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// jwksSecretKey is the key in a Secret's data that holds a JSON Web Key Set
+const jwksSecretKey = "jwks.json"
+
+var (
+	errJWTMissing      = errors.New("bearer token not found in request")
+	errJWTUnauthorized = errors.New("bearer token is not authorized for this request")
+	errJWKSNotFound    = errors.New("json web key set not found")
+)
+
+// jwtClaimsKey is the request context key that
+// the verified claims of a bearer token are stored under
+type jwtClaimsKey struct{}
+
+var (
+	keySetCache     *jwt.KeySetCache
+	keySetCacheOnce sync.Once
+)
+
+// secretKeySet is a JSON Web Key Set parsed from
+// a particular version of a Secret
+type secretKeySet struct {
+	resourceVersion string
+	set             *jwt.KeySet
+	err             error
+}
+
+// secretKeySets caches the key sets parsed from Secrets
+// indexed by the namespace and name of the Secret
+var secretKeySets = struct {
+	sync.Mutex
+	entries map[string]secretKeySet
+}{entries: map[string]secretKeySet{}}
+
+// JWTStep is factory that defines a step responsible for validating
+// the JSON Web Token bearer token of a request
+type JWTStep struct{}
+
+// GetName retruns the name of the JWTStep step
+func (step JWTStep) GetName() string {
+	return "JWT"
+}
+
+// Do executes the logic of the JWTStep step
+func (step JWTStep) Do(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metrics, w http.ResponseWriter, r *http.Request, resp *http.Response, trace opentracing.Span) error {
+
+	if proxy.Spec.JWT == nil {
+		return nil
+	}
+
+	raw := getBearerToken(r)
+	if raw == "" {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: errJWTMissing}
+	}
+
+	token, err := parseJWT(proxy, raw)
+	if err != nil {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: err}
+	}
+
+	if err := token.Claims.Validate(proxy.Spec.JWT.Issuer, proxy.Spec.JWT.Audiences, time.Now(), viper.GetDuration(config.FlagProxyJWTClockSkew.GetLong())); err != nil {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: err}
+	}
+
+	if sub := token.Claims.String("sub"); sub != "" {
+		trace.SetTag(tracer.KanaliJWTSubject, sub)
+		m.Add(metrics.Metric{Name: "jwt_subject", Value: sub, Index: false})
+	}
+
+	targetPath := utils.ComputeTargetPath(proxy.Spec.Path, proxy.Spec.Target, utils.ComputeURLPath(r.URL))
+
+	for _, rule := range proxy.Spec.JWT.Rules {
+		if rule.Matches(targetPath, r.Method) && !isJWTAuthorized(rule, token.Claims) {
+			return utils.StatusError{Code: http.StatusForbidden, Err: errJWTUnauthorized}
+		}
+	}
+
+	for _, forward := range proxy.Spec.JWT.ForwardClaims {
+		r.Header.Del(forward.Header)
+		if value := token.Claims.String(forward.Claim); value != "" {
+			r.Header.Set(forward.Header, value)
+		}
+	}
+
+	*r = *r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, token.Claims))
+
+	return nil
+
+}
+
+// getVerifiedClaims returns the claims of a bearer token
+// that were verified by the JWTStep step, if any
+func getVerifiedClaims(r *http.Request) (jwt.Claims, bool) {
+	claims, ok := r.Context().Value(jwtClaimsKey{}).(jwt.Claims)
+	return claims, ok
+}
+
+// getBearerToken extracts a bearer token from the Authorization header
+func getBearerToken(r *http.Request) string {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// parseJWT verifies a token using the JSON Web Key Set configured for an APIProxy.
+// If a key set fetched from a URL does not contain the signing key, it is refetched
+// once in case the issuer has rotated its keys.
+func parseJWT(proxy *spec.APIProxy, raw string) (*jwt.Token, error) {
+	if proxy.Spec.JWT.JWKSURL == "" {
+		keys, err := getSecretKeySet(proxy.Spec.JWT.JWKSSecret, proxy.ObjectMeta.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.Parse(raw, keys)
+	}
+
+	cache := getKeySetCache()
+	keys, err := cache.Get(proxy.Spec.JWT.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	token, err := jwt.Parse(raw, keys)
+	if err != jwt.ErrKeyNotFound {
+		return token, err
+	}
+	if keys, err = cache.Refresh(proxy.Spec.JWT.JWKSURL); err != nil {
+		return nil, err
+	}
+	return jwt.Parse(raw, keys)
+}
+
+func getKeySetCache() *jwt.KeySetCache {
+	keySetCacheOnce.Do(func() {
+		keySetCache = jwt.NewKeySetCache(&http.Client{Timeout: 10 * time.Second}, viper.GetDuration(config.FlagProxyJWKSRefreshInterval.GetLong()))
+	})
+	return keySetCache
+}
+
+// getSecretKeySet loads a JSON Web Key Set from a Secret. The parsed
+// key set is cached until the resource version of the Secret changes.
+func getSecretKeySet(name, namespace string) (*jwt.KeySet, error) {
+	cacheKey := namespace + "/" + name
+
+	secretKeySets.Lock()
+	defer secretKeySets.Unlock()
+
+	untypedSecret, err := spec.SecretStore.Get(name, namespace)
+	if err != nil || untypedSecret == nil {
+		delete(secretKeySets.entries, cacheKey)
+		return nil, errJWKSNotFound
+	}
+	secret, _ := untypedSecret.(api.Secret)
+
+	if entry, ok := secretKeySets.entries[cacheKey]; ok && entry.resourceVersion != "" && entry.resourceVersion == secret.ObjectMeta.ResourceVersion {
+		return entry.set, entry.err
+	}
+
+	data, ok := secret.Data[jwksSecretKey]
+	if !ok {
+		delete(secretKeySets.entries, cacheKey)
+		return nil, errJWKSNotFound
+	}
+	set, err := jwt.ParseKeySet(data)
+	secretKeySets.entries[cacheKey] = secretKeySet{
+		resourceVersion: secret.ObjectMeta.ResourceVersion,
+		set:             set,
+		err:             err,
+	}
+	return set, err
+}
+
+// isJWTAuthorized reports whether a token has every scope
+// and claim value required by a rule
+func isJWTAuthorized(rule spec.JWTRule, claims jwt.Claims) bool {
+	scopes := claims.Scopes()
+	for _, required := range rule.Scopes {
+		if !containsString(scopes, required) {
+			return false
+		}
+	}
+	for name, value := range rule.Claims {
+		if !containsString(claims.Strings(name), value) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/jwt"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func signTestJWT(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(jwt.Header{Algorithm: "RS256", KeyID: kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hasher := crypto.SHA256.New()
+	hasher.Write([]byte(signingInput))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hasher.Sum(nil))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeTestJWKS(kid string, pub *rsa.PublicKey) []byte {
+	data, _ := json.Marshal(map[string][]jwt.JWK{"keys": {{
+		KeyType: "RSA",
+		KeyID:   kid,
+		N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+	return data
+}
+
+func TestJWTGetName(t *testing.T) {
+	assert := assert.New(t)
+	step := JWTStep{}
+	assert.Equal(step.GetName(), "JWT", "step name is incorrect")
+}
+
+func TestJWT(t *testing.T) {
+	assert := assert.New(t)
+	step := JWTStep{}
+	spec.SecretStore.Clear()
+	defer spec.SecretStore.Clear()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:   "/api/v1/accounts",
+			Target: "/",
+		},
+	}
+
+	newRequest := func(method, rawURL, token string) *http.Request {
+		u, _ := url.Parse(rawURL)
+		r := &http.Request{Method: method, URL: u, Header: http.Header{}}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	do := func(r *http.Request) error {
+		return step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, r, nil, opentracing.StartSpan("test span"))
+	}
+
+	claims := map[string]interface{}{
+		"iss":    "https://issuer.example.com",
+		"aud":    "kanali",
+		"sub":    "frank",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"scope":  "accounts:read",
+		"groups": []string{"users"},
+	}
+	token := signTestJWT(key, "one", claims)
+
+	assert.Nil(do(newRequest("GET", "https://foo.bar.com/api/v1/accounts", "")), "jwt validation should not be enforced")
+
+	proxy.Spec.JWT = &spec.JWT{
+		Issuer:     "https://issuer.example.com",
+		Audiences:  []string{"kanali"},
+		JWKSSecret: "jwks",
+		Rules: []spec.JWTRule{
+			{Path: "/", Verbs: []string{"GET"}, Scopes: []string{"accounts:read"}},
+			{Path: "/admin", Claims: map[string]string{"groups": "admins"}},
+		},
+		ForwardClaims: []spec.ClaimHeader{
+			{Claim: "sub", Header: "X-User"},
+			{Claim: "email", Header: "X-Email"},
+		},
+	}
+
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errJWTMissing}, do(newRequest("GET", "https://foo.bar.com/api/v1/accounts", "")))
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errJWKSNotFound}, do(newRequest("GET", "https://foo.bar.com/api/v1/accounts", token)))
+
+	spec.SecretStore.Set(api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "jwks",
+			Namespace: "foo",
+		},
+		Data: map[string][]byte{
+			"jwks.json": encodeTestJWKS("one", &key.PublicKey),
+		},
+	})
+
+	r := newRequest("GET", "https://foo.bar.com/api/v1/accounts", token)
+	r.Header.Set("X-Email", "spoofed@example.com")
+	assert.Nil(do(r))
+	assert.Equal("frank", r.Header.Get("X-User"))
+	assert.Equal("", r.Header.Get("X-Email"), "forwarded claim headers should not be spoofable")
+
+	r = newRequest("GET", "https://foo.bar.com/api/v1/accounts", token)
+	r.Header.Set("Authorization", "bearer "+token)
+	assert.Nil(do(r))
+
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: jwt.ErrInvalidSignature}, do(newRequest("GET", "https://foo.bar.com/api/v1/accounts", signTestJWT(otherKey, "one", claims))))
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: jwt.ErrKeyNotFound}, do(newRequest("GET", "https://foo.bar.com/api/v1/accounts", signTestJWT(key, "two", claims))))
+
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: jwt.ErrExpired}, do(newRequest("GET", "https://foo.bar.com/api/v1/accounts", signTestJWT(key, "one", claims))))
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+
+	claims["aud"] = "other"
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: jwt.ErrInvalidAudience}, do(newRequest("GET", "https://foo.bar.com/api/v1/accounts", signTestJWT(key, "one", claims))))
+	claims["aud"] = "kanali"
+
+	claims["scope"] = "accounts:write"
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errJWTUnauthorized}, do(newRequest("GET", "https://foo.bar.com/api/v1/accounts", signTestJWT(key, "one", claims))))
+	assert.Nil(do(newRequest("POST", "https://foo.bar.com/api/v1/accounts", signTestJWT(key, "one", claims))))
+
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errJWTUnauthorized}, do(newRequest("POST", "https://foo.bar.com/api/v1/accounts/admin", signTestJWT(key, "one", claims))))
+	claims["groups"] = []string{"users", "admins"}
+	assert.Nil(do(newRequest("POST", "https://foo.bar.com/api/v1/accounts/admin", signTestJWT(key, "one", claims))))
+}
+
+func TestJWTKeySetURL(t *testing.T) {
+	assert := assert.New(t)
+	step := JWTStep{}
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rotatedKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	getKeySetCache().SetTTL(time.Hour)
+	defer getKeySetCache().SetTTL(viper.GetDuration(config.FlagProxyJWKSRefreshInterval.GetLong()))
+
+	current := encodeTestJWKS("one", &key.PublicKey)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, string(current))
+	}))
+	defer server.Close()
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:   "/api/v1/accounts",
+			Target: "/",
+			JWT: &spec.JWT{
+				JWKSURL: server.URL,
+			},
+		},
+	}
+
+	do := func(token string) error {
+		u, _ := url.Parse("https://foo.bar.com/api/v1/accounts")
+		r := &http.Request{Method: "GET", URL: u, Header: http.Header{"Authorization": []string{"Bearer " + token}}}
+		return step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, r, nil, opentracing.StartSpan("test span"))
+	}
+
+	claims := map[string]interface{}{"sub": "frank", "exp": time.Now().Add(time.Hour).Unix()}
+	assert.Nil(do(signTestJWT(key, "one", claims)))
+
+	current = encodeTestJWKS("two", &rotatedKey.PublicKey)
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: jwt.ErrKeyNotFound}, do(signTestJWT(rotatedKey, "two", claims)), "recently fetched key sets should not be refetched")
+}
+
+func TestGetSecretKeySetCache(t *testing.T) {
+	assert := assert.New(t)
+	spec.SecretStore.Clear()
+	defer spec.SecretStore.Clear()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	secret := func(resourceVersion, kid string) api.Secret {
+		return api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:            "jwks",
+				Namespace:       "foo",
+				ResourceVersion: resourceVersion,
+			},
+			Data: map[string][]byte{
+				"jwks.json": encodeTestJWKS(kid, &key.PublicKey),
+			},
+		}
+	}
+
+	spec.SecretStore.Set(secret("1", "one"))
+	set, err := getSecretKeySet("jwks", "foo")
+	assert.Nil(err)
+	assert.Equal("one", set.Keys[0].ID)
+
+	spec.SecretStore.Set(secret("1", "two"))
+	cached, err := getSecretKeySet("jwks", "foo")
+	assert.Nil(err)
+	assert.True(set == cached, "key set should be cached for the same resource version")
+
+	spec.SecretStore.Set(secret("2", "two"))
+	set, err = getSecretKeySet("jwks", "foo")
+	assert.Nil(err)
+	assert.Equal("two", set.Keys[0].ID, "key set should be parsed again when the secret changes")
+
+	spec.SecretStore.Clear()
+	_, err = getSecretKeySet("jwks", "foo")
+	assert.Equal(errJWKSNotFound, err)
+}
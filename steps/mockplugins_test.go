@@ -23,10 +23,15 @@ package steps
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/plugins"
 	"github.com/northwesternmutual/kanali/spec"
 	"github.com/opentracing/opentracing-go"
 )
@@ -60,3 +65,28 @@ func (plugin fakeErrorPlugin) OnRequest(ctx context.Context, m *metrics.Metrics,
 func (plugin fakeErrorPlugin) OnResponse(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, resp *http.Response, span opentracing.Span) error {
 	return errors.New("error")
 }
+
+type fakeConfigurablePlugin struct {
+	fakeErrorPlugin
+}
+
+func (plugin fakeConfigurablePlugin) OnRequestWithConfig(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, config json.RawMessage, r *http.Request, span opentracing.Span) error {
+	return fmt.Errorf("request config %s", string(config))
+}
+
+func (plugin fakeConfigurablePlugin) OnResponseWithConfig(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, config json.RawMessage, r *http.Request, resp *http.Response, span opentracing.Span) error {
+	return fmt.Errorf("response config %s", string(config))
+}
+
+type fakeResponderPlugin struct {
+	fakeSuccessPlugin
+}
+
+func (plugin fakeResponderPlugin) OnRequestWithResult(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, config json.RawMessage, r *http.Request, span opentracing.Span) (*plugins.RequestResult, error) {
+	return &plugins.RequestResult{
+		Response: &http.Response{
+			StatusCode: http.StatusTeapot,
+			Body:       ioutil.NopCloser(strings.NewReader("short and stout")),
+		},
+	}, nil
+}
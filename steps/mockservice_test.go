@@ -96,11 +96,11 @@ func TestMockServiceDo(t *testing.T) {
   body, _ := ioutil.ReadAll(res.Body)
   assert.Equal(t, string(body), `{"foo":"bar"}`)
   req, _ = http.NewRequest("GET", "http://foo.bar.com/api/v1/accounts/bar", nil)
-  assert.Nil(t, step.Do(context.Background(), accountsProxy, m, nil, req, res, span))
+  assert.Nil(t, step.Do(context.Background(), accountsProxy, m, nil, req, &http.Response{}, span))
   req, _ = http.NewRequest("GET", "http://foo.bar.com/api/v1/balance", nil)
-  assert.Equal(t, step.Do(context.Background(), balanceProxy, m, nil, req, res, span).Error(), "no mock response found")
+  assert.Equal(t, step.Do(context.Background(), balanceProxy, m, nil, req, &http.Response{}, span).Error(), "no mock response found")
   req, _ = http.NewRequest("GET", "http://foo.bar.com/api/v1/address", nil)
-  assert.Equal(t, step.Do(context.Background(), addressProxy, m, nil, req, res, span).Error(), "no mock response found")
+  assert.Equal(t, step.Do(context.Background(), addressProxy, m, nil, req, &http.Response{}, span).Error(), "no mock response found")
 }
 
 func getTestConfigMaps() []api.ConfigMap {
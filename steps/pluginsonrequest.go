@@ -21,15 +21,18 @@
 package steps
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/northwesternmutual/kanali/metrics"
 	"github.com/northwesternmutual/kanali/plugins"
 	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
 	"github.com/opentracing/opentracing-go"
 )
 
@@ -45,28 +48,99 @@ func (step PluginsOnRequestStep) GetName() string {
 // Do executes the logic of the PluginsOnRequestStep step
 func (step PluginsOnRequestStep) Do(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metrics, w http.ResponseWriter, r *http.Request, resp *http.Response, trace opentracing.Span) error {
 
-	for _, plugin := range proxy.Spec.Plugins {
-		p, err := plugins.GetPlugin(plugin)
+	if failed := proxy.Status.GetFailedPlugin(); failed != nil {
+		return utils.StatusError{
+			Code: http.StatusServiceUnavailable,
+			Err:  fmt.Errorf("plugin %s is unavailable: %s", failed.Name, failed.GetReason()),
+		}
+	}
+
+	for _, plugin := range proxy.GetPlugins() {
+		p, err := plugins.Registry.Get(plugin)
 		if err != nil {
 			return err
 		}
-		if err := doOnRequest(ctx, m, plugin.Name, *proxy, r, trace, *p); err != nil {
+		response, err := doOnRequest(ctx, m, plugin, *proxy, r, trace, p)
+		if err != nil {
 			return err
 		}
+		if response != nil {
+			// the plugin has responded to the request itself so neither
+			// the remaining plugins nor the upstream service will see it
+			m.Add(metrics.Metric{Name: "short_circuit_plugin", Value: plugin.Name, Index: true})
+			*resp = *normalizeResponse(response, r)
+			break
+		}
 	}
 	return nil
 }
 
-func doOnRequest(ctx context.Context, m *metrics.Metrics, name string, proxy spec.APIProxy, req *http.Request, span opentracing.Span, p plugins.Plugin) (e error) {
+// normalizeResponse fills in the fields of a plugin supplied response that
+// the remaining steps rely on being set.
+func normalizeResponse(resp *http.Response, req *http.Request) *http.Response {
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	if resp.Status == "" {
+		resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	if resp.Body == nil {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(nil))
+	}
+	if resp.Request == nil {
+		resp.Request = req
+	}
+	return resp
+}
+
+// isShortCircuited reports whether a plugin has already responded to the
+// request, in which case the upstream service should not be called. The
+// response is empty until then and a plugin supplied response always has
+// a status code once it has been normalized.
+func isShortCircuited(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode != 0
+}
+
+func doOnRequest(ctx context.Context, m *metrics.Metrics, plugin spec.Plugin, proxy spec.APIProxy, req *http.Request, span opentracing.Span, p plugins.Plugin) (response *http.Response, e error) {
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Errorf("OnRequest paniced: %v", r)
+			response = nil
 			e = errors.New("OnRequest paniced")
 		}
 	}()
 
-	sp := opentracing.StartSpan(fmt.Sprintf("PLUGIN: ON_REQUEST: %s", name), opentracing.ChildOf(span.Context()))
-	defer sp.Finish()
+	// the plugin may still be running after it is abandoned so its span
+	// is started and finished by the call itself rather than this function
+	parent := span.Context()
 
-	return p.OnRequest(ctx, m, proxy, req, sp)
+	// the plugin may still be running if it timed out so its response
+	// is handed over on a channel that is only read once it is done
+	responses := make(chan *http.Response, 1)
+	e = plugins.Invoke(ctx, m, plugin, plugins.OnRequestHook, req, nil, func(ctx context.Context, m *metrics.Metrics, req *http.Request, _ *http.Response) error {
+		sp := opentracing.StartSpan(fmt.Sprintf("PLUGIN: ON_REQUEST: %s", plugin.Name), opentracing.ChildOf(parent))
+		defer sp.Finish()
+		if responder, ok := p.(plugins.ResponderPlugin); ok {
+			result, err := responder.OnRequestWithResult(ctx, m, proxy, plugin.Config, req, sp)
+			if err == nil && result != nil && result.Response != nil {
+				responses <- result.Response
+			}
+			return err
+		}
+		if configurable, ok := p.(plugins.ConfigurablePlugin); ok {
+			return configurable.OnRequestWithConfig(ctx, m, proxy, plugin.Config, req, sp)
+		}
+		return p.OnRequest(ctx, m, proxy, req, sp)
+	})
+	if e != nil {
+		return nil, e
+	}
+	select {
+	case response = <-responses:
+	default:
+	}
+	return response, nil
 }
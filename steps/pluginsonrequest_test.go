@@ -22,10 +22,18 @@ package steps
 
 import (
 	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/plugins"
 	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -35,8 +43,117 @@ func TestPluginsOnRequestGetName(t *testing.T) {
 	assert.Equal(step.GetName(), "Plugin OnRequest", "step name is incorrect")
 }
 
+func TestPluginsOnRequestFailedPlugin(t *testing.T) {
+	proxy := &spec.APIProxy{
+		Spec: spec.APIProxySpec{
+			Plugins: []spec.Plugin{{Name: "missing"}},
+		},
+		Status: spec.APIProxyStatus{
+			Plugins: []spec.PluginStatus{{Name: "missing", Error: "not found"}},
+		},
+	}
+	err := PluginsOnRequestStep{}.Do(context.Background(), proxy, nil, nil, nil, nil, opentracing.StartSpan("test span"))
+	assert.Equal(t, "plugin missing is unavailable: not found", err.Error())
+	assert.Equal(t, http.StatusServiceUnavailable, err.(utils.StatusError).Status())
+}
+
 func TestDoOnRequest(t *testing.T) {
-	assert.Equal(t, doOnRequest(context.Background(), nil, "name", spec.APIProxy{}, nil, opentracing.StartSpan("test span"), fakePanicPlugin{}).Error(), "OnRequest paniced")
-	assert.Equal(t, doOnRequest(context.Background(), nil, "name", spec.APIProxy{}, nil, opentracing.StartSpan("test span"), fakeErrorPlugin{}).Error(), "error")
-	assert.Nil(t, doOnRequest(context.Background(), nil, "name", spec.APIProxy{}, nil, opentracing.StartSpan("test span"), fakeSuccessPlugin{}))
+	_, err := doOnRequest(context.Background(), nil, spec.Plugin{Name: "name"}, spec.APIProxy{}, nil, opentracing.StartSpan("test span"), fakePanicPlugin{})
+	assert.Equal(t, err.Error(), "OnRequest paniced")
+	_, err = doOnRequest(context.Background(), nil, spec.Plugin{Name: "name"}, spec.APIProxy{}, nil, opentracing.StartSpan("test span"), fakeErrorPlugin{})
+	assert.Equal(t, err.Error(), "error")
+	resp, err := doOnRequest(context.Background(), nil, spec.Plugin{Name: "name"}, spec.APIProxy{}, nil, opentracing.StartSpan("test span"), fakeSuccessPlugin{})
+	assert.Nil(t, err)
+	assert.Nil(t, resp)
+	_, err = doOnRequest(context.Background(), nil, spec.Plugin{Name: "name", Config: json.RawMessage(`{"foo":"bar"}`)}, spec.APIProxy{}, nil, opentracing.StartSpan("test span"), fakeConfigurablePlugin{})
+	assert.Equal(t, err.Error(), `request config {"foo":"bar"}`)
+	resp, err = doOnRequest(context.Background(), nil, spec.Plugin{Name: "name"}, spec.APIProxy{}, nil, opentracing.StartSpan("test span"), fakeResponderPlugin{})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+}
+
+func TestPluginsOnRequestShortCircuit(t *testing.T) {
+	plugins.Register("responder", "", func() plugins.Plugin { return fakeResponderPlugin{} })
+	plugins.Register("unreachable", "", func() plugins.Plugin { return fakePanicPlugin{} })
+	defer plugins.Registry.Clear()
+
+	proxy := &spec.APIProxy{
+		Spec: spec.APIProxySpec{
+			Plugins: []spec.Plugin{{Name: "responder"}, {Name: "unreachable"}},
+		},
+	}
+	req, _ := http.NewRequest("GET", "http://foo.bar.com/foo", nil)
+	m := &metrics.Metrics{}
+	resp := &http.Response{}
+
+	assert.Nil(t, PluginsOnRequestStep{}.Do(context.Background(), proxy, m, nil, req, resp, opentracing.StartSpan("test span")))
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	assert.Equal(t, "418 I'm a teapot", resp.Status)
+	assert.Equal(t, http.Header{}, resp.Header)
+	assert.Equal(t, req, resp.Request)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "short and stout", string(body))
+	assert.Equal(t, "responder", m.Get("short_circuit_plugin").Value)
+
+	// the upstream service should not be called once a plugin has responded
+	assert.Nil(t, ProxyPassStep{}.Do(context.Background(), proxy, m, nil, req, resp, opentracing.StartSpan("test span")))
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+}
+
+func TestNormalizeResponse(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://foo.bar.com/foo", nil)
+	resp := normalizeResponse(&http.Response{}, req)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "200 OK", resp.Status)
+	assert.Equal(t, http.Header{}, resp.Header)
+	assert.NotNil(t, resp.Body)
+	assert.Equal(t, req, resp.Request)
+
+	resp = normalizeResponse(&http.Response{StatusCode: http.StatusFound, Status: "302 Moved", Header: http.Header{"Location": []string{"/bar"}}}, req)
+	assert.Equal(t, "302 Moved", resp.Status)
+	assert.Equal(t, "/bar", resp.Header.Get("Location"))
+}
+
+func TestIsShortCircuited(t *testing.T) {
+	assert.False(t, isShortCircuited(nil))
+	assert.False(t, isShortCircuited(&http.Response{}))
+	assert.True(t, isShortCircuited(&http.Response{StatusCode: http.StatusOK}))
+}
+
+type fakeBlockingPlugin struct {
+	block chan struct{}
+	span  chan opentracing.Span
+}
+
+func (plugin fakeBlockingPlugin) OnRequest(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, span opentracing.Span) error {
+	<-plugin.block
+	span.SetTag("returned", true)
+	plugin.span <- span
+	return nil
+}
+
+func (plugin fakeBlockingPlugin) OnResponse(ctx context.Context, m *metrics.Metrics, p spec.APIProxy, r *http.Request, resp *http.Response, span opentracing.Span) error {
+	return nil
+}
+
+func TestDoOnRequestAbandonedSpan(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	plugin := fakeBlockingPlugin{block: make(chan struct{}), span: make(chan opentracing.Span, 1)}
+	parent := tracer.StartSpan("test span")
+	_, err := doOnRequest(context.Background(), nil, spec.Plugin{Name: "blocking", Timeout: "10ms"}, spec.APIProxy{}, nil, parent, plugin)
+	assert.Equal(t, http.StatusGatewayTimeout, err.(utils.StatusError).Status())
+	parent.Finish()
+	assert.Equal(t, 1, len(tracer.FinishedSpans()), "the span of an abandoned plugin should not be finished while it runs")
+
+	close(plugin.block)
+	span := (<-plugin.span).(*mocktracer.MockSpan)
+	for len(tracer.FinishedSpans()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, "PLUGIN: ON_REQUEST: blocking", span.OperationName)
+	assert.Equal(t, true, span.Tag("returned"))
+	assert.Equal(t, parent.Context().(mocktracer.MockSpanContext).SpanID, span.ParentID)
 }
@@ -45,12 +45,12 @@ func (step PluginsOnResponseStep) GetName() string {
 // Do executes the logic of the PluginsOnResponseStep step
 func (step PluginsOnResponseStep) Do(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metrics, w http.ResponseWriter, r *http.Request, resp *http.Response, trace opentracing.Span) error {
 
-	for _, plugin := range proxy.Spec.Plugins {
-		p, err := plugins.GetPlugin(plugin)
+	for _, plugin := range proxy.GetPlugins() {
+		p, err := plugins.Registry.Get(plugin)
 		if err != nil {
 			return err
 		}
-		if err := doOnResponse(ctx, m, plugin.Name, *proxy, r, resp, trace, *p); err != nil {
+		if err := doOnResponse(ctx, m, plugin, *proxy, r, resp, trace, p); err != nil {
 			return err
 		}
 	}
@@ -58,7 +58,7 @@ func (step PluginsOnResponseStep) Do(ctx context.Context, proxy *spec.APIProxy,
 	return nil
 }
 
-func doOnResponse(ctx context.Context, m *metrics.Metrics, name string, proxy spec.APIProxy, req *http.Request, resp *http.Response, span opentracing.Span, p plugins.Plugin) (e error) {
+func doOnResponse(ctx context.Context, m *metrics.Metrics, plugin spec.Plugin, proxy spec.APIProxy, req *http.Request, resp *http.Response, span opentracing.Span, p plugins.Plugin) (e error) {
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Errorf("OnResponse paniced: %v", r)
@@ -66,8 +66,16 @@ func doOnResponse(ctx context.Context, m *metrics.Metrics, name string, proxy sp
 		}
 	}()
 
-	sp := opentracing.StartSpan(fmt.Sprintf("PLUGIN: ON_RESPONSE: %s", name), opentracing.ChildOf(span.Context()))
-	defer sp.Finish()
+	// the plugin may still be running after it is abandoned so its span
+	// is started and finished by the call itself rather than this function
+	parent := span.Context()
 
-	return p.OnResponse(ctx, m, proxy, req, resp, sp)
+	return plugins.Invoke(ctx, m, plugin, plugins.OnResponseHook, req, resp, func(ctx context.Context, m *metrics.Metrics, req *http.Request, resp *http.Response) error {
+		sp := opentracing.StartSpan(fmt.Sprintf("PLUGIN: ON_RESPONSE: %s", plugin.Name), opentracing.ChildOf(parent))
+		defer sp.Finish()
+		if configurable, ok := p.(plugins.ConfigurablePlugin); ok {
+			return configurable.OnResponseWithConfig(ctx, m, proxy, plugin.Config, req, resp, sp)
+		}
+		return p.OnResponse(ctx, m, proxy, req, resp, sp)
+	})
 }
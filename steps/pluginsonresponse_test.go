@@ -22,6 +22,7 @@ package steps
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 
 	"github.com/northwesternmutual/kanali/spec"
@@ -35,7 +36,8 @@ func TestPluginsOnResponseGetName(t *testing.T) {
 }
 
 func TestDoOnResponse(t *testing.T) {
-	assert.Equal(t, doOnResponse(context.Background(), nil, "name", spec.APIProxy{}, nil, nil, opentracing.StartSpan("test span"), fakePanicPlugin{}).Error(), "OnResponse paniced")
-	assert.Equal(t, doOnResponse(context.Background(), nil, "name", spec.APIProxy{}, nil, nil, opentracing.StartSpan("test span"), fakeErrorPlugin{}).Error(), "error")
-	assert.Nil(t, doOnResponse(context.Background(), nil, "name", spec.APIProxy{}, nil, nil, opentracing.StartSpan("test span"), fakeSuccessPlugin{}))
+	assert.Equal(t, doOnResponse(context.Background(), nil, spec.Plugin{Name: "name"}, spec.APIProxy{}, nil, nil, opentracing.StartSpan("test span"), fakePanicPlugin{}).Error(), "OnResponse paniced")
+	assert.Equal(t, doOnResponse(context.Background(), nil, spec.Plugin{Name: "name"}, spec.APIProxy{}, nil, nil, opentracing.StartSpan("test span"), fakeErrorPlugin{}).Error(), "error")
+	assert.Nil(t, doOnResponse(context.Background(), nil, spec.Plugin{Name: "name"}, spec.APIProxy{}, nil, nil, opentracing.StartSpan("test span"), fakeSuccessPlugin{}))
+	assert.Equal(t, doOnResponse(context.Background(), nil, spec.Plugin{Name: "name", Config: json.RawMessage(`{"foo":"bar"}`)}, spec.APIProxy{}, nil, nil, opentracing.StartSpan("test span"), fakeConfigurablePlugin{}).Error(), `response config {"foo":"bar"}`)
 }
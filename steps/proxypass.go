@@ -57,6 +57,10 @@ func (step ProxyPassStep) GetName() string {
 // Do executes the logic of the ProxyPassStep step
 func (step ProxyPassStep) Do(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metrics, w http.ResponseWriter, r *http.Request, resp *http.Response, span opentracing.Span) error {
 
+	if isShortCircuited(resp) {
+		return nil
+	}
+
 	targetRequest, err := createTargetRequest(proxy, r)
 	if err != nil {
 		return err
@@ -67,10 +71,18 @@ func (step ProxyPassStep) Do(ctx context.Context, proxy *spec.APIProxy, m *metri
 		return err
 	}
 
+	release, err := acquireConcurrency(ctx, proxy, m)
+	if err != nil {
+		return err
+	}
+
+	t0 := time.Now()
 	targetResponse, err := preformTargetProxy(targetClient, targetRequest, m, span)
 	if err != nil {
+		release(time.Now().Sub(t0))
 		return err
 	}
+	targetResponse.Body = releaseBody(targetResponse.Body, release, time.Now().Sub(t0))
 
 	*resp = *targetResponse
 	return nil
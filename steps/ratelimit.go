@@ -0,0 +1,125 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/tracer"
+	"github.com/northwesternmutual/kanali/traffic"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	rateLimitSourceClientIP  = "clientIP"
+	rateLimitSourceHeader    = "header"
+	rateLimitSourcePathParam = "pathParam"
+	rateLimitSourceClaim     = "claim"
+)
+
+// RateLimitStep is factory that defines a step responsible for enforcing
+// the rate limit and quota that an APIProxy defines for every request
+type RateLimitStep struct{}
+
+// GetName retruns the name of the RateLimitStep step
+func (step RateLimitStep) GetName() string {
+	return "Rate Limit"
+}
+
+// Do executes the logic of the RateLimitStep step
+func (step RateLimitStep) Do(ctx context.Context, proxy *spec.APIProxy, m *metrics.Metrics, w http.ResponseWriter, r *http.Request, resp *http.Response, trace opentracing.Span) error {
+
+	if proxy.Spec.RateLimit == nil {
+		return nil
+	}
+
+	keyName, err := getRateLimitKeyName(proxy, r)
+	if err != nil {
+		return utils.StatusError{Code: http.StatusInternalServerError, Err: err}
+	}
+
+	trace.SetTag(tracer.KanaliRateLimitKey, keyName)
+
+	if spec.TrafficStore.IsQuotaExceeded(proxy.ObjectMeta.Namespace, proxy.ObjectMeta.Name, keyName, proxy.Spec.RateLimit.Quota) {
+		m.Add(metrics.Metric{Name: "rate_limit_violated", Value: "quota", Index: true})
+		return utils.StatusError{Code: http.StatusTooManyRequests, Err: errors.New("quota limit exceeded")}
+	}
+
+	if spec.TrafficStore.IsRateExceeded(proxy.ObjectMeta.Namespace, proxy.ObjectMeta.Name, keyName, proxy.Spec.RateLimit.Rate, time.Now()) {
+		m.Add(metrics.Metric{Name: "rate_limit_violated", Value: "rate", Index: true})
+		return utils.StatusError{Code: http.StatusTooManyRequests, Err: errors.New("rate limit exceeded")}
+	}
+
+	traffic.Emit(proxy.ObjectMeta.Namespace, proxy.ObjectMeta.Name, keyName)
+
+	return nil
+
+}
+
+// getRateLimitKeyName derives the key that traffic will be tracked against.
+// The derived value is hashed so that it is safe to send to other Kanali
+// instances regardless of its content or length.
+func getRateLimitKeyName(proxy *spec.APIProxy, r *http.Request) (string, error) {
+	var value string
+
+	switch key := proxy.Spec.RateLimit.Key; key.Source {
+	case rateLimitSourceClientIP, "":
+		value = getClientIP(r)
+	case rateLimitSourceHeader:
+		value = r.Header.Get(key.Name)
+	case rateLimitSourcePathParam:
+		value = getPathSegment(proxy.Spec.Path, utils.ComputeURLPath(r.URL), key.Segment)
+	case rateLimitSourceClaim:
+		if proxy.Spec.JWT == nil {
+			return "", errors.New("rate limit key source claim requires jwt validation")
+		}
+		if claims, ok := getVerifiedClaims(r); ok {
+			value = claims.String(key.Name)
+		}
+	default:
+		return "", fmt.Errorf("unsupported rate limit key source %s", key.Source)
+	}
+
+	// traffic for a rate limit key is tracked alongside api key traffic
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%s", proxy.Spec.RateLimit.Key.Source, value)))
+	return spec.BucketName("", "ratelimit", hex.EncodeToString(sum[:16])), nil
+}
+
+// getPathSegment returns the segment at the given zero-based index
+// of the part of the request path following the proxy path
+func getPathSegment(proxyPath, requestPath string, index int) string {
+	remainder := strings.TrimPrefix(requestPath, utils.NormalizeURLPath(proxyPath))
+	segments := strings.Split(strings.Trim(remainder, "/"), "/")
+	if index < 0 || index >= len(segments) {
+		return ""
+	}
+	return segments[index]
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestRateLimitGetName(t *testing.T) {
+	assert := assert.New(t)
+	step := RateLimitStep{}
+	assert.Equal(step.GetName(), "Rate Limit", "step name is incorrect")
+}
+
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	step := RateLimitStep{}
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	u, _ := url.Parse("https://www.foo.bar.com/api/v1/accounts")
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path: "/api/v1/accounts",
+		},
+	}
+
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: u, RemoteAddr: "10.0.0.1:1234"}, nil, opentracing.StartSpan("test span")), "no rate limit should be enforced")
+	assert.True(spec.TrafficStore.IsEmpty(), "no traffic should be recorded")
+
+	proxy.Spec.RateLimit = &spec.RateLimit{
+		Quota: 2,
+	}
+
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: u, RemoteAddr: "10.0.0.1:1234"}, nil, opentracing.StartSpan("test span")))
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: u, RemoteAddr: "10.0.0.1:1234"}, nil, opentracing.StartSpan("test span")))
+	assert.Equal(utils.StatusError{Code: http.StatusTooManyRequests, Err: errors.New("quota limit exceeded")}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: u, RemoteAddr: "10.0.0.1:1234"}, nil, opentracing.StartSpan("test span")))
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: u, RemoteAddr: "10.0.0.2:1234"}, nil, opentracing.StartSpan("test span")), "a different client should have its own quota")
+
+	proxy.Spec.RateLimit = &spec.RateLimit{
+		Key: spec.RateLimitKey{
+			Source: "header",
+			Name:   "X-Tenant",
+		},
+		Rate: &spec.Rate{
+			Amount: 1,
+			Unit:   "minute",
+		},
+	}
+
+	m := &metrics.Metrics{}
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: u, Header: http.Header{"X-Tenant": []string{"one"}}}, nil, opentracing.StartSpan("test span")))
+	assert.Equal(utils.StatusError{Code: http.StatusTooManyRequests, Err: errors.New("rate limit exceeded")}, step.Do(context.Background(), proxy, m, nil, &http.Request{URL: u, Header: http.Header{"X-Tenant": []string{"one"}}}, nil, opentracing.StartSpan("test span")))
+	assert.Equal("rate", m.Get("rate_limit_violated").Value)
+	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: u, Header: http.Header{"X-Tenant": []string{"two"}}}, nil, opentracing.StartSpan("test span")))
+
+	proxy.Spec.RateLimit.Key.Source = "foo"
+	assert.Equal(utils.StatusError{Code: http.StatusInternalServerError, Err: errors.New("unsupported rate limit key source foo")}, step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: u}, nil, opentracing.StartSpan("test span")))
+}
+
+func TestGetRateLimitKeyName(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Reset()
+
+	proxy := &spec.APIProxy{
+		Spec: spec.APIProxySpec{
+			Path: "/api/v1/accounts",
+			RateLimit: &spec.RateLimit{
+				Key: spec.RateLimitKey{
+					Source:  "pathParam",
+					Segment: 0,
+				},
+			},
+		},
+	}
+	u1, _ := url.Parse("https://www.foo.bar.com/api/v1/accounts/123/details")
+	u2, _ := url.Parse("https://www.foo.bar.com/api/v1/accounts/456/details")
+	u3, _ := url.Parse("https://www.foo.bar.com/api/v1/accounts/123")
+	k1, _ := getRateLimitKeyName(proxy, &http.Request{URL: u1})
+	k2, _ := getRateLimitKeyName(proxy, &http.Request{URL: u2})
+	k3, _ := getRateLimitKeyName(proxy, &http.Request{URL: u3})
+	assert.NotEqual(k1, k2)
+	assert.Equal(k1, k3)
+	assert.Equal(43, len(k1))
+
+	proxy.Spec.RateLimit.Key = spec.RateLimitKey{Source: "clientIP"}
+	viper.Set(config.FlagServerTrustedProxies.GetLong(), []string{"10.0.0.0/8"})
+	k1, _ = getRateLimitKeyName(proxy, &http.Request{URL: u1, RemoteAddr: "10.0.0.1:1234", Header: http.Header{"X-Forwarded-For": []string{"1.2.3.4"}}})
+	k2, _ = getRateLimitKeyName(proxy, &http.Request{URL: u1, RemoteAddr: "10.0.0.2:1234", Header: http.Header{"X-Forwarded-For": []string{"1.2.3.4"}}})
+	k3, _ = getRateLimitKeyName(proxy, &http.Request{URL: u1, RemoteAddr: "10.0.0.2:1234"})
+	assert.Equal(k1, k2, "client ip should be taken from a trusted X-Forwarded-For header")
+	assert.NotEqual(k1, k3)
+
+	proxy.Spec.RateLimit.Key = spec.RateLimitKey{Source: "claim", Name: "sub"}
+	_, err := getRateLimitKeyName(proxy, &http.Request{URL: u1})
+	assert.Equal("rate limit key source claim requires jwt validation", err.Error())
+}
+
+func TestGetRateLimitKeyNameClaim(t *testing.T) {
+	assert := assert.New(t)
+	spec.SecretStore.Clear()
+	defer spec.SecretStore.Clear()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	spec.SecretStore.Set(api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "jwks",
+			Namespace: "foo",
+		},
+		Data: map[string][]byte{
+			"jwks.json": encodeTestJWKS("one", &key.PublicKey),
+		},
+	})
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:      "/api/v1/accounts",
+			JWT:       &spec.JWT{JWKSSecret: "jwks"},
+			RateLimit: &spec.RateLimit{Key: spec.RateLimitKey{Source: "claim", Name: "sub"}},
+		},
+	}
+
+	// the JWT step verifies the token before the rate limit step runs
+	keyName := func(token string) string {
+		u, _ := url.Parse("https://www.foo.bar.com/api/v1/accounts")
+		r := &http.Request{URL: u, Header: http.Header{}}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		JWTStep{}.Do(context.Background(), proxy, &metrics.Metrics{}, nil, r, nil, opentracing.StartSpan("test span"))
+		name, _ := getRateLimitKeyName(proxy, r)
+		return name
+	}
+	claims := func(sub string) map[string]interface{} {
+		return map[string]interface{}{"sub": sub, "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	frank := keyName(signTestJWT(key, "one", claims("frank")))
+	john := keyName(signTestJWT(key, "one", claims("john")))
+	forged := keyName(signTestJWT(otherKey, "one", claims("mallory")))
+	anonymous := keyName("")
+	assert.NotEqual(frank, john)
+	assert.Equal(anonymous, forged, "claims of a token that can not be verified should not be trusted")
+	assert.NotEqual(frank, anonymous)
+}
+
+func TestGetPathSegment(t *testing.T) {
+	assert.Equal(t, "123", getPathSegment("/api/v1/accounts", "/api/v1/accounts/123/details", 0))
+	assert.Equal(t, "details", getPathSegment("/api/v1/accounts/", "/api/v1/accounts/123/details", 1))
+	assert.Equal(t, "", getPathSegment("/api/v1/accounts", "/api/v1/accounts/123/details", 2))
+	assert.Equal(t, "", getPathSegment("/api/v1/accounts", "/api/v1/accounts", 0))
+	assert.Equal(t, "", getPathSegment("/api/v1/accounts", "/api/v1/accounts/123", -1))
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/signing"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/traffic"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+)
+
+var (
+	errSignatureHeadersMissing = errors.New("request signature does not include every required header")
+	errSignatureInvalid        = errors.New("request signature is not valid")
+	errSignatureReplayed       = errors.New("request signature nonce has already been used")
+)
+
+// authorizeSignedRequest authenticates a request signed on behalf of an
+// APIKey and authorizes it using the same binding as the key itself
+func authorizeSignedRequest(proxy *spec.APIProxy, m *metrics.Metrics, r *http.Request, trace opentracing.Span) error {
+	auth, err := signing.ParseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: err}
+	}
+
+	if !auth.HasHeaders(proxy.Spec.APIKeyAuth.Signature.Headers) {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: errSignatureHeadersMissing}
+	}
+
+	currTime := time.Now()
+	skew := viper.GetDuration(config.FlagPluginsAPIKeySignatureClockSkew.GetLong())
+	timestamp, err := signing.CheckTimestamp(r, currTime, skew)
+	if err != nil {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: err}
+	}
+
+	stringToSign, err := signing.StringToSign(r, auth.Headers, int64(viper.GetInt(config.FlagPluginsAPIKeySignatureMaxBodySize.GetLong())))
+	if err == signing.ErrBodyTooLarge {
+		return utils.StatusError{Code: http.StatusRequestEntityTooLarge, Err: err}
+	}
+	if err != nil {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: err}
+	}
+
+	var key *spec.APIKey
+	for _, candidate := range spec.KeyStore.GetByName(auth.KeyID) {
+		if len(candidate.Spec.SigningKey) > 0 && signing.Verify(candidate.Spec.SigningKey, stringToSign, auth.Signature) {
+			key = &candidate
+			break
+		}
+	}
+	if key == nil {
+		m.Add(
+			metrics.Metric{Name: "apikey_name", Value: "unknown", Index: true},
+			metrics.Metric{Name: "apikey_namespace", Value: "unknown", Index: true},
+		)
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: errSignatureInvalid}
+	}
+
+	// the nonce is only recorded once the signature is verified so that
+	// unauthenticated clients can not use up the nonces of others. It is
+	// hashed so that it fits in the message that shares it with peers.
+	nonce := sha256.Sum256([]byte(auth.KeyID + "/" + r.Header.Get(signing.NonceHeader)))
+	if !traffic.UseNonce(hex.EncodeToString(nonce[:]), timestamp.Add(skew), currTime) {
+		return utils.StatusError{Code: http.StatusUnauthorized, Err: errSignatureReplayed}
+	}
+
+	return authorizeAPIKey(proxy, m, r, *key, key.CheckValidity(currTime), trace)
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package steps
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/metrics"
+	"github.com/northwesternmutual/kanali/signing"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/northwesternmutual/kanali/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestAPIKeySignedRequest(t *testing.T) {
+	assert := assert.New(t)
+	step := APIKeyStep{}
+	viper.Set(config.FlagPluginsAPIKeySignatureClockSkew.GetLong(), "0h5m0s")
+	defer viper.Set(config.FlagPluginsAPIKeySignatureClockSkew.GetLong(), "")
+	spec.KeyStore.Clear()
+	spec.BindingStore.Clear()
+	spec.TrafficStore.Clear()
+	defer spec.KeyStore.Clear()
+	defer spec.BindingStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	signingKey := spec.DeriveSigningKey([]byte("abc123"))
+
+	proxy := &spec.APIProxy{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "exampleAPIProxyOne",
+			Namespace: "foo",
+		},
+		Spec: spec.APIProxySpec{
+			Path:   "/api/v1/accounts",
+			Target: "/",
+			APIKeyAuth: &spec.APIKeyAuth{
+				Signature: &spec.RequestSignature{
+					Headers: []string{"host"},
+				},
+			},
+		},
+	}
+
+	newRequest := func(method, rawURL, body string) *http.Request {
+		u, _ := url.Parse(rawURL)
+		return &http.Request{Method: method, URL: u, Host: u.Host, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewBufferString(body))}
+	}
+
+	newSignedRequest := func(method, rawURL, body, keyID string, key []byte, headers []string, nonce string, signedAt time.Time) *http.Request {
+		r := newRequest(method, rawURL, body)
+		signing.SignRequest(r, keyID, key, headers, nonce, signedAt)
+		return r
+	}
+
+	do := func(r *http.Request) error {
+		return step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, r, nil, opentracing.StartSpan("test span"))
+	}
+
+	now := time.Now()
+
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errSignatureInvalid}, do(newSignedRequest("GET", "https://foo.bar.com/api/v1/accounts", "", "keyone", signingKey, []string{"host"}, "1", now)))
+
+	spec.KeyStore.Set(spec.APIKey{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "keyone",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeySpec{
+			APIKeyData: spec.HashAPIKey("abc123"),
+			SigningKey: signingKey,
+		},
+	})
+	spec.BindingStore.Set(spec.APIKeyBinding{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "bindingone",
+			Namespace: "foo",
+		},
+		Spec: spec.APIKeyBindingSpec{
+			APIProxyName: "exampleAPIProxyOne",
+			Keys: []spec.Key{
+				{
+					Name: "keyone",
+					DefaultRule: spec.Rule{
+						Granular: &spec.GranularProxy{
+							Verbs: []string{"POST"},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	m := &metrics.Metrics{}
+	r := newSignedRequest("POST", "https://foo.bar.com/api/v1/accounts", "hello", "keyone", signingKey, []string{"host"}, "2", now)
+	assert.Nil(step.Do(context.Background(), proxy, m, nil, r, nil, opentracing.StartSpan("test span")))
+	assert.Equal("keyone", m.Get("apikey_name").Value)
+	body, _ := ioutil.ReadAll(r.Body)
+	assert.Equal("hello", string(body), "the body should still be readable")
+
+	r = newSignedRequest("POST", "https://foo.bar.com/api/v1/accounts", "hello", "keyone", signingKey, []string{"host"}, "3", now)
+	assert.Nil(do(r))
+	r.Body = ioutil.NopCloser(bytes.NewBufferString("hello"))
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errSignatureReplayed}, do(r))
+
+	r = newSignedRequest("POST", "https://foo.bar.com/api/v1/accounts", "hello", "keyone", signingKey, []string{"host"}, "4", now)
+	r.Body = ioutil.NopCloser(bytes.NewBufferString("goodbye"))
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errSignatureInvalid}, do(r))
+
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errSignatureInvalid}, do(newSignedRequest("POST", "https://foo.bar.com/api/v1/accounts", "", "keyone", spec.DeriveSigningKey([]byte("def456")), []string{"host"}, "5", now)))
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errSignatureHeadersMissing}, do(newSignedRequest("POST", "https://foo.bar.com/api/v1/accounts", "", "keyone", signingKey, nil, "6", now)))
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: signing.ErrTimestamp}, do(newSignedRequest("POST", "https://foo.bar.com/api/v1/accounts", "", "keyone", signingKey, []string{"host"}, "7", now.Add(-10*time.Minute))))
+	assert.Equal(utils.StatusError{Code: http.StatusForbidden, Err: errAPIKeyUnauthorized}, do(newSignedRequest("GET", "https://foo.bar.com/api/v1/accounts", "", "keyone", signingKey, []string{"host"}, "8", now)))
+
+	viper.Set(config.FlagPluginsAPIKeySignatureMaxBodySize.GetLong(), 4)
+	assert.Equal(utils.StatusError{Code: http.StatusRequestEntityTooLarge, Err: signing.ErrBodyTooLarge}, do(newSignedRequest("POST", "https://foo.bar.com/api/v1/accounts", "hello", "keyone", signingKey, []string{"host"}, "10", now)))
+	viper.Set(config.FlagPluginsAPIKeySignatureMaxBodySize.GetLong(), 0)
+
+	proxy.Spec.APIKeyAuth.Signature = nil
+	assert.Equal(utils.StatusError{Code: http.StatusUnauthorized, Err: errAPIKeyMissing}, do(newSignedRequest("POST", "https://foo.bar.com/api/v1/accounts", "", "keyone", signingKey, []string{"host"}, "9", now)), "signed requests should not be accepted")
+}
@@ -60,9 +60,11 @@ func TestValidateProxy(t *testing.T) {
 	proxy := &spec.APIProxy{}
 
 	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: urlOne}, nil, opentracing.StartSpan("test span")), "expected proxy to be found")
-	assert.Equal(*proxy, proxyList.Proxies[0])
+	assert.Equal(proxyList.Proxies[0].ObjectMeta, proxy.ObjectMeta)
+	assert.Equal(proxyList.Proxies[0].Spec, proxy.Spec)
 	assert.Nil(step.Do(context.Background(), proxy, &metrics.Metrics{}, nil, &http.Request{URL: urlTwo}, nil, opentracing.StartSpan("test span")), "expected proxy to be found")
-	assert.Equal(*proxy, proxyList.Proxies[1])
+	assert.Equal(proxyList.Proxies[1].ObjectMeta, proxy.ObjectMeta)
+	assert.Equal(proxyList.Proxies[1].Spec, proxy.Spec)
 	assert.Equal(utils.StatusError{Code: http.StatusNotFound, Err: errors.New("proxy not found")}, step.Do(context.Background(), nil, &metrics.Metrics{}, nil, &http.Request{URL: urlThree}, nil, opentracing.StartSpan("test span")), "expected proxy to not exist")
 	assert.Equal(utils.StatusError{Code: http.StatusNotFound, Err: errors.New("proxy not found")}, step.Do(context.Background(), nil, &metrics.Metrics{}, nil, &http.Request{URL: urlFour}, nil, opentracing.StartSpan("test span")), "expected proxy to not exist")
 }
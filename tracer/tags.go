@@ -28,6 +28,16 @@ const (
 	KanaliProxyName = "kanali.proxy.name"
 	// KanaliProxyNamespace is the opentracing tag name that represents an APIProxy namespace
 	KanaliProxyNamespace = "kanali.proxy.namespace"
+	// KanaliRateLimitKey is the opentracing tag name that represents the key an APIProxy rate limit is tracked against
+	KanaliRateLimitKey = "kanali.rate_limit.key"
+	// KanaliAPIKeyName is the opentracing tag name that represents an APIKey name
+	KanaliAPIKeyName = "kanali.apikey.name"
+	// KanaliAPIKeyNamespace is the opentracing tag name that represents an APIKey namespace
+	KanaliAPIKeyNamespace = "kanali.apikey.namespace"
+	// KanaliJWTSubject is the opentracing tag name that represents the subject of a JSON Web Token
+	KanaliJWTSubject = "kanali.jwt.subject"
+	// KanaliClientIdentity is the opentracing tag name that represents the identity of a verified client certificate
+	KanaliClientIdentity = "kanali.client.identity"
 
 	// HTTPRequest is the opentracing tag name that represents the existence on an HTTP request
 	HTTPRequest = "http.request"
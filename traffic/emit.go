@@ -0,0 +1,110 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package traffic
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/spec"
+)
+
+// Emit records a traffic point locally and sends it to all other Kanali
+// instances. The traffic point is always recorded locally, even if no
+// peers are discovered.
+func Emit(namespace, proxyName, keyName string) {
+
+	gram := EncodeKanaliGram(namespace, proxyName, keyName, ",")
+
+	if err := spec.TrafficStore.Set(gram); err != nil {
+		logrus.Errorf("could not add traffic point to store: %s", err.Error())
+	}
+
+	broadcast(gram)
+
+}
+
+// broadcast sends a gram to every other Kanali instance
+func broadcast(gram string) {
+
+	peers, err := getPeers()
+	if err != nil {
+		logrus.Warnf("could not discover peers: %s", err.Error())
+		return
+	}
+
+	selfAddr := getSelfAddr()
+
+	for _, addr := range peers {
+
+		if isSelf(addr, selfAddr) {
+			continue
+		}
+
+		go send(addr, gram)
+
+	}
+
+}
+
+// EmitLimits records a traffic point against the bucket of every given limit
+func EmitLimits(namespace, proxyName string, limits []spec.Limit) {
+	for _, limit := range limits {
+		Emit(namespace, proxyName, limit.Bucket)
+	}
+}
+
+func send(addr, gram string) {
+
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		logrus.Warnf("error resolving UDP address for %s", addr)
+		return
+	}
+
+	conn, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		logrus.Warnf("error dialing %s", addr)
+		return
+	}
+
+	if _, err := conn.Write([]byte(gram)); err != nil {
+		logrus.Warnf("error writing traffic to %s", addr)
+	}
+
+	if err := conn.Close(); err != nil {
+		logrus.Error(err.Error())
+	}
+
+}
+
+// EncodeKanaliGram encodes a traffic point into the format
+// that Kanali instances use to communicate with each other
+func EncodeKanaliGram(nSpace, pName, keyName, delimiter string) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(nSpace)
+	buffer.WriteString(delimiter)
+	buffer.WriteString(pName)
+	buffer.WriteString(delimiter)
+	buffer.WriteString(keyName)
+	return buffer.String()
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package traffic
+
+import (
+	"testing"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmit(t *testing.T) {
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+	defer viper.Reset()
+	spec.TrafficStore.Clear()
+	spec.KanaliEndpoints.Clear()
+	defer spec.TrafficStore.Clear()
+
+	Emit("foo", "bar", "car")
+	assert.False(t, spec.TrafficStore.IsEmpty(), "traffic should be recorded locally without any peers")
+}
+
+func TestEmitLimits(t *testing.T) {
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+	defer viper.Reset()
+	spec.TrafficStore.Clear()
+	spec.KanaliEndpoints.Clear()
+	defer spec.TrafficStore.Clear()
+
+	EmitLimits("foo", "bar", []spec.Limit{{Bucket: "car"}, {Bucket: "car_abc"}})
+	assert.True(t, spec.TrafficStore.IsQuotaExceeded("foo", "bar", "car", 1))
+	assert.True(t, spec.TrafficStore.IsQuotaExceeded("foo", "bar", "car_abc", 1))
+}
+
+func TestEncodeKanaliGram(t *testing.T) {
+	assert.Equal(t, "foo,bar,car", EncodeKanaliGram("foo", "bar", "car", ","))
+}
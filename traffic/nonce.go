@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package traffic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/northwesternmutual/kanali/signing"
+	"github.com/northwesternmutual/kanali/spec"
+)
+
+// nonceGramPrefix marks a gram that carries the nonce of a signed request
+// rather than a traffic point. A Kubernetes namespace can not contain a '#'
+// so a nonce gram can never be mistaken for a traffic point.
+const nonceGramPrefix = "#nonce,"
+
+// Nonces holds the nonces of recently verified signed requests
+// used on this and every other Kanali instance
+var Nonces = signing.NewNonceCache()
+
+// UseNonce records the nonce of a verified signed request and reports
+// whether it was unused. A nonce that was unused is sent to every other
+// Kanali instance so that the request can not be replayed to them. Peers
+// receive the nonce asynchronously, so a replay that reaches another
+// instance before the nonce does is not detected. The nonce must be
+// short enough for the gram to fit in a UDP message, such as a hash.
+func UseNonce(nonce string, expires, currTime time.Time) bool {
+	if !Nonces.Use(nonce, expires, currTime) {
+		return false
+	}
+	broadcast(encodeNonceGram(nonce, expires))
+	return true
+}
+
+// Receive records a gram sent by another Kanali instance,
+// which is either a traffic point or a nonce
+func Receive(gram string, currTime time.Time) error {
+	if !strings.HasPrefix(gram, nonceGramPrefix) {
+		return spec.TrafficStore.Set(gram)
+	}
+	nonce, expires, err := decodeNonceGram(gram)
+	if err != nil {
+		return err
+	}
+	Nonces.Use(nonce, expires, currTime)
+	return nil
+}
+
+func encodeNonceGram(nonce string, expires time.Time) string {
+	return fmt.Sprintf("%s%d,%s", nonceGramPrefix, expires.UnixNano(), nonce)
+}
+
+func decodeNonceGram(gram string) (string, time.Time, error) {
+	parts := strings.SplitN(strings.TrimPrefix(gram, nonceGramPrefix), ",", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", time.Time{}, fmt.Errorf("malformed nonce gram %s", gram)
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("malformed nonce gram %s", gram)
+	}
+	return parts[1], time.Unix(0, expires), nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package traffic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUseNonce(t *testing.T) {
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+	defer viper.Reset()
+	spec.KanaliEndpoints.Clear()
+
+	currTime := time.Now()
+	assert.True(t, UseNonce("use-nonce", currTime.Add(time.Minute), currTime))
+	assert.False(t, UseNonce("use-nonce", currTime.Add(time.Minute), currTime), "a nonce should only be used once")
+}
+
+func TestReceive(t *testing.T) {
+	assert := assert.New(t)
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	currTime := time.Now()
+	assert.Nil(Receive(encodeNonceGram("receive-nonce", currTime.Add(time.Minute)), currTime))
+	assert.False(Nonces.Use("receive-nonce", currTime.Add(time.Minute), currTime), "a nonce used on a peer should not be usable")
+	assert.True(spec.TrafficStore.IsEmpty(), "a nonce is not a traffic point")
+
+	assert.Nil(Receive("foo,bar,car", currTime))
+	assert.True(spec.TrafficStore.IsQuotaExceeded("foo", "bar", "car", 1))
+
+	assert.Equal("malformed nonce gram #nonce,foo", Receive("#nonce,foo", currTime).Error())
+	assert.Equal("malformed nonce gram #nonce,foo,bar", Receive("#nonce,foo,bar", currTime).Error())
+}
+
+func TestNonceGram(t *testing.T) {
+	expires := time.Unix(1500000000, 5)
+	gram := encodeNonceGram("foo,bar", expires)
+	assert.Equal(t, "#nonce,1500000000000000005,foo,bar", gram)
+	nonce, decoded, err := decodeNonceGram(gram)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo,bar", nonce)
+	assert.True(t, expires.Equal(decoded))
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package traffic
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+const (
+	peerDiscoveryEndpoints = "endpoints"
+	peerDiscoveryStatic    = "static"
+	peerDiscoveryDNS       = "dns"
+)
+
+// these are variables so that they can be mocked in tests
+var (
+	lookupSRV  = net.LookupSRV
+	lookupHost = net.LookupHost
+)
+
+type dnsPeerCache struct {
+	mutex   sync.Mutex
+	record  string
+	addrs   []string
+	expires time.Time
+}
+
+var dnsPeers = &dnsPeerCache{}
+
+type hostPeerCache struct {
+	mutex   sync.Mutex
+	entries map[string]hostPeerCacheEntry
+}
+
+type hostPeerCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+var staticPeers = &hostPeerCache{entries: map[string]hostPeerCacheEntry{}}
+
+// peerList holds the peers most recently discovered in the background
+type peerList struct {
+	mutex sync.RWMutex
+	addrs []string
+	err   error
+}
+
+var discoveredPeers = &peerList{}
+
+// getPeers returns the host:port address of every running Kanali
+// instance using the configured peer discovery mechanism. Peers that
+// require DNS lookups are discovered in the background by
+// RunPeerDiscovery so that emitting traffic never waits on a resolver.
+func getPeers() ([]string, error) {
+	if mode := viper.GetString(config.FlagServerPeerDiscovery.GetLong()); mode == peerDiscoveryEndpoints || mode == "" {
+		return spec.KanaliEndpoints.Addresses(), nil
+	}
+	discoveredPeers.mutex.RLock()
+	defer discoveredPeers.mutex.RUnlock()
+	return discoveredPeers.addrs, discoveredPeers.err
+}
+
+// RunPeerDiscovery discovers the peers of this Kanali instance
+// immediately and then every peer DNS refresh interval
+func RunPeerDiscovery() {
+	refreshPeers(time.Now())
+	interval := viper.GetDuration(config.FlagServerPeerDNSRefreshInterval.GetLong())
+	if interval <= 0 {
+		logrus.Warn("peers will not be rediscovered as the peer dns refresh interval is not positive")
+		return
+	}
+	for range time.Tick(interval) {
+		refreshPeers(time.Now())
+	}
+}
+
+func refreshPeers(currTime time.Time) {
+	addrs, err := discoverPeers(currTime)
+	discoveredPeers.mutex.Lock()
+	defer discoveredPeers.mutex.Unlock()
+	discoveredPeers.addrs = addrs
+	discoveredPeers.err = err
+}
+
+func discoverPeers(currTime time.Time) ([]string, error) {
+	ttl := viper.GetDuration(config.FlagServerPeerDNSRefreshInterval.GetLong())
+	switch mode := viper.GetString(config.FlagServerPeerDiscovery.GetLong()); mode {
+	case peerDiscoveryEndpoints, "":
+		return nil, nil
+	case peerDiscoveryStatic:
+		return staticPeers.get(getStaticPeers(viper.GetStringSlice(config.FlagServerPeerStaticAddresses.GetLong())), ttl, currTime), nil
+	case peerDiscoveryDNS:
+		return dnsPeers.get(viper.GetString(config.FlagServerPeerDNSSRV.GetLong()), ttl, currTime)
+	default:
+		return nil, fmt.Errorf("unsupported peer discovery mechanism %s", mode)
+	}
+}
+
+func getStaticPeers(addrs []string) []string {
+	seen := map[string]bool{}
+	peers := []string{}
+	for _, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, strconv.Itoa(viper.GetInt(config.FlagServerPeerUDPPort.GetLong())))
+		}
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		peers = append(peers, addr)
+	}
+	return peers
+}
+
+func (c *dnsPeerCache) get(record string, ttl time.Duration, currTime time.Time) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if record == "" {
+		return nil, fmt.Errorf("peer discovery mechanism %s requires --%s", peerDiscoveryDNS, config.FlagServerPeerDNSSRV.GetLong())
+	}
+
+	if c.record == record && currTime.Before(c.expires) {
+		return c.addrs, nil
+	}
+
+	_, srvs, err := lookupSRV("", "", record)
+	if err != nil {
+		// serve stale peers rather than none at all
+		if c.record == record && c.addrs != nil {
+			return c.addrs, nil
+		}
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	addrs := []string{}
+	for _, srv := range srvs {
+		hosts, err := lookupHost(srv.Target)
+		if err != nil {
+			continue
+		}
+		for _, host := range hosts {
+			addr := net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	c.record = record
+	c.addrs = addrs
+	c.expires = currTime.Add(ttl)
+
+	return addrs, nil
+}
+
+// get resolves the host name of every peer address to its IP addresses so
+// that this Kanali instance can recognise itself among its peers. Addresses
+// that are already IP addresses are returned as is. Peers whose host name
+// can not be resolved are skipped.
+func (c *hostPeerCache) get(addrs []string, ttl time.Duration, currTime time.Time) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	seen := map[string]bool{}
+	peers := []string{}
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		resolved := []string{addr}
+		if net.ParseIP(host) == nil {
+			entry, ok := c.entries[host]
+			if !ok || !currTime.Before(entry.expires) {
+				ips, err := lookupHost(host)
+				if err != nil {
+					logrus.Warnf("could not resolve peer %s: %s", host, err.Error())
+					// serve stale addresses rather than none at all
+					if !ok {
+						continue
+					}
+					ips = entry.addrs
+				}
+				entry = hostPeerCacheEntry{addrs: ips, expires: currTime.Add(ttl)}
+				c.entries[host] = entry
+			}
+			resolved = []string{}
+			for _, ip := range entry.addrs {
+				resolved = append(resolved, net.JoinHostPort(ip, port))
+			}
+		}
+		for _, peer := range resolved {
+			if !seen[peer] {
+				seen[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+	return peers
+}
+
+// ValidateSelfIP reports whether the IP address of this Kanali instance can
+// be determined. Without it, Kanali can not recognise itself among its peers
+// and would count its own traffic twice.
+func ValidateSelfIP() error {
+	ip := getSelfIP()
+	if ip == "" {
+		return fmt.Errorf("could not determine the IP address of this Kanali instance. set --%s or the POD_IP environment variable", config.FlagServerPeerSelfIP.GetLong())
+	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("%s is not a valid IP address for this Kanali instance", ip)
+	}
+	return nil
+}
+
+// getSelfIP returns the IP address of this Kanali instance
+func getSelfIP() string {
+	if ip := viper.GetString(config.FlagServerPeerSelfIP.GetLong()); ip != "" {
+		return ip
+	}
+	return os.Getenv("POD_IP")
+}
+
+// getSelfAddr returns the host:port address that this
+// Kanali instance receives traffic from its peers on
+func getSelfAddr() string {
+	ip := getSelfIP()
+	if ip == "" {
+		return ""
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(viper.GetInt(config.FlagServerPeerUDPPort.GetLong())))
+}
+
+// isSelf reports whether a peer address is this Kanali instance. The port
+// is compared as well so that instances sharing an IP address, such as
+// those using the host network, do not skip each other.
+func isSelf(addr, selfAddr string) bool {
+	return selfAddr != "" && addr == selfAddr
+}
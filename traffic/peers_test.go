@@ -0,0 +1,207 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package traffic
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestGetPeers(t *testing.T) {
+	assert := assert.New(t)
+	viper.SetDefault(config.FlagServerPeerUDPPort.GetLong(), 10001)
+	viper.SetDefault(config.FlagServerPeerEndpointsName.GetLong(), "kanali")
+	defer viper.Reset()
+	defer spec.KanaliEndpoints.Clear()
+
+	spec.KanaliEndpoints.Clear()
+	peers, err := getPeers()
+	assert.Nil(err)
+	assert.Equal([]string{}, peers, "no endpoints should not panic")
+
+	spec.KanaliEndpoints.Set(api.Endpoints{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "kanali",
+			Namespace: "default",
+		},
+		Subsets: []api.EndpointSubset{
+			{Addresses: []api.EndpointAddress{{IP: "10.0.0.1"}}},
+		},
+	})
+	peers, err = getPeers()
+	assert.Nil(err)
+	assert.Equal([]string{"10.0.0.1:10001"}, peers)
+
+	viper.Set(config.FlagServerPeerDiscovery.GetLong(), "static")
+	viper.Set(config.FlagServerPeerStaticAddresses.GetLong(), []string{"10.0.0.5", "10.0.0.6:20001", "10.0.0.5:10001", ""})
+	peers, err = getPeers()
+	assert.Nil(err)
+	assert.Equal(0, len(peers), "peers should only be discovered in the background")
+	refreshPeers(time.Now())
+	peers, err = getPeers()
+	assert.Nil(err)
+	assert.Equal([]string{"10.0.0.5:10001", "10.0.0.6:20001"}, peers)
+
+	viper.Set(config.FlagServerPeerDiscovery.GetLong(), "foo")
+	refreshPeers(time.Now())
+	_, err = getPeers()
+	assert.Equal("unsupported peer discovery mechanism foo", err.Error())
+	refreshPeers(time.Now())
+}
+
+func TestDNSPeerCache(t *testing.T) {
+	assert := assert.New(t)
+	defer func() {
+		lookupSRV = net.LookupSRV
+		lookupHost = net.LookupHost
+	}()
+
+	calls := 0
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		calls++
+		if calls > 2 {
+			return "", nil, errors.New("dns failure")
+		}
+		return "", []*net.SRV{
+			{Target: "kanali-0.kanali.default.svc.cluster.local.", Port: 10001},
+			{Target: "kanali-1.kanali.default.svc.cluster.local.", Port: 10001},
+			{Target: "unknown.", Port: 10001},
+		}, nil
+	}
+	lookupHost = func(host string) ([]string, error) {
+		switch host {
+		case "kanali-0.kanali.default.svc.cluster.local.":
+			return []string{"10.0.0.2"}, nil
+		case "kanali-1.kanali.default.svc.cluster.local.":
+			return []string{"10.0.0.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	c := &dnsPeerCache{}
+	now := time.Now()
+
+	_, err := c.get("", time.Minute, now)
+	assert.NotNil(err, "empty record should return an error")
+
+	peers, err := c.get("_peer._udp.kanali.default.svc.cluster.local", time.Minute, now)
+	assert.Nil(err)
+	assert.Equal([]string{"10.0.0.1:10001", "10.0.0.2:10001"}, peers)
+
+	// cached
+	_, err = c.get("_peer._udp.kanali.default.svc.cluster.local", time.Minute, now.Add(time.Second))
+	assert.Nil(err)
+	assert.Equal(1, calls)
+
+	// expired
+	_, err = c.get("_peer._udp.kanali.default.svc.cluster.local", time.Minute, now.Add(2*time.Minute))
+	assert.Nil(err)
+	assert.Equal(2, calls)
+
+	// stale peers are served when resolution fails
+	peers, err = c.get("_peer._udp.kanali.default.svc.cluster.local", time.Minute, now.Add(4*time.Minute))
+	assert.Nil(err)
+	assert.Equal([]string{"10.0.0.1:10001", "10.0.0.2:10001"}, peers)
+
+	_, err = c.get("_other._udp.kanali.default.svc.cluster.local", time.Minute, now.Add(4*time.Minute))
+	assert.NotNil(err)
+}
+
+func TestHostPeerCache(t *testing.T) {
+	assert := assert.New(t)
+	defer func() {
+		lookupHost = net.LookupHost
+	}()
+
+	calls := 0
+	lookupHost = func(host string) ([]string, error) {
+		calls++
+		if calls > 2 {
+			return nil, errors.New("dns failure")
+		}
+		switch host {
+		case "kanali-0.example.com":
+			return []string{"10.0.0.2"}, nil
+		case "kanali-1.example.com":
+			return []string{"10.0.0.1", "10.0.0.3"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	c := &hostPeerCache{entries: map[string]hostPeerCacheEntry{}}
+	now := time.Now()
+
+	peers := c.get([]string{"10.0.0.1:10001", "kanali-0.example.com:10001", "kanali-1.example.com:10001"}, time.Minute, now)
+	assert.Equal([]string{"10.0.0.1:10001", "10.0.0.2:10001", "10.0.0.3:10001"}, peers, "host names should be resolved so that the self IP can be detected")
+	assert.Equal(2, calls)
+
+	// cached
+	c.get([]string{"kanali-0.example.com:10001"}, time.Minute, now.Add(time.Second))
+	assert.Equal(2, calls)
+
+	// stale addresses are served when resolution fails
+	peers = c.get([]string{"kanali-0.example.com:10001", "unknown.example.com:10001"}, time.Minute, now.Add(2*time.Minute))
+	assert.Equal([]string{"10.0.0.2:10001"}, peers)
+}
+
+func TestValidateSelfIP(t *testing.T) {
+	defer viper.Reset()
+	defer os.Setenv("POD_IP", os.Getenv("POD_IP"))
+	os.Setenv("POD_IP", "")
+
+	assert.Equal(t, "could not determine the IP address of this Kanali instance. set --server.peer_self_ip or the POD_IP environment variable", ValidateSelfIP().Error())
+	viper.Set(config.FlagServerPeerSelfIP.GetLong(), "kanali-0")
+	assert.Equal(t, "kanali-0 is not a valid IP address for this Kanali instance", ValidateSelfIP().Error())
+	viper.Set(config.FlagServerPeerSelfIP.GetLong(), "10.0.0.9")
+	assert.Nil(t, ValidateSelfIP())
+}
+
+func TestIsSelf(t *testing.T) {
+	assert.True(t, isSelf("10.0.0.1:10001", "10.0.0.1:10001"))
+	assert.False(t, isSelf("10.0.0.1:10002", "10.0.0.1:10001"), "instances sharing an IP address should not skip each other")
+	assert.False(t, isSelf("10.0.0.2:10001", "10.0.0.1:10001"))
+	assert.False(t, isSelf("10.0.0.1:10001", ""))
+}
+
+func TestGetSelfAddr(t *testing.T) {
+	defer viper.Reset()
+	defer os.Setenv("POD_IP", os.Getenv("POD_IP"))
+	os.Setenv("POD_IP", "")
+	assert.Equal(t, "", getSelfAddr())
+	viper.Set(config.FlagServerPeerSelfIP.GetLong(), "10.0.0.9")
+	viper.Set(config.FlagServerPeerUDPPort.GetLong(), 10001)
+	assert.Equal(t, "10.0.0.9:10001", getSelfAddr())
+}
+
+func TestGetSelfIP(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(config.FlagServerPeerSelfIP.GetLong(), "10.0.0.9")
+	assert.Equal(t, "10.0.0.9", getSelfIP())
+}
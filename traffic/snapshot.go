@@ -0,0 +1,207 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package traffic
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+)
+
+// SnapshotStore persists traffic snapshots across restarts
+type SnapshotStore interface {
+	// Load returns the most recently saved snapshot of every
+	// Kanali instance, indexed by the name it was saved under
+	Load() (map[string][]byte, error)
+	// Save persists the snapshot of this Kanali instance
+	Save(data []byte) error
+	// Remove deletes a snapshot saved under the given name
+	Remove(name string) error
+}
+
+// snapshot is a traffic snapshot along with the time it was saved
+type snapshot struct {
+	SavedAt time.Time `json:"savedAt"`
+	spec.TrafficSnapshot
+}
+
+// FileSnapshotStore persists traffic snapshots to a local file
+type FileSnapshotStore struct {
+	Path string
+}
+
+// Load reads the snapshot file
+func (s FileSnapshotStore) Load() (map[string][]byte, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{filepath.Base(s.Path): data}, nil
+}
+
+// Save atomically replaces the snapshot file
+func (s FileSnapshotStore) Save(data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// Remove deletes the snapshot file if it has the given name
+func (s FileSnapshotStore) Remove(name string) error {
+	if name != filepath.Base(s.Path) {
+		return nil
+	}
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SaveSnapshot persists a snapshot of the traffic store
+func SaveSnapshot(store SnapshotStore) error {
+	data, err := json.Marshal(snapshot{
+		SavedAt:         time.Now(),
+		TrafficSnapshot: spec.TrafficStore.Snapshot(),
+	})
+	if err != nil {
+		return err
+	}
+	return store.Save(data)
+}
+
+// RestoreSnapshot merges the most recently saved snapshot of every Kanali
+// instance into the traffic store. Traffic recorded at or after since is
+// skipped as it will have already been received from peers. Once restored,
+// the traffic is saved as the snapshot of this instance and the snapshots
+// saved before since are removed, as any instance that is still running
+// saves its snapshot again on its next interval.
+func RestoreSnapshot(store SnapshotStore, since time.Time) error {
+	snapshots, err := loadSnapshots(store)
+	if err != nil || len(snapshots) < 1 {
+		return err
+	}
+	merged := spec.NewTrafficSnapshot()
+	for _, s := range snapshots {
+		merged.Merge(s.TrafficSnapshot)
+	}
+	spec.TrafficStore.Restore(merged, since)
+	if err := SaveSnapshot(store); err != nil {
+		return err
+	}
+	if snapshots, err = loadSnapshots(store); err != nil {
+		return err
+	}
+	for name, s := range snapshots {
+		if s.SavedAt.Before(since) {
+			if err := store.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSnapshots decodes every saved snapshot. A snapshot that can not be
+// decoded is skipped so that it does not prevent the others from loading.
+func loadSnapshots(store SnapshotStore) (map[string]snapshot, error) {
+	data, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make(map[string]snapshot, len(data))
+	for name, d := range data {
+		if len(d) < 1 {
+			continue
+		}
+		s := snapshot{}
+		if err := json.Unmarshal(d, &s); err != nil {
+			logrus.Warnf("could not decode traffic snapshot %s: %s", name, err.Error())
+			continue
+		}
+		snapshots[name] = s
+	}
+	return snapshots, nil
+}
+
+// RunSnapshots persists a snapshot of the traffic store on every interval
+// until the stop channel is closed. Snapshots are disabled if the interval
+// is not positive.
+func RunSnapshots(store SnapshotStore, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		logrus.Warnf("periodic traffic snapshots are disabled as the interval %s is not positive", interval)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := SaveSnapshot(store); err != nil {
+				logrus.Errorf("could not save traffic snapshot: %s", err.Error())
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RunPruning prunes traffic older than the retention period from the
+// traffic store on every interval until the stop channel is closed.
+// Pruning is disabled if either the interval or the retention period
+// is not positive.
+func RunPruning(interval time.Duration, stop <-chan struct{}) {
+	retention := viper.GetDuration(config.FlagServerTrafficRetention.GetLong())
+	if interval <= 0 || retention <= 0 {
+		logrus.Warnf("traffic will not be pruned as either the interval %s or the retention %s is not positive", interval, retention)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case currTime := <-ticker.C:
+			spec.TrafficStore.Prune(currTime.Add(-retention))
+		case <-stop:
+			return
+		}
+	}
+}
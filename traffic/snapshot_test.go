@@ -0,0 +1,204 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package traffic
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/northwesternmutual/kanali/config"
+	"github.com/northwesternmutual/kanali/spec"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSnapshotStore struct {
+	data map[string][]byte
+	err  error
+}
+
+func (s *mockSnapshotStore) Load() (map[string][]byte, error) {
+	return s.data, s.err
+}
+
+func (s *mockSnapshotStore) Save(data []byte) error {
+	if s.data == nil {
+		s.data = map[string][]byte{}
+	}
+	s.data["self"] = data
+	return s.err
+}
+
+func (s *mockSnapshotStore) Remove(name string) error {
+	delete(s.data, name)
+	return s.err
+}
+
+func TestFileSnapshotStore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "kanali")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	store := FileSnapshotStore{Path: filepath.Join(dir, "traffic.json")}
+
+	data, err := store.Load()
+	assert.Nil(err, "a missing snapshot file is not an error")
+	assert.Nil(data)
+
+	assert.Nil(store.Save([]byte("one")))
+	assert.Nil(store.Save([]byte("two")))
+	data, err = store.Load()
+	assert.Nil(err)
+	assert.Equal(map[string][]byte{"traffic.json": []byte("two")}, data)
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(1, len(files), "temporary files should not be left behind")
+
+	assert.Nil(store.Remove("other.json"))
+	files, _ = ioutil.ReadDir(dir)
+	assert.Equal(1, len(files))
+	assert.Nil(store.Remove("traffic.json"))
+	files, _ = ioutil.ReadDir(dir)
+	assert.Equal(0, len(files))
+}
+
+func TestSaveRestoreSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	store := &mockSnapshotStore{}
+
+	assert.Nil(RestoreSnapshot(store, time.Now()), "an empty snapshot should be ignored")
+	assert.True(spec.TrafficStore.IsEmpty())
+
+	assert.Nil(spec.TrafficStore.Set("foo,bar,car"))
+	assert.Nil(SaveSnapshot(store))
+	spec.TrafficStore.Clear()
+
+	assert.Nil(RestoreSnapshot(store, time.Now().Add(time.Second)))
+	assert.True(spec.TrafficStore.IsQuotaExceeded("foo", "bar", "car", 1))
+
+	store.data = map[string][]byte{"self": []byte("foo")}
+	assert.Nil(RestoreSnapshot(store, time.Now()), "a snapshot that can not be decoded should be skipped")
+
+	store.err = errors.New("unavailable")
+	assert.Equal(store.err, RestoreSnapshot(store, time.Now()))
+	assert.Equal(store.err, SaveSnapshot(store))
+}
+
+func TestRestoreSnapshots(t *testing.T) {
+	assert := assert.New(t)
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	since := time.Now()
+	point := since.Add(-time.Minute).Unix()
+
+	encode := func(savedAt time.Time, count, pruned int) []byte {
+		s := snapshot{SavedAt: savedAt, TrafficSnapshot: spec.NewTrafficSnapshot()}
+		s.Counts["foo"] = map[string]map[string]map[int64]int{"bar": {"car": {point: count}}}
+		s.Pruned["foo"] = map[string]map[string]int{"bar": {"car": pruned}}
+		data, _ := json.Marshal(s)
+		return data
+	}
+
+	store := &mockSnapshotStore{data: map[string][]byte{
+		"one": encode(since.Add(-time.Hour), 2, 5),
+		"two": encode(since.Add(-time.Second), 3, 4),
+	}}
+
+	assert.Nil(RestoreSnapshot(store, since))
+	assert.Equal(3, spec.TrafficStore.Count("foo", "bar", "car", since.Add(-time.Hour)), "instances share their traffic so the largest count should be restored")
+	assert.True(spec.TrafficStore.IsQuotaExceeded("foo", "bar", "car", 8))
+	assert.False(spec.TrafficStore.IsQuotaExceeded("foo", "bar", "car", 9))
+
+	_, ok := store.data["self"]
+	assert.True(ok, "restored traffic should be saved")
+	assert.Equal(1, len(store.data), "snapshots saved before the restore should be removed")
+}
+
+func TestRunSnapshots(t *testing.T) {
+	assert := assert.New(t)
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+
+	store := &mockSnapshotStore{}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		RunSnapshots(store, time.Millisecond, stop)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	<-done
+	s := snapshot{}
+	assert.Nil(json.Unmarshal(store.data["self"], &s))
+	assert.Equal(0, len(s.Counts))
+	assert.False(s.SavedAt.IsZero())
+
+	store.data = nil
+	RunSnapshots(store, 0, nil)
+	RunSnapshots(store, -time.Second, nil)
+	assert.Nil(store.data, "snapshots should be disabled")
+}
+
+func TestRunPruning(t *testing.T) {
+	assert := assert.New(t)
+	spec.TrafficStore.Clear()
+	defer spec.TrafficStore.Clear()
+	defer viper.Reset()
+
+	store := &mockSnapshotStore{}
+
+	assert.Nil(spec.TrafficStore.Set("foo,bar,car"))
+	viper.Set(config.FlagServerTrafficRetention.GetLong(), time.Nanosecond)
+	RunPruning(0, nil)
+	assert.False(spec.TrafficStore.IsEmpty(), "pruning should be disabled")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunPruning(time.Millisecond, stop)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	<-done
+	assert.True(spec.TrafficStore.IsEmpty())
+
+	assert.Nil(SaveSnapshot(store))
+	spec.TrafficStore.Clear()
+
+	assert.Nil(RestoreSnapshot(store, time.Now()))
+	assert.True(spec.TrafficStore.IsQuotaExceeded("foo", "bar", "car", 1), "pruned traffic should count towards quotas")
+	assert.False(spec.TrafficStore.IsQuotaExceeded("foo", "bar", "car", 2))
+}
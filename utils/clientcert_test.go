@@ -0,0 +1,90 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestCertificate(t *testing.T, commonName string, dnsNames []string, uris []string) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	names := []asn1.RawValue{}
+	for _, name := range dnsNames {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte(name)})
+	}
+	names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 7, Bytes: net.ParseIP("10.0.0.1").To4()})
+	for _, uri := range uris {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(uri)})
+	}
+	sans, _ := asn1.Marshal(names)
+
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: commonName},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidExtensionSubjectAltName, Value: sans}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestGetClientCertificate(t *testing.T) {
+	cert := createTestCertificate(t, "frank", nil, nil)
+
+	assert.Nil(t, GetClientCertificate(&http.Request{}))
+	assert.Nil(t, GetClientCertificate(&http.Request{TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}))
+	assert.Equal(t, cert, GetClientCertificate(&http.Request{TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}))
+}
+
+func TestGetClientIdentity(t *testing.T) {
+	assert.Equal(t, "frank", GetClientIdentity(createTestCertificate(t, "frank", []string{"frank.example.com"}, nil)))
+	assert.Equal(t, "spiffe://example.com/ns/foo/sa/bar", GetClientIdentity(createTestCertificate(t, "frank", nil, []string{"spiffe://example.com/ns/foo/sa/bar"})))
+}
+
+func TestGetSANs(t *testing.T) {
+	cert := createTestCertificate(t, "frank", []string{"frank.example.com"}, []string{"spiffe://example.com/ns/foo/sa/bar", "https://example.com"})
+
+	assert.Equal(t, []string{"spiffe://example.com/ns/foo/sa/bar", "https://example.com"}, GetURISANs(cert))
+	assert.Equal(t, []string{"frank.example.com", "10.0.0.1", "spiffe://example.com/ns/foo/sa/bar", "https://example.com"}, GetSANs(cert))
+	assert.Equal(t, []string{}, GetURISANs(&x509.Certificate{}))
+}
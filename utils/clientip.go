@@ -0,0 +1,119 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ComputeClientIP returns the IP address of the client that originated a request.
+// If the immediate peer is a trusted proxy, the X-Forwarded-For header is walked
+// from right to left and the first untrusted address is returned. Trusted proxies
+// may be given either as IP addresses or CIDR ranges. If the PROXY protocol is
+// enabled, the remote address of the request will already reflect the client.
+func ComputeClientIP(r *http.Request, trustedProxies []string) string {
+	remoteIP := stripPort(r.RemoteAddr)
+
+	trusted := ParseNetworks(trustedProxies)
+	if !ContainsIP(trusted, remoteIP) {
+		return remoteIP
+	}
+
+	hops := []string{}
+	for _, header := range r.Header[http.CanonicalHeaderKey("X-Forwarded-For")] {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, stripPort(hop))
+			}
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			// a malformed hop can not be trusted any further
+			return remoteIP
+		}
+		if !ContainsIP(trusted, hops[i]) {
+			return hops[i]
+		}
+		remoteIP = hops[i]
+	}
+
+	return remoteIP
+}
+
+// ParseNetworks parses a list of IP addresses and CIDR ranges.
+// Entries that can not be parsed are ignored.
+func ParseNetworks(networks []string) []*net.IPNet {
+	result := []*net.IPNet{}
+	for _, network := range networks {
+		if n, err := ParseNetwork(network); err == nil {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// ParseNetwork parses an IP address or CIDR range. An IP
+// address is parsed as a range containing only itself.
+func ParseNetwork(network string) (*net.IPNet, error) {
+	network = strings.TrimSpace(network)
+	if !strings.Contains(network, "/") {
+		ip := net.ParseIP(network)
+		if ip == nil {
+			return nil, fmt.Errorf("%s is not an IP address or CIDR range", network)
+		}
+		if ip.To4() != nil {
+			network = network + "/32"
+		} else {
+			network = network + "/128"
+		}
+	}
+	_, n, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an IP address or CIDR range", network)
+	}
+	return n, nil
+}
+
+// ContainsIP reports whether an IP address is in any of the given networks
+func ContainsIP(networks []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
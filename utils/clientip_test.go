@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Northwestern Mutual.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "192.168.1.1"}
+
+	assert.Equal(t, "1.2.3.4", ComputeClientIP(&http.Request{RemoteAddr: "1.2.3.4:5678"}, nil))
+	assert.Equal(t, "1.2.3.4", ComputeClientIP(&http.Request{RemoteAddr: "1.2.3.4"}, nil))
+	assert.Equal(t, "1.2.3.4", ComputeClientIP(&http.Request{
+		RemoteAddr: "1.2.3.4:5678",
+		Header:     http.Header{"X-Forwarded-For": []string{"5.6.7.8"}},
+	}, trusted), "untrusted peers can not spoof their address")
+	assert.Equal(t, "5.6.7.8", ComputeClientIP(&http.Request{
+		RemoteAddr: "10.1.2.3:5678",
+		Header:     http.Header{"X-Forwarded-For": []string{"9.9.9.9, 5.6.7.8, 192.168.1.1"}},
+	}, trusted))
+	assert.Equal(t, "5.6.7.8", ComputeClientIP(&http.Request{
+		RemoteAddr: "10.1.2.3:5678",
+		Header:     http.Header{"X-Forwarded-For": []string{"9.9.9.9", "5.6.7.8"}},
+	}, trusted))
+	assert.Equal(t, "192.168.1.1", ComputeClientIP(&http.Request{
+		RemoteAddr: "10.1.2.3:5678",
+		Header:     http.Header{"X-Forwarded-For": []string{"192.168.1.1"}},
+	}, trusted), "the last trusted hop is used when all hops are trusted")
+	assert.Equal(t, "10.1.2.3", ComputeClientIP(&http.Request{
+		RemoteAddr: "10.1.2.3:5678",
+		Header:     http.Header{"X-Forwarded-For": []string{"garbage"}},
+	}, trusted))
+	assert.Equal(t, "10.1.2.3", ComputeClientIP(&http.Request{RemoteAddr: "10.1.2.3:5678"}, trusted))
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks := ParseNetworks([]string{"10.0.0.0/8", "1.2.3.4", "::1", "foo", "300.0.0.0/8"})
+	assert.Equal(t, 3, len(networks))
+	assert.True(t, ContainsIP(networks, "10.2.3.4"))
+	assert.True(t, ContainsIP(networks, "1.2.3.4"))
+	assert.True(t, ContainsIP(networks, "::1"))
+	assert.False(t, ContainsIP(networks, "1.2.3.5"))
+	assert.False(t, ContainsIP(networks, "foo"))
+}
+
+func TestParseNetwork(t *testing.T) {
+	n, err := ParseNetwork(" 1.2.3.4 ")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.4/32", n.String())
+	n, err = ParseNetwork("2001:db8::/32")
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8::/32", n.String())
+	_, err = ParseNetwork("foo")
+	assert.Equal(t, "foo is not an IP address or CIDR range", err.Error())
+	_, err = ParseNetwork("10.0.0.0/33")
+	assert.Equal(t, "10.0.0.0/33 is not an IP address or CIDR range", err.Error())
+}